@@ -18,8 +18,12 @@
 
 package main
 
-import "github.com/IBM/kar.git/core/internal/runtime"
+import (
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/runtime"
+)
 
 func main() {
+	config.Configure()
 	runtime.Main()
 }
@@ -52,12 +52,39 @@ const (
 	PurgeCmd = "purge"
 	// DrainCmd is the command "drain"
 	DrainCmd = "drain"
+	// MigrateCmd is the command "migrate"
+	MigrateCmd = "migrate"
+	// DeadLettersCmd is the command "deadletters"
+	DeadLettersCmd = "deadletters"
+	// ExportCmd is the command "export"
+	ExportCmd = "export"
+	// ImportCmd is the command "import"
+	ImportCmd = "import"
 	// VersionCmd is the command "version"
 	VersionCmd = "version"
 	// HelpCmd is the command "help"
 	HelpCmd = "help"
 )
 
+const (
+	// TransportKafka selects the Kafka message transport
+	TransportKafka = "kafka"
+	// TransportMemory selects the in-memory message transport (single sidecar)
+	TransportMemory = "memory"
+
+	// StoreRedis selects the Redis state backend
+	StoreRedis = "redis"
+	// StoreMemory selects the embedded state backend (single sidecar)
+	StoreMemory = "memory"
+
+	// TraceExporterNone disables span export
+	TraceExporterNone = "none"
+	// TraceExporterOTLP exports spans to an OTLP/HTTP endpoint
+	TraceExporterOTLP = "otlp"
+	// TraceExporterFile exports spans to a file
+	TraceExporterFile = "file"
+)
+
 var (
 	// CmdName is the top-level command to be executed (purge, run, invoke, etc)
 	CmdName string
@@ -80,6 +107,63 @@ var (
 	// ActorReminderAcceptableDelay controls the threshold at which reminders are logged as being late
 	ActorReminderAcceptableDelay time.Duration
 
+	// ActorPlacement maps actor types to placement strategies [random|hash|least-loaded|colocate:TYPE]
+	ActorPlacement map[string]string
+
+	// ActorRebalancePolicy is the policy used to rebalance idle actor placements when sidecars join [none|even]
+	ActorRebalancePolicy string
+
+	// ActorRebalanceLimit is the maximum number of actor placements moved by a sidecar on each rebalance
+	ActorRebalanceLimit int
+
+	// ActorIdleTTL maps actor types to the idle time after which actor instances are deleted
+	ActorIdleTTL map[string]time.Duration
+
+	// ActorIdleSweepInterval is the interval at which idle actors are deleted
+	ActorIdleSweepInterval time.Duration
+
+	// ActorMaxInFlight maps actor types to the maximum number of messages for the type processed concurrently
+	ActorMaxInFlight map[string]int
+
+	// StatelessWorkers maps stateless worker actor types to the maximum number of concurrent sessions per actor instance
+	StatelessWorkers map[string]int
+
+	// MaxInFlight is the maximum number of messages processed concurrently by the sidecar (0 is unbounded)
+	MaxInFlight int
+
+	// PromiseTTL is the time to live of the results of promise calls in the store
+	PromiseTTL time.Duration
+
+	// BreakerFailures is the number of consecutive failures that opens a circuit breaker (0 to disable)
+	BreakerFailures int
+
+	// BreakerOpenDuration is the time a circuit breaker stays open before letting a trial request through
+	BreakerOpenDuration time.Duration
+
+	// AppMaxInFlight bounds the number of concurrent requests to the application process (0 is unbounded)
+	AppMaxInFlight int
+
+	// IdempotencyRetention is the time during which the replies to requests with idempotency keys are retained
+	IdempotencyRetention time.Duration
+
+	// DeadLetterTopic is the application topic receiving undeliverable asynchronous invocations
+	DeadLetterTopic string
+
+	// TraceExporter selects the span exporter
+	TraceExporter string
+
+	// TraceEndpoint is the OTLP/HTTP endpoint receiving spans
+	TraceEndpoint string
+
+	// TraceFile is the file receiving spans
+	TraceFile string
+
+	// DeadLetterAttempts is the number of delivery attempts before an asynchronous invocation is dead-lettered
+	DeadLetterAttempts int
+
+	// DeadLetterBackoff is the delay before the first retry of a failed asynchronous invocation, doubled on each retry
+	DeadLetterBackoff time.Duration
+
 	// LongRedisOperation sets a threshold used to report long-running redis operations
 	LongRedisOperation time.Duration
 
@@ -92,6 +176,9 @@ var (
 	// KubernetesMode is true when this process is running in a sidecar container in a Kubernetes Pod
 	KubernetesMode bool
 
+	// Transport is the message transport used by the sidecar [kafka|memory]
+	Transport string
+
 	// KafkaBrokers is an array of Kafka brokers
 	KafkaBrokers []string
 
@@ -110,6 +197,15 @@ var (
 	// KafkaTLSSkipVerify is set to skip server name verification for Kafka when connecting over TLS
 	KafkaTLSSkipVerify bool
 
+	// Store is the state backend used by the sidecar [redis|memory]
+	Store string
+
+	// StorePath is the file used to persist the embedded state backend (optional)
+	StorePath string
+
+	// StoreSaveInterval is the interval at which the embedded state backend is saved to disk
+	StoreSaveInterval time.Duration
+
 	// RedisHost is the host of the Redis instance
 	RedisHost string
 
@@ -166,14 +262,40 @@ var (
 	// RestBodyContentType specifies the content type of the request body
 	RestBodyContentType string
 
+	// ExportActorTypes restricts export and import to some actor types
+	ExportActorTypes []string
+
+	// ImportPartitions is the number of partitions to remap imported bindings to (0 to keep the exported partitions)
+	ImportPartitions int
+
+	// PurgeActorType restricts purge to an actor type
+	PurgeActorType string
+
+	// PurgeActorID restricts purge to an actor instance
+	PurgeActorID string
+
+	// PurgeReminders restricts purge to reminders
+	PurgeReminders bool
+
+	// PurgeSubscriptions restricts purge to subscriptions
+	PurgeSubscriptions bool
+
+	// PurgeOffsets restricts purge to consumer offsets
+	PurgeOffsets bool
+
+	// PurgeDryRun lists the keys to purge without deleting them
+	PurgeDryRun bool
+
 	// temporary variables to parse command line options
-	kafkaBrokers, verbosity, configDir, actorTypes, redisCABase64 string
+	kafkaBrokers, verbosity, logFormat, configDir, actorTypes, actorPlacement, actorIdleTTL, actorMaxInFlight, statelessWorkers, exportActorTypes, redisCABase64 string
 )
 
 // define the flags available on all commands
 func globalOptions(f *flag.FlagSet) {
 	f.StringVar(&AppName, "app", "", "The name of the application (required)")
 
+	f.StringVar(&Transport, "transport", TransportKafka, "The message transport [kafka|memory]")
+
 	f.StringVar(&kafkaBrokers, "kafka_brokers", "", "The Kafka brokers to connect to, as a comma separated list")
 	f.BoolVar(&KafkaEnableTLS, "kafka_enable_tls", false, "Use TLS to communicate with Kafka")
 	f.StringVar(&KafkaUsername, "kafka_username", "", "The SASL username if any")
@@ -181,6 +303,10 @@ func globalOptions(f *flag.FlagSet) {
 	f.StringVar(&KafkaVersion, "kafka_version", "", "Kafka cluster version")
 	f.BoolVar(&KafkaTLSSkipVerify, "kafka_tls_skip_verify", false, "Skip server name verification for Kafka when connecting over TLS")
 
+	f.StringVar(&Store, "store", StoreRedis, "The state backend [redis|memory]")
+	f.StringVar(&StorePath, "store_path", "", "The file used to persist the embedded state backend if any, saved periodically and on exit (changes since the last save are lost on a crash)")
+	f.DurationVar(&StoreSaveInterval, "store_save_interval", 10*time.Second, "Interval at which the embedded state backend is saved to disk (0 to only save on exit)")
+
 	f.StringVar(&RedisHost, "redis_host", "", "The Redis host")
 	f.IntVar(&RedisPort, "redis_port", 0, "The Redis port")
 	f.BoolVar(&RedisEnableTLS, "redis_enable_tls", false, "Use TLS to communicate with Redis")
@@ -191,25 +317,31 @@ func globalOptions(f *flag.FlagSet) {
 	f.DurationVar(&RequestRetryLimit, "request_retry_limit", -1*time.Second, "Time limit on retrying failing redis/http connections (<0 is infinite)")
 	f.DurationVar(&LongRedisOperation, "redis_slow_op_threshold", 1*time.Second, "Threshold for reporting long-running redis operations")
 
-	f.StringVar(&verbosity, "v", "error", "Logging verbosity")
+	f.StringVar(&verbosity, "v", "error", "Logging verbosity as a default level optionally followed by SUBSYSTEM=LEVEL pairs, e.g. info,pubsub=debug")
+	f.StringVar(&logFormat, "log_format", "text", "Log format [text|json]")
 
 	f.StringVar(&configDir, "config_dir", "", "Directory containing configuration files")
 }
 
-func init() {
+// Configure parses the command line and initializes the configuration
+func Configure() {
 	var err error
 
 	usage := `kar COMMAND ...
 
 Available commands:
-  run     run application component
-  get     query running application
-  invoke  invoke actor instance
-  rest    perform a REST operation on a service endpoint
-  purge   purge application messages and state
-  drain   drain application messages
-  version print version
-  help    print help message`
+  run         run application component
+  get         query running application
+  invoke      invoke actor instance
+  rest        perform a REST operation on a service endpoint
+  migrate     migrate actor instance to another sidecar
+  deadletters list, inspect, and re-drive undeliverable invocations
+  export      export application state
+  import      import application state
+  purge       purge application messages and state
+  drain       drain application messages
+  version     print version
+  help        print help message`
 
 	description := `Use "kar COMMAND -h" for more information about a command`
 
@@ -253,6 +385,14 @@ Available commands:
 		flag.DurationVar(&ActorCollectorInterval, "actor_collector_interval", 10*time.Second, "Actor collector interval")
 		flag.DurationVar(&ActorReminderInterval, "actor_reminder_interval", 100*time.Millisecond, "Actor reminder processing interval")
 		flag.DurationVar(&ActorReminderAcceptableDelay, "actor_reminder_acceptable_delay", 3*time.Second, "Threshold at which reminders are logged as being late")
+		flag.StringVar(&actorPlacement, "actor_placement", "", "The placement strategies of the actor types as a comma separated list of TYPE=STRATEGY with STRATEGY one of [random|hash|least-loaded|colocate:TYPE] (colocate:TYPE places an instance with the TYPE instance of the same id if already placed, randomly otherwise)")
+		flag.StringVar(&ActorRebalancePolicy, "actor_rebalance_policy", "none", "Policy for rebalancing idle actor placements when sidecars join [none|even]")
+		flag.IntVar(&ActorRebalanceLimit, "actor_rebalance_limit", 100, "Maximum number of actor placements moved by a sidecar on each rebalance")
+		flag.StringVar(&actorIdleTTL, "actor_idle_ttl", "", "The idle times after which actor instances are deleted with their state and bindings as a comma separated list of TYPE=DURATION, e.g. Session=30d")
+		flag.DurationVar(&ActorIdleSweepInterval, "actor_idle_sweep_interval", time.Minute, "Interval at which idle actors are deleted")
+		flag.StringVar(&actorMaxInFlight, "actor_max_inflight", "", "The maximum numbers of calls and tells processed concurrently by actor type as a comma separated list of TYPE=N")
+		flag.StringVar(&statelessWorkers, "stateless_workers", "", "The stateless worker actor types, which are not placed and run concurrent sessions, as a comma separated list of TYPE=N with N the maximum number of concurrent sessions per instance")
+		flag.IntVar(&MaxInFlight, "max_inflight", 0, "Maximum number of calls and tells processed concurrently, further calls and tells are queued (0 is unbounded)")
 		flag.IntVar(&AppPort, "app_port", 8080, "The port used by KAR to connect to the application")
 		flag.IntVar(&RuntimePort, "runtime_port", 0, "The port used by the application to connect to KAR")
 		flag.BoolVar(&KubernetesMode, "kubernetes_mode", false, "Running as a sidecar container in a Kubernetes Pod")
@@ -260,13 +400,24 @@ Available commands:
 		flag.StringVar(&Hostname, "hostname", "localhost", "Hostname")
 		flag.DurationVar(&ActorBusyTimeout, "actor_busy_timeout", 2*time.Minute, "Time to wait on a busy actor before timing out (0 is infinite)")
 		flag.DurationVar(&MissingComponentTimeout, "missing_component_timeout", 2*time.Minute, "Time to wait on request to unknown service or actor type before timing out (0 is infinite)")
+		flag.DurationVar(&PromiseTTL, "promise_ttl", 24*time.Hour, "Time after which the results of promise calls are discarded if not awaited")
+		flag.IntVar(&BreakerFailures, "breaker_failures", 0, "Number of consecutive failed requests to an actor type or path prefix of the application that opens its circuit breaker (0 disables circuit breakers)")
+		flag.DurationVar(&BreakerOpenDuration, "breaker_open_duration", 30*time.Second, "Time an open circuit breaker fails requests before letting a trial request through")
+		flag.IntVar(&AppMaxInFlight, "app_max_inflight", 0, "Maximum number of concurrent requests to the application process, requests beyond wait for a slot (0 is unbounded)")
+		flag.DurationVar(&IdempotencyRetention, "idempotency_retention", 24*time.Hour, "Time during which the replies to requests with idempotency keys are retained")
+		flag.StringVar(&DeadLetterTopic, "dead_letter_topic", "", "The topic receiving undeliverable asynchronous invocations and events (none if empty)")
+		flag.IntVar(&DeadLetterAttempts, "dead_letter_attempts", 3, "Number of delivery attempts before an asynchronous invocation is dead-lettered")
+		flag.DurationVar(&DeadLetterBackoff, "dead_letter_backoff", time.Second, "Delay before the first retry of a failed asynchronous invocation, doubled on each retry")
+		flag.StringVar(&TraceExporter, "trace_exporter", TraceExporterNone, "Span exporter [none|otlp|file]")
+		flag.StringVar(&TraceEndpoint, "trace_endpoint", "http://localhost:4318/v1/traces", "The OTLP/HTTP endpoint receiving spans")
+		flag.StringVar(&TraceFile, "trace_file", "kar-traces.json", "The file receiving spans")
 
 	case GetCmd:
 		usage = "kar get [OPTIONS]"
 		description = "Inspect state of an active application"
-		flag.StringVar(&GetSystemComponent, "s", "actors", "Subsystem to query [actors|sidecars]")
+		flag.StringVar(&GetSystemComponent, "s", "actors", "Subsystem to query [actors|sidecars|mailboxes|reminders|subscriptions]")
 		flag.BoolVar(&GetResidentOnly, "mr", false, "Only include memory-resident actor instances")
-		flag.StringVar(&GetActorType, "t", "", "Type of the actor instance to get")
+		flag.StringVar(&GetActorType, "t", "", "Type of the actor instance(s) to get")
 		flag.StringVar(&GetActorInstanceID, "i", "", "Instance id of a single actor whose state to get")
 		flag.StringVar(&GetOutputStyle, "o", "", "Output style of information calls. 'json' for JSON formatting")
 
@@ -282,9 +433,35 @@ Available commands:
 		flag.StringVar(&RestBodyContentType, "content_type", "application/json", "Content-Type of request body")
 		flag.DurationVar(&MissingComponentTimeout, "missing_component_timeout", 2*time.Minute, "Time to wait on request to unknown service or actor type before timing out (0 is infinite)")
 
+	case MigrateCmd:
+		usage = "kar migrate [OPTIONS] ACTOR_TYPE ACTOR_ID SIDECAR"
+		description = "Migrate actor instance to another sidecar"
+
+	case DeadLettersCmd:
+		usage = "kar deadletters [OPTIONS] [list | inspect ID | redrive ID...]"
+		description = "List, inspect, and re-drive undeliverable asynchronous invocations"
+		flag.StringVar(&GetOutputStyle, "o", "", "Output style of information calls. 'json' for JSON formatting")
+
+	case ExportCmd:
+		usage = "kar export [OPTIONS] [FILE]"
+		description = "Export actor state, placements, reminders, and subscriptions to FILE or standard output"
+		flag.StringVar(&exportActorTypes, "t", "", "The actor types to export as a comma separated list (all types if empty)")
+
+	case ImportCmd:
+		usage = "kar import [OPTIONS] [FILE]"
+		description = "Import actor state, placements, reminders, and subscriptions from FILE or standard input\nRunning sidecars load imported reminders and subscriptions on the next rebalance"
+		flag.StringVar(&exportActorTypes, "t", "", "The actor types to import as a comma separated list (all types if empty)")
+		flag.IntVar(&ImportPartitions, "partitions", 0, "The number of partitions of the target application to remap reminders and subscriptions to (keep partitions if 0)")
+
 	case PurgeCmd:
 		usage = "kar purge [OPTIONS]"
-		description = "Purge application messages and state"
+		description = "Purge application messages and state\nSelective purges keep the application messages and should only be run when the application is stopped"
+		flag.StringVar(&PurgeActorType, "t", "", "Only purge the state, placements, reminders, and subscriptions of this actor type")
+		flag.StringVar(&PurgeActorID, "i", "", "Only purge this actor instance (requires -t)")
+		flag.BoolVar(&PurgeReminders, "reminders", false, "Only purge reminders")
+		flag.BoolVar(&PurgeSubscriptions, "subscriptions", false, "Only purge subscriptions")
+		flag.BoolVar(&PurgeOffsets, "offsets", false, "Only purge consumer offsets")
+		flag.BoolVar(&PurgeDryRun, "dry_run", false, "List the keys to purge without deleting anything")
 
 	case DrainCmd:
 		usage = "kar drain [OPTIONS]"
@@ -335,7 +512,9 @@ Available commands:
 		os.Exit(0)
 	}
 
-	logger.SetVerbosity(verbosity)
+	if err := logger.SetVerbosity(verbosity); err != nil {
+		logger.Fatal("invalid verbosity: %v", err)
+	}
 
 	if AppName == "" {
 		logger.Fatal("app name is required")
@@ -345,12 +524,133 @@ Available commands:
 		ServiceName = "kar.none"
 	}
 
+	switch logFormat {
+	case "text":
+	case "json":
+		logger.SetJSON(true, logger.Fields{"sidecar": ID, "app": AppName, "service": ServiceName})
+	default:
+		logger.Fatal("invalid log format %s", logFormat)
+	}
+
 	if actorTypes == "" {
 		ActorTypes = make([]string, 0)
 	} else {
 		ActorTypes = strings.Split(actorTypes, ",")
 	}
 
+	if Transport != TransportKafka && Transport != TransportMemory {
+		logger.Fatal("invalid transport %s", Transport)
+	}
+
+	if Store != StoreRedis && Store != StoreMemory {
+		logger.Fatal("invalid store %s", Store)
+	}
+
+	if StoreSaveInterval < 0 {
+		logger.Fatal("invalid store save interval %v", StoreSaveInterval)
+	}
+
+	if actorPlacement == "" {
+		actorPlacement = loadStringFromConfig(configDir, "actor_placement")
+	}
+
+	ActorPlacement = map[string]string{}
+	for _, entry := range strings.FieldsFunc(actorPlacement, func(r rune) bool { return r == ',' || r == '\n' }) {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			logger.Fatal("invalid actor placement %s", entry)
+		}
+		switch strategy := parts[1]; {
+		case strategy == "random", strategy == "hash", strategy == "least-loaded":
+		case strings.HasPrefix(strategy, "colocate:") && len(strategy) > len("colocate:"):
+		default:
+			logger.Fatal("invalid placement strategy %s for actor type %s", strategy, parts[0])
+		}
+		ActorPlacement[parts[0]] = parts[1]
+	}
+
+	if actorIdleTTL == "" {
+		actorIdleTTL = loadStringFromConfig(configDir, "actor_idle_ttl")
+	}
+
+	ActorIdleTTL = map[string]time.Duration{}
+	for _, entry := range strings.FieldsFunc(actorIdleTTL, func(r rune) bool { return r == ',' || r == '\n' }) {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			logger.Fatal("invalid actor idle ttl %s", entry)
+		}
+		ttl, err := ParseDuration(parts[1])
+		if err != nil || ttl <= 0 {
+			logger.Fatal("invalid idle ttl %s for actor type %s", parts[1], parts[0])
+		}
+		ActorIdleTTL[parts[0]] = ttl
+	}
+
+	if actorMaxInFlight == "" {
+		actorMaxInFlight = loadStringFromConfig(configDir, "actor_max_inflight")
+	}
+
+	ActorMaxInFlight = map[string]int{}
+	for _, entry := range strings.FieldsFunc(actorMaxInFlight, func(r rune) bool { return r == ',' || r == '\n' }) {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			logger.Fatal("invalid actor max inflight %s", entry)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n <= 0 {
+			logger.Fatal("invalid max inflight %s for actor type %s", parts[1], parts[0])
+		}
+		ActorMaxInFlight[parts[0]] = n
+	}
+
+	if statelessWorkers == "" {
+		statelessWorkers = loadStringFromConfig(configDir, "stateless_workers")
+	}
+
+	StatelessWorkers = map[string]int{}
+	for _, entry := range strings.FieldsFunc(statelessWorkers, func(r rune) bool { return r == ',' || r == '\n' }) {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 {
+			logger.Fatal("invalid stateless worker %s", entry)
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n <= 0 {
+			logger.Fatal("invalid concurrency %s for stateless worker type %s", parts[1], parts[0])
+		}
+		if _, ok := ActorPlacement[parts[0]]; ok {
+			logger.Fatal("stateless worker type %s cannot have a placement strategy", parts[0])
+		}
+		StatelessWorkers[parts[0]] = n
+	}
+
+	if CmdName == RunCmd && MaxInFlight < 0 {
+		logger.Fatal("invalid max inflight %v", MaxInFlight)
+	}
+
+	if CmdName == RunCmd && PromiseTTL <= 0 {
+		logger.Fatal("invalid promise ttl %v", PromiseTTL)
+	}
+
+	if CmdName == RunCmd && (BreakerFailures < 0 || BreakerOpenDuration <= 0) {
+		logger.Fatal("invalid circuit breaker configuration %v failures, %v open duration", BreakerFailures, BreakerOpenDuration)
+	}
+
+	if CmdName == RunCmd && AppMaxInFlight < 0 {
+		logger.Fatal("invalid app max inflight %v", AppMaxInFlight)
+	}
+
+	if CmdName == RunCmd && IdempotencyRetention <= 0 {
+		logger.Fatal("invalid idempotency retention %v", IdempotencyRetention)
+	}
+
+	if CmdName == RunCmd && ActorIdleSweepInterval <= 0 {
+		logger.Fatal("invalid actor idle sweep interval %v", ActorIdleSweepInterval)
+	}
+
+	if CmdName == RunCmd && ActorRebalancePolicy != "none" && ActorRebalancePolicy != "even" {
+		logger.Fatal("invalid actor rebalance policy %s", ActorRebalancePolicy)
+	}
+
 	if !KafkaEnableTLS {
 		ktmp := os.Getenv("KAFKA_ENABLE_TLS")
 		if ktmp == "" {
@@ -365,7 +665,7 @@ Available commands:
 
 	if kafkaBrokers == "" {
 		if kafkaBrokers = os.Getenv("KAFKA_BROKERS"); kafkaBrokers == "" {
-			if kafkaBrokers = loadStringFromConfig(configDir, "kafka_brokers"); kafkaBrokers == "" {
+			if kafkaBrokers = loadStringFromConfig(configDir, "kafka_brokers"); kafkaBrokers == "" && Transport == TransportKafka {
 				logger.Fatal("at least one Kafka broker is required")
 			}
 		}
@@ -433,7 +733,7 @@ Available commands:
 
 	if RedisHost == "" {
 		if RedisHost = os.Getenv("REDIS_HOST"); RedisHost == "" {
-			if RedisHost = loadStringFromConfig(configDir, "redis_host"); RedisHost == "" {
+			if RedisHost = loadStringFromConfig(configDir, "redis_host"); RedisHost == "" && Store == StoreRedis {
 				logger.Fatal("Redis host is required")
 			}
 		}
@@ -484,6 +784,52 @@ Available commands:
 		logger.Fatal("invoke expects at least three arguments")
 	}
 
+	if CmdName == MigrateCmd && len(flag.Args()) != 3 {
+		logger.Fatal("migrate expects exactly three arguments; got %v", len(flag.Args()))
+	}
+
+	if CmdName == RunCmd && TraceExporter != TraceExporterNone && TraceExporter != TraceExporterOTLP && TraceExporter != TraceExporterFile {
+		logger.Fatal("invalid trace exporter %s", TraceExporter)
+	}
+
+	if CmdName == RunCmd && DeadLetterAttempts < 1 {
+		logger.Fatal("invalid dead letter attempts %v", DeadLetterAttempts)
+	}
+
+	if CmdName == RunCmd && DeadLetterBackoff < 0 {
+		logger.Fatal("invalid dead letter backoff %v", DeadLetterBackoff)
+	}
+
+	if CmdName == DeadLettersCmd {
+		switch {
+		case len(flag.Args()) == 0, len(flag.Args()) == 1 && flag.Arg(0) == "list":
+		case len(flag.Args()) == 2 && flag.Arg(0) == "inspect":
+		case len(flag.Args()) >= 2 && flag.Arg(0) == "redrive":
+		default:
+			logger.Fatal("deadletters expects list, inspect ID, or redrive ID...")
+		}
+	}
+
+	if exportActorTypes != "" {
+		ExportActorTypes = strings.Split(exportActorTypes, ",")
+	}
+
+	if (CmdName == ExportCmd || CmdName == ImportCmd) && len(flag.Args()) > 1 {
+		logger.Fatal("%s expects at most one argument; got %v", CmdName, len(flag.Args()))
+	}
+
+	if CmdName == ImportCmd && ImportPartitions < 0 {
+		logger.Fatal("invalid partitions %v", ImportPartitions)
+	}
+
+	if CmdName == PurgeCmd && PurgeActorID != "" && PurgeActorType == "" {
+		logger.Fatal("purge -i requires -t")
+	}
+
+	if CmdName == PurgeCmd && PurgeOffsets && PurgeActorType != "" {
+		logger.Fatal("purge -offsets cannot be combined with -t")
+	}
+
 	if CmdName == RestCmd && !(len(flag.Args()) == 3 || len(flag.Args()) == 4) {
 		logger.Fatal("rest expects either three or four arguments; got %v", len(flag.Args()))
 	}
@@ -491,6 +837,18 @@ Available commands:
 	GetOutputStyle = strings.ToLower(GetOutputStyle)
 }
 
+// ParseDuration parses a duration like time.ParseDuration but also accepts a number of days, e.g. 30d
+func ParseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseUint(strings.TrimSuffix(s, "d"), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
 func loadStringFromConfig(path string, file string) string {
 	value := ""
 	if path != "" {
@@ -0,0 +1,257 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package metrics implements a minimal registry of metrics
+// exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the default histogram buckets in seconds
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// a metric family
+type family interface {
+	write(w io.Writer)
+}
+
+var (
+	lock     sync.Mutex
+	families = map[string]family{} // metric name -> family
+)
+
+func register(name string, f family) {
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := families[name]; ok {
+		panic("duplicate metric " + name)
+	}
+	families[name] = f
+}
+
+// Write writes all registered metrics in the Prometheus text format
+func Write(w io.Writer) {
+	lock.Lock()
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	lock.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		lock.Lock()
+		f := families[name]
+		lock.Unlock()
+		f.write(w)
+	}
+}
+
+// escape a label value
+var escaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// format label names and values as {name="value",...}
+func formatLabels(names, values []string, extra ...string) string {
+	if len(names) == 0 && len(extra) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+len(extra)/2)
+	for i, name := range names {
+		parts = append(parts, name+`="`+escaper.Replace(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+escaper.Replace(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sorted keys of a map of label values
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// key for a tuple of label values
+func key(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64
+	tuples     map[string][]string
+}
+
+// NewCounterVec registers a new counter
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, values: map[string]float64{}, tuples: map[string][]string{}}
+	if len(labels) == 0 { // report unlabeled counter even if never incremented
+		c.Add(0)
+	}
+	register(name, c)
+	return c
+}
+
+// Inc increments the counter for the given label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds to the counter for the given label values
+func (c *CounterVec) Add(v float64, values ...string) {
+	k := key(values)
+	c.mu.Lock()
+	if _, ok := c.tuples[k]; !ok {
+		c.tuples[k] = append([]string(nil), values...)
+	}
+	c.values[k] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, k := range sortedKeys(c.tuples) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, c.tuples[k]), formatFloat(c.values[k]))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	mu         sync.Mutex
+	series     map[string]*histogram
+	tuples     map[string][]string
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+// NewHistogramVec registers a new histogram with the given upper bounds
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}, tuples: map[string][]string{}}
+	register(name, h)
+	return h
+}
+
+// Observe records a value for the given label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	k := key(values)
+	h.mu.Lock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[k] = s
+		h.tuples[k] = append([]string(nil), values...)
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+	h.mu.Unlock()
+}
+
+// Since records the time elapsed since start in seconds for the given label values
+func (h *HistogramVec) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, k := range sortedKeys(h.tuples) {
+		s := h.series[k]
+		values := h.tuples[k]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), s.count)
+	}
+}
+
+// gaugeFunc is a gauge computed when metrics are written
+type gaugeFunc struct {
+	name, help string
+	label      string
+	f          func() map[string]float64
+}
+
+// NewGaugeFunc registers a gauge computed by f when metrics are written
+func NewGaugeFunc(name, help string, f func() float64) {
+	register(name, &gaugeFunc{name: name, help: help, f: func() map[string]float64 { return map[string]float64{"": f()} }})
+}
+
+// NewGaugeVecFunc registers a gauge partitioned by one label computed by f when metrics are written
+// f returns a map from label values to gauge values
+func NewGaugeVecFunc(name, help, label string, f func() map[string]float64) {
+	register(name, &gaugeFunc{name: name, help: help, label: label, f: f})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	values := g.f()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		labels := ""
+		if g.label != "" {
+			labels = formatLabels([]string{g.label}, []string{k})
+		}
+		fmt.Fprintf(w, "%s%s %s\n", g.name, labels, formatFloat(values[k]))
+	}
+}
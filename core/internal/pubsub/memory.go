@@ -0,0 +1,244 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package pubsub
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/pkg/logger"
+)
+
+// memoryTransport is an in-process Transport for single-sidecar deployments
+//
+// Topics are append-only in-memory logs with a single partition.
+// Consumer groups track their position in each log.
+// Messages are considered consumed as soon as they are delivered,
+// hence Mark is a no-op and nothing survives a restart of the sidecar.
+type memoryTransport struct {
+	lock   sync.Mutex
+	topics map[string]*memoryTopic
+	paused pauses // paused partition of the application topic
+}
+
+// memoryTopic is an in-memory topic
+type memoryTopic struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	log     [][]byte         // messages
+	offsets map[string]int64 // next offset to deliver for each consumer group
+	deleted bool             // true once the topic has been deleted
+}
+
+func newMemoryTransport() *memoryTransport {
+	return &memoryTransport{topics: map[string]*memoryTopic{}}
+}
+
+func newMemoryTopic() *memoryTopic {
+	t := &memoryTopic{offsets: map[string]int64{}}
+	t.cond = sync.NewCond(&t.lock)
+	return t
+}
+
+// append a message to the topic and return its offset
+func (t *memoryTopic) append(message []byte) int64 {
+	t.lock.Lock()
+	t.log = append(t.log, message)
+	offset := int64(len(t.log) - 1)
+	t.cond.Broadcast()
+	t.lock.Unlock()
+	return offset
+}
+
+// next waits for the next message for the group
+// returns false if the context is cancelled or the topic is deleted
+func (t *memoryTopic) next(ctx context.Context, group string) ([]byte, int64, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for t.offsets[group] >= int64(len(t.log)) && !t.deleted && ctx.Err() == nil {
+		t.cond.Wait()
+	}
+	if t.deleted || ctx.Err() != nil {
+		return nil, 0, false
+	}
+	offset := t.offsets[group]
+	t.offsets[group] = offset + 1
+	return t.log[offset], offset, true
+}
+
+func (m *memoryTransport) topic(name string) *memoryTopic {
+	m.lock.Lock()
+	t := m.topics[name]
+	m.lock.Unlock()
+	return t
+}
+
+// Dial is a no-op
+func (m *memoryTransport) Dial() error {
+	return nil
+}
+
+// Close is a no-op
+func (m *memoryTransport) Close() {
+}
+
+// Join makes this sidecar the only member of the application
+func (m *memoryTransport) Join(ctx context.Context, f func(Message), port int) (<-chan struct{}, error) {
+	address = net.JoinHostPort(config.Hostname, strconv.Itoa(port))
+	if err := m.CreateTopic(topic, ""); err != nil && err != ErrTopicAlreadyExists {
+		return nil, err
+	}
+
+	hs := map[string][]string{}
+	for _, t := range config.ActorTypes {
+		hs[t] = []string{config.ID}
+	}
+	mu.Lock()
+	replicas = map[string][]string{config.ServiceName: {config.ID}}
+	hosts = hs
+	placement = config.ActorPlacement
+	workers = config.StatelessWorkers
+	routes = map[string][]int32{config.ID: {0}}
+	addresses = map[string]string{config.ID: address}
+	close(tick)
+	tick = make(chan struct{})
+	mu.Unlock()
+	rebalances.Inc(topic)
+
+	ch, _, err := m.Subscribe(ctx, topic, topic, &Options{master: true, OffsetOldest: true}, f)
+	return ch, err
+}
+
+// Subscribe consumes messages on a topic
+func (m *memoryTransport) Subscribe(ctx context.Context, topic, group string, options *Options, f func(Message)) (<-chan struct{}, int, error) {
+	if ctx.Err() != nil { // fail fast
+		return nil, http.StatusServiceUnavailable, ctx.Err()
+	}
+	t := m.topic(topic)
+	if t == nil {
+		return nil, http.StatusNotFound, ErrUnknownTopic
+	}
+
+	t.lock.Lock()
+	if _, ok := t.offsets[group]; !ok && !options.OffsetOldest {
+		t.offsets[group] = int64(len(t.log))
+	}
+	t.lock.Unlock()
+
+	closed := make(chan struct{})
+
+	// wake up consumer loop on cancellation
+	go func() {
+		select {
+		case <-ctx.Done():
+			t.lock.Lock()
+			t.cond.Broadcast()
+			t.lock.Unlock()
+		case <-closed:
+		}
+	}()
+
+	// consumer loop
+	go func() {
+		defer close(closed)
+		for {
+			value, offset, ok := t.next(ctx, group)
+			if !ok {
+				return
+			}
+			logger.Debug("received message on topic %s, offset %d", topic, offset)
+			if options.master && !m.paused.wait(ctx, 0) {
+				return
+			}
+			f(Message{Value: value, offset: offset})
+		}
+	}()
+
+	return closed, http.StatusOK, nil
+}
+
+// Publish publishes a message on a topic
+func (m *memoryTransport) Publish(topic string, message []byte) (int, error) {
+	t := m.topic(topic)
+	if t == nil {
+		logger.Error("failed to send message on topic %s: %v", topic, ErrUnknownTopic)
+		return http.StatusNotFound, ErrUnknownTopic
+	}
+	offset := t.append(message)
+	logger.Debug("sent message on topic %s, offset %d", topic, offset)
+	return http.StatusOK, nil
+}
+
+// Produce sends a message to the application topic
+func (m *memoryTransport) Produce(partition int32, message []byte) error {
+	t := m.topic(topic)
+	if t == nil {
+		return ErrUnknownTopic
+	}
+	offset := t.append(message)
+	logger.Debug("sent message at partition %d, offset %d", partition, offset)
+	return nil
+}
+
+// Pause pauses the single partition of the application topic
+func (m *memoryTransport) Pause(partition int32) {
+	m.paused.pause(partition)
+}
+
+// Resume resumes the single partition of the application topic
+func (m *memoryTransport) Resume(partition int32) {
+	m.paused.resume(partition)
+}
+
+// CreateTopic creates a topic, parameters are ignored
+func (m *memoryTransport) CreateTopic(topic string, parameters string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.topics[topic]; ok {
+		return ErrTopicAlreadyExists
+	}
+	m.topics[topic] = newMemoryTopic()
+	return nil
+}
+
+// DeleteTopic deletes a topic and stops its consumers
+func (m *memoryTransport) DeleteTopic(topic string) error {
+	m.lock.Lock()
+	t, ok := m.topics[topic]
+	delete(m.topics, topic)
+	m.lock.Unlock()
+	if !ok {
+		return ErrUnknownTopic
+	}
+	t.lock.Lock()
+	t.deleted = true
+	t.cond.Broadcast()
+	t.lock.Unlock()
+	return nil
+}
+
+// Purge deletes the application topic
+func (m *memoryTransport) Purge() error {
+	if err := m.DeleteTopic(topic); err != ErrUnknownTopic {
+		return err
+	}
+	return nil
+}
@@ -17,6 +17,9 @@
 package pubsub
 
 import (
+	"hash/fnv"
+	"math/rand"
+	"strconv"
 	"strings"
 
 	"github.com/IBM/kar.git/core/internal/config"
@@ -31,6 +34,95 @@ func placementKey(t, id string) string {
 	return "pubsub" + config.Separator + "placement" + config.Separator + t + config.Separator + id
 }
 
+// PlacementKeyPattern returns the store key pattern matching the placements of actor type t and instance id
+func PlacementKeyPattern(t, id string) string {
+	return placementKey(t, id)
+}
+
+func loadKey(sidecar string) string {
+	return "pubsub" + config.Separator + "load" + config.Separator + sidecar
+}
+
+// selectSidecar selects a sidecar for a new actor instance among the sidecars hosting the actor type
+// the strategy is one of random (default), hash, least-loaded, or colocate:TYPE
+// colocate:TYPE selects the sidecar of the TYPE instance with the same id, it falls back to random
+// if this instance is not placed yet or is placed on a sidecar that does not host the actor type,
+// the placement is not revised when the TYPE instance is placed later
+func selectSidecar(strategy, t, id string, sidecars []string) (string, error) {
+	switch {
+	case strategy == "hash":
+		return rendezvous(id, sidecars), nil
+
+	case strategy == "least-loaded":
+		return leastLoaded(t, sidecars)
+
+	case strings.HasPrefix(strategy, "colocate:"):
+		sidecar, err := GetSidecar(strings.TrimPrefix(strategy, "colocate:"), id)
+		if err != nil {
+			return "", err
+		}
+		for _, s := range sidecars {
+			if s == sidecar {
+				return sidecar, nil
+			}
+		}
+		// other actor is not placed or placed on a sidecar that does not host this type
+	}
+	return sidecars[rand.Int31n(int32(len(sidecars)))], nil // select random sidecar from list
+}
+
+// rendezvous selects the sidecar with the highest hash for the actor id
+// only actors placed on a departed sidecar or claimed by a new sidecar change placement
+func rendezvous(id string, sidecars []string) string {
+	var best string
+	var max uint64
+	for _, sidecar := range sidecars {
+		h := fnv.New64a()
+		h.Write([]byte(sidecar))
+		h.Write([]byte(config.Separator))
+		h.Write([]byte(id))
+		if w := h.Sum64(); best == "" || w > max {
+			best = sidecar
+			max = w
+		}
+	}
+	return best
+}
+
+// leastLoaded selects the sidecar with the fewest resident instances of the actor type
+// resident counts are published periodically by each sidecar, ties are broken randomly
+func leastLoaded(t string, sidecars []string) (string, error) {
+	candidates := []string{}
+	min := -1
+	for _, sidecar := range sidecars {
+		count := 0
+		s, err := store.HGet(loadKey(sidecar), t)
+		if err != nil && err != store.ErrNil {
+			return "", err
+		}
+		if err == nil {
+			count, _ = strconv.Atoi(s)
+		}
+		if min < 0 || count < min {
+			candidates = []string{sidecar}
+			min = count
+		} else if count == min {
+			candidates = append(candidates, sidecar)
+		}
+	}
+	return candidates[rand.Int31n(int32(len(candidates)))], nil
+}
+
+// PublishLoad records the number of resident instances of each actor type in this sidecar
+func PublishLoad(counts map[string]int) error {
+	m := make(map[string]string, len(counts))
+	for _, t := range config.ActorTypes {
+		m[t] = strconv.Itoa(counts[t])
+	}
+	_, err := store.HSetMultiple(loadKey(config.ID), m)
+	return err
+}
+
 // GetSidecar returns the current sidecar for the given actor type and id or "" if none.
 func GetSidecar(t, id string) (string, error) {
 	s, err := store.Get(placementKey(t, id))
@@ -56,6 +148,27 @@ func CompareAndSetSidecar(t, id, old, new string) (int, error) {
 	return store.CompareAndSet(placementKey(t, id), o, n)
 }
 
+// GetPlacements returns a mapping from instanceIDs to sidecars for the given actor type
+func GetPlacements(t string) (map[string]string, error) {
+	m := map[string]string{}
+	reply, err := store.Keys(placementKey(t, "*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range reply {
+		id := strings.SplitN(key, config.Separator, 4)[3]
+		sidecar, err := store.Get(key)
+		if err == store.ErrNil { // placement was deleted concurrently
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[id] = sidecar
+	}
+	return m, nil
+}
+
 // GetAllActorInstances returns a mapping from actor types to instanceIDs
 func GetAllActorInstances(actorTypePrefix string) (map[string][]string, error) {
 	m := map[string][]string{}
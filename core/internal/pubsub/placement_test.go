@@ -0,0 +1,68 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package pubsub
+
+import (
+	"testing"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/store"
+)
+
+func dialStore(t *testing.T) {
+	store0, app0 := config.Store, config.AppName
+	config.Store, config.AppName = config.StoreMemory, "test"
+	if err := store.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		config.Store, config.AppName = store0, app0
+	})
+}
+
+func TestColocate(t *testing.T) {
+	dialStore(t)
+	sidecars := []string{"s1", "s2", "s3"}
+	if _, err := CompareAndSetSidecar("Bar", "a", "", "s2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CompareAndSetSidecar("Bar", "b", "", "s4"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if s, err := selectSidecar("colocate:Bar", "Foo", "a", sidecars); err != nil || s != "s2" {
+			t.Fatalf("selectSidecar(a) = %v, %v, want s2", s, err)
+		}
+	}
+
+	// Bar c is not placed and Bar b is placed on a sidecar that does not host Foo: random placement
+	for _, id := range []string{"b", "c"} {
+		seen := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			s, err := selectSidecar("colocate:Bar", "Foo", id, sidecars)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[s] = true
+		}
+		if len(seen) != len(sidecars) {
+			t.Errorf("selectSidecar(%s) selected %v, want random among %v", id, seen, sidecars)
+		}
+	}
+}
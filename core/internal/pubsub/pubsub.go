@@ -14,7 +14,7 @@
 // limitations under the License.
 //
 
-// Package pubsub handles Kafka
+// Package pubsub handles messaging between sidecars
 package pubsub
 
 import (
@@ -34,13 +34,15 @@ import (
 )
 
 var (
-	client   sarama.Client       // shared client
-	producer sarama.SyncProducer // shared idempotent producer
+	client   sarama.Client       // shared Kafka client
+	producer sarama.SyncProducer // shared idempotent Kafka producer
 
 	// routes
 	topic     = "kar" + config.Separator + config.AppName
 	replicas  map[string][]string // map services to sidecars
 	hosts     map[string][]string // map actor types to sidecars
+	placement map[string]string   // map actor types to placement strategies
+	workers   map[string]int      // map stateless worker actor types to concurrency
 	routes    map[string][]int32  // map sidecards to partitions
 	address   string              // host:port of sidecar http server (for peer-to-peer connections)
 	addresses map[string]string   // map sidecards to addresses
@@ -63,8 +65,13 @@ func partitioner(t string) sarama.Partitioner {
 	return sarama.NewRandomPartitioner(t)
 }
 
+// kafkaTransport is the default Transport backed by a Kafka cluster
+type kafkaTransport struct {
+	paused pauses // paused partitions of the application topic
+}
+
 // Dial connects Kafka producer
-func Dial() error {
+func (k *kafkaTransport) Dial() error {
 	conf, err := newConfig()
 	if err != nil {
 		return err
@@ -92,7 +99,7 @@ func Dial() error {
 }
 
 // Close closes Kafka producer
-func Close() {
+func (k *kafkaTransport) Close() {
 	producer.Close()
 	client.Close()
 }
@@ -135,7 +142,7 @@ func Partitions() ([]int32, <-chan struct{}) {
 }
 
 // Join joins the sidecar to the application and returns a channel of incoming messages
-func Join(ctx context.Context, f func(Message), port int) (<-chan struct{}, error) {
+func (k *kafkaTransport) Join(ctx context.Context, f func(Message), port int) (<-chan struct{}, error) {
 	address = net.JoinHostPort(config.Hostname, strconv.Itoa(port))
 	admin, err := sarama.NewClusterAdminFromClient(client)
 	if err != nil {
@@ -152,12 +159,12 @@ func Join(ctx context.Context, f func(Message), port int) (<-chan struct{}, erro
 			return nil, err
 		}
 	}
-	ch, _, err := Subscribe(ctx, topic, topic, &Options{master: true, OffsetOldest: true}, f)
+	ch, _, err := k.Subscribe(ctx, topic, topic, &Options{master: true, OffsetOldest: true}, f)
 	return ch, err
 }
 
 // CreateTopic attempts to create the specified topic using the given parameters
-func CreateTopic(topic string, parameters string) error {
+func (k *kafkaTransport) CreateTopic(topic string, parameters string) error {
 	var params sarama.TopicDetail
 	var err error
 
@@ -184,6 +191,9 @@ func CreateTopic(topic string, parameters string) error {
 		err = admin.CreateTopic(topic, &params, false)
 	}
 	if err != nil {
+		if e, ok := err.(*sarama.TopicError); ok && e.Err == sarama.ErrTopicAlreadyExists {
+			return ErrTopicAlreadyExists
+		}
 		logger.Error("failed to create Kafka topic %v: %v", topic, err)
 		return err
 	}
@@ -191,7 +201,7 @@ func CreateTopic(topic string, parameters string) error {
 }
 
 // DeleteTopic attempts to delete the specified topic
-func DeleteTopic(topic string) error {
+func (k *kafkaTransport) DeleteTopic(topic string) error {
 	admin, err := sarama.NewClusterAdminFromClient(client)
 	if err != nil {
 		logger.Error("failed to instantiate Kafka cluster admin: %v", err)
@@ -263,7 +273,7 @@ func GetSidecarID(format string) (string, error) {
 }
 
 // Purge deletes the application topic
-func Purge() error {
+func (k *kafkaTransport) Purge() error {
 	admin, err := sarama.NewClusterAdminFromClient(client)
 	if err != nil {
 		return err
@@ -274,3 +284,14 @@ func Purge() error {
 	}
 	return nil
 }
+
+// Pause stops the delivery of the messages of a partition of the application topic to this sidecar
+// messages remain in the partition until the partition is resumed or reassigned
+func (k *kafkaTransport) Pause(partition int32) {
+	k.paused.pause(partition)
+}
+
+// Resume resumes the delivery of the messages of a paused partition of the application topic
+func (k *kafkaTransport) Resume(partition int32) {
+	k.paused.resume(partition)
+}
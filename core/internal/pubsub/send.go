@@ -28,8 +28,9 @@ import (
 	"time"
 
 	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/metrics"
+	"github.com/IBM/kar.git/core/internal/tracing"
 	"github.com/IBM/kar.git/core/pkg/logger"
-	"github.com/Shopify/sarama"
 )
 
 // ErrRouteToActorTimeout indicates a timeout while waiting for a viable route to an Actor type.
@@ -40,11 +41,18 @@ var ErrRouteToServiceTimeout = errors.New("timeout occurred while looking for se
 
 // use debug logger for errors returned to caller
 
-// routeToService maps a service to a partition (keep trying)
+// routeToService maps a service to a random sidecar running the service to a random partition (keep trying)
 func routeToService(ctx context.Context, service string) (partition int32, sidecar string, err error) {
+	return routeToReplica(ctx, func() []string { return replicas[service] }, "service "+service, ErrRouteToServiceTimeout)
+}
+
+// routeToReplica maps a service or stateless worker actor type to a random sidecar among the sidecars
+// returned by candidates to a random partition (keep trying)
+// candidates must be called while holding mu
+func routeToReplica(ctx context.Context, candidates func() []string, component string, errTimeout error) (partition int32, sidecar string, err error) {
 	for {
 		mu.RLock()
-		sidecars := replicas[service]
+		sidecars := candidates()
 		if len(sidecars) != 0 {
 			sidecar = sidecars[rand.Int31n(int32(len(sidecars)))]       // select random sidecar from list
 			partitions := routes[sidecar]                               // a live sidecar always has partitions
@@ -54,31 +62,32 @@ func routeToService(ctx context.Context, service string) (partition int32, sidec
 		}
 		ch := tick
 		mu.RUnlock()
-		logger.Info("no sidecar for service %s, waiting for new session", service)
-
-		if config.MissingComponentTimeout > 0 {
-			select {
-			case <-ch:
-			case <-ctx.Done():
-				err = ctx.Err()
-				return
-			case <-time.After(config.MissingComponentTimeout):
-				err = ErrRouteToServiceTimeout
-				return
-			}
-		} else {
-			select {
-			case <-ch:
-			case <-ctx.Done():
-				err = ctx.Err()
-				return
-			}
+		logger.Info("no sidecar for %s, waiting for new session", component)
+		if err = awaitSession(ctx, ch, errTimeout); err != nil {
+			return
 		}
 	}
 }
 
-// routeToSidecar maps a sidecar to a partition (no retries)
-func routeToSidecar(sidecar string) (int32, error) {
+// awaitSession waits for the next session given the tick channel of the current session
+// returns errTimeout if no new session starts within the missing component timeout
+func awaitSession(ctx context.Context, ch <-chan struct{}, errTimeout error) error {
+	var timeout <-chan time.Time // nil channel blocks forever
+	if config.MissingComponentTimeout > 0 {
+		timeout = time.After(config.MissingComponentTimeout)
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timeout:
+		return errTimeout
+	}
+}
+
+// RouteToSidecar maps a sidecar to a partition (no retries)
+func RouteToSidecar(sidecar string) (int32, error) {
 	mu.RLock()
 	partitions := routes[sidecar]
 	mu.RUnlock()
@@ -89,16 +98,32 @@ func routeToSidecar(sidecar string) (int32, error) {
 	return partitions[rand.Int31n(int32(len(partitions)))], nil // select random partition from list
 }
 
+// routeToWorker maps a stateless worker actor type to a random sidecar hosting the type to a random partition (keep trying)
+func routeToWorker(ctx context.Context, t string) (partition int32, sidecar string, err error) {
+	return routeToReplica(ctx, func() []string { return hosts[t] }, "actor type "+t, ErrRouteToActorTimeout)
+}
+
+// IsStatelessWorker returns true if the actor type is a stateless worker type
+func IsStatelessWorker(t string) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return workers[t] > 0
+}
+
 // routeToActor maps an actor to a stable sidecar to a random partition (keep trying)
 // only switching to a new sidecar if the existing sidecar has died
+// stateless worker actors are not placed and are routed to any sidecar hosting the type
 func routeToActor(ctx context.Context, t, id string) (partition int32, sidecar string, err error) {
+	if IsStatelessWorker(t) {
+		return routeToWorker(ctx, t)
+	}
 	for { // keep trying
 		sidecar, err = GetSidecar(t, id) // retrieve already assigned sidecar if any
 		if err != nil {
 			return // store error
 		}
 		if sidecar != "" { // sidecar is already assigned
-			partition, err = routeToSidecar(sidecar) // find partition for sidecar
+			partition, err = RouteToSidecar(sidecar) // find partition for sidecar
 			if err == nil {
 				return // found sidecar and partition
 			}
@@ -110,30 +135,19 @@ func routeToActor(ctx context.Context, t, id string) (partition int32, sidecar s
 			mu.RLock()
 			sidecars := hosts[t]
 			if len(sidecars) != 0 {
-				sidecar = sidecars[rand.Int31n(int32(len(sidecars)))] // select random sidecar from list
+				strategy := placement[t]
 				mu.RUnlock()
+				sidecar, err = selectSidecar(strategy, t, id, sidecars) // apply placement strategy
+				if err != nil {
+					return // store error
+				}
 				break
 			}
 			ch := tick
 			mu.RUnlock()
 			logger.Info("no sidecar for actor type %s, waiting for new session", t)
-			if config.MissingComponentTimeout > 0 {
-				select {
-				case <-ch:
-				case <-ctx.Done():
-					err = ctx.Err()
-					return
-				case <-time.After(config.MissingComponentTimeout):
-					err = ErrRouteToActorTimeout
-					return
-				}
-			} else {
-				select {
-				case <-ch:
-				case <-ctx.Done():
-					err = ctx.Err()
-					return
-				}
+			if err = awaitSession(ctx, ch, ErrRouteToActorTimeout); err != nil {
+				return
 			}
 		}
 		logger.Debug("trying to save new sidecar %s for actor type %s, id %s", sidecar, t, id)
@@ -145,8 +159,26 @@ func routeToActor(ctx context.Context, t, id string) (partition int32, sidecar s
 	}
 }
 
+var (
+	sendRequests = metrics.NewCounterVec("kar_pubsub_send_total", "Messages sent by the sidecar by protocol and result.", "protocol", "result")
+	sendDuration = metrics.NewHistogramVec("kar_pubsub_send_duration_seconds", "Latency of sending messages including routing.", metrics.DefaultBuckets, "protocol")
+)
+
 // Send sends message to receiver
 func Send(ctx context.Context, direct bool, msg map[string]string) error {
+	tracing.Inject(ctx, msg)
+	start := time.Now()
+	err := send(ctx, direct, msg)
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	sendRequests.Inc(msg["protocol"], result)
+	sendDuration.Since(start, msg["protocol"])
+	return err
+}
+
+func send(ctx context.Context, direct bool, msg map[string]string) error {
 	select { // make sure we have joined
 	case <-joined:
 	case <-ctx.Done():
@@ -168,7 +200,7 @@ func Send(ctx context.Context, direct bool, msg map[string]string) error {
 			return err
 		}
 	case "sidecar": // route to sidecar
-		partition, err = routeToSidecar(msg["sidecar"])
+		partition, err = RouteToSidecar(msg["sidecar"])
 		if err != nil {
 			logger.Error("failed to route to sidecar %s: %v", msg["sidecar"], err)
 			return err
@@ -198,16 +230,11 @@ func Send(ctx context.Context, direct bool, msg map[string]string) error {
 		}
 		return nil
 	}
-	_, offset, err := producer.SendMessage(&sarama.ProducerMessage{
-		Topic:     topic,
-		Partition: partition,
-		Value:     sarama.ByteEncoder(m),
-	})
+	err = transport.Produce(partition, m)
 	if err != nil {
 		logger.Error("failed to send message to partition %d: %v", partition, err)
 		return err
 	}
-	logger.Debug("sent message at partition %d, offset %d", partition, offset)
 	return nil
 }
 
@@ -222,6 +249,22 @@ func Sidecars() []string {
 	return sidecars
 }
 
+// SidecarPartitions returns the partitions assigned to the given sidecar
+func SidecarPartitions(sidecar string) []int32 {
+	mu.RLock()
+	partitions := append([]int32{}, routes[sidecar]...)
+	mu.RUnlock()
+	return partitions
+}
+
+// ActorHosts returns the sidecars hosting the given actor type
+func ActorHosts(t string) []string {
+	mu.RLock()
+	sidecars := append([]string{}, hosts[t]...)
+	mu.RUnlock()
+	return sidecars
+}
+
 func httpSend(address string, message []byte) error {
 	res, err := http.Post("http://"+address+"/kar/v1/system/post", "application/octet-stream", bytes.NewReader(message))
 	if err != nil {
@@ -0,0 +1,58 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+)
+
+func TestRouteToReplica(t *testing.T) {
+	timeout0 := config.MissingComponentTimeout
+	mu.Lock()
+	replicas0, hosts0, routes0 := replicas, hosts, routes
+	replicas = map[string][]string{"svc": {"s1"}}
+	hosts = map[string][]string{}
+	routes = map[string][]int32{"s1": {3}}
+	mu.Unlock()
+	t.Cleanup(func() {
+		config.MissingComponentTimeout = timeout0
+		mu.Lock()
+		replicas, hosts, routes = replicas0, hosts0, routes0
+		mu.Unlock()
+	})
+	config.MissingComponentTimeout = 10 * time.Millisecond
+
+	if partition, sidecar, err := routeToService(context.Background(), "svc"); err != nil || sidecar != "s1" || partition != 3 {
+		t.Errorf("routeToService = %v, %v, %v", partition, sidecar, err)
+	}
+	if _, _, err := routeToService(context.Background(), "other"); err != ErrRouteToServiceTimeout {
+		t.Errorf("routeToService of missing service = %v, want %v", err, ErrRouteToServiceTimeout)
+	}
+	if _, _, err := routeToWorker(context.Background(), "A"); err != ErrRouteToActorTimeout {
+		t.Errorf("routeToWorker of missing type = %v, want %v", err, ErrRouteToActorTimeout)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	config.MissingComponentTimeout = 0
+	if _, _, err := routeToWorker(ctx, "A"); err != context.Canceled {
+		t.Errorf("routeToWorker with cancelled context = %v", err)
+	}
+}
@@ -24,7 +24,7 @@ import (
 )
 
 // Publish publishes a message on a topic
-func Publish(topic string, message []byte) ( /* httpStatusCode */ int, error) {
+func (k *kafkaTransport) Publish(topic string, message []byte) ( /* httpStatusCode */ int, error) {
 	partition, offset, err := producer.SendMessage(&sarama.ProducerMessage{
 		Topic: topic,
 		Value: sarama.ByteEncoder(message),
@@ -39,3 +39,17 @@ func Publish(topic string, message []byte) ( /* httpStatusCode */ int, error) {
 	logger.Debug("sent message on topic %s, partition %d, offset %d", topic, partition, offset)
 	return http.StatusOK, nil
 }
+
+// Produce sends a message to a partition of the application topic
+func (k *kafkaTransport) Produce(partition int32, message []byte) error {
+	_, offset, err := producer.SendMessage(&sarama.ProducerMessage{
+		Topic:     topic,
+		Partition: partition,
+		Value:     sarama.ByteEncoder(message),
+	})
+	if err != nil {
+		return err
+	}
+	logger.Debug("sent message at partition %d, offset %d", partition, offset)
+	return nil
+}
@@ -24,24 +24,34 @@ import (
 	"strconv"
 	"sync"
 
-	"github.com/Shopify/sarama"
 	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/metrics"
 	"github.com/IBM/kar.git/core/internal/store"
 	"github.com/IBM/kar.git/core/pkg/logger"
+	"github.com/Shopify/sarama"
 )
 
+var rebalances = metrics.NewCounterVec("kar_pubsub_rebalances_total", "Consumer group sessions set up by this sidecar by topic.", "topic")
+
 // store key for topic, partition
 func mangle(topic string, partition int32) string {
 	return "pubsub" + config.Separator + topic + config.Separator + strconv.Itoa(int(partition))
 }
 
+// OffsetsKeyPattern returns the store key pattern matching the consumer offsets of the application topic
+func OffsetsKeyPattern() string {
+	return "pubsub" + config.Separator + topic + config.Separator + "*"
+}
+
 // data exchanged when setting up consumer group session for application topic
 type userData struct {
-	Address string                       // ip:port of sidecar
-	Sidecar string                       // id of this sidecar
-	Service string                       // name of this service
-	Actors  []string                     // types of actors implemented by this service
-	Offsets map[int32]map[int64]struct{} // live local offsets
+	Address   string                       // ip:port of sidecar
+	Sidecar   string                       // id of this sidecar
+	Service   string                       // name of this service
+	Actors    []string                     // types of actors implemented by this service
+	Placement map[string]string            // placement strategies of actor types
+	Workers   map[string]int               // stateless worker actor types
+	Offsets   map[int32]map[int64]struct{} // live local offsets
 }
 
 // Options specifies the options for subscribing to a topic
@@ -58,6 +68,11 @@ type Message struct {
 	handler   *handler // hidden
 }
 
+// Partition returns the partition of the application topic the message was received from
+func (e *Message) Partition() int32 {
+	return e.partition
+}
+
 // Mark marks a message as consumed if coming from kafka
 func (e *Message) Mark() error {
 	if e.handler != nil {
@@ -78,6 +93,7 @@ type handler struct {
 	lock    sync.Mutex                   // mutex to protect local map
 	live    map[int32]map[int64]struct{} // offsets in progress at beginning of session (from all sidecars)
 	done    map[int32]map[int64]struct{} // offsets completed at beginning of session (from all sidecars)
+	paused  *pauses                      // paused partitions, nil unless application topic
 }
 
 func newHandler(conf *sarama.Config, topic string, options *Options, f func(Message)) *handler {
@@ -96,7 +112,7 @@ func (h *handler) mark(partition int32, offset int64) error {
 	_, err := store.ZAdd(mangle(h.topic, partition), offset, strconv.FormatInt(offset, 10)) // tell store offset is done first
 	if err != nil {
 		// TODO retry logic
-		logger.Error("failed to mark message on topic %s, partition %d, offset %d: %v", err)
+		logger.Error("failed to mark message on topic %s, partition %d, offset %d: %v", h.topic, partition, offset, err)
 		return err
 	}
 	h.lock.Lock()
@@ -110,11 +126,13 @@ func (h *handler) marshal() {
 	h.lock.Lock()
 	if h.options.master { // exchange metadata and local progress
 		h.conf.Consumer.Group.Member.UserData, _ = json.Marshal(userData{
-			Address: address,
-			Sidecar: config.ID,
-			Service: config.ServiceName,
-			Actors:  config.ActorTypes,
-			Offsets: h.local,
+			Address:   address,
+			Sidecar:   config.ID,
+			Service:   config.ServiceName,
+			Actors:    config.ActorTypes,
+			Placement: config.ActorPlacement,
+			Workers:   config.StatelessWorkers,
+			Offsets:   h.local,
 		})
 	} else {
 		h.conf.Consumer.Group.Member.UserData, _ = json.Marshal(h.local) // exchange only local progress
@@ -124,6 +142,7 @@ func (h *handler) marshal() {
 
 // Setup consumer group session
 func (h *handler) Setup(session sarama.ConsumerGroupSession) error {
+	rebalances.Inc(h.topic)
 	logger.Info("setup session for topic %s, generation %d, claims %v", h.topic, session.GenerationID(), session.Claims()[h.topic])
 
 	admin, err := sarama.NewClusterAdminFromClient(h.client)
@@ -141,6 +160,8 @@ func (h *handler) Setup(session sarama.ConsumerGroupSession) error {
 
 	var rp map[string][]string // temp replicas
 	var hs map[string][]string // temp hosts
+	var pl map[string]string   // temp placement
+	var wk map[string]int      // temp workers
 	var rt map[string][]int32  // temp routes
 	var ad map[string]string   // temp addresses
 
@@ -160,6 +181,8 @@ func (h *handler) Setup(session sarama.ConsumerGroupSession) error {
 		}
 		rp = map[string][]string{}
 		hs = map[string][]string{}
+		pl = map[string]string{}
+		wk = map[string]int{}
 		rt = map[string][]int32{}
 		ad = map[string]string{}
 	}
@@ -200,6 +223,12 @@ func (h *handler) Setup(session sarama.ConsumerGroupSession) error {
 			for _, t := range d.Actors {
 				hs[t] = append(hs[t], d.Sidecar)
 			}
+			for t, strategy := range d.Placement {
+				pl[t] = strategy
+			}
+			for t, n := range d.Workers {
+				wk[t] = n
+			}
 			a, err := member.GetMemberAssignment()
 			if err != nil {
 				logger.Error("failed to parse member assignment: %v", err)
@@ -229,6 +258,8 @@ func (h *handler) Setup(session sarama.ConsumerGroupSession) error {
 		mu.Lock()
 		replicas = rp
 		hosts = hs
+		placement = pl
+		workers = wk
 		routes = rt
 		addresses = ad
 		close(tick)
@@ -278,6 +309,9 @@ func (h *handler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama
 		}
 		h.local[m.Partition][m.Offset] = struct{}{}
 		h.lock.Unlock()
+		if h.paused != nil && !h.paused.wait(session.Context(), m.Partition) {
+			return nil // fail fast
+		}
 		logger.Debug("starting work on topic %s, at partition %d, offset %d", m.Topic, m.Partition, m.Offset)
 		h.f(Message{Value: m.Value, partition: m.Partition, offset: m.Offset, handler: h})
 	}
@@ -287,7 +321,7 @@ func (h *handler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama
 // Subscribe joins a consumer group and consumes messages on a topic
 // f is invoked on each message (serially for each partition)
 // f must return quickly if the context is cancelled
-func Subscribe(ctx context.Context, topic, group string, options *Options, f func(Message)) (<-chan struct{}, int, error) {
+func (k *kafkaTransport) Subscribe(ctx context.Context, topic, group string, options *Options, f func(Message)) (<-chan struct{}, int, error) {
 	if ctx.Err() != nil { // fail fast
 		return nil, http.StatusServiceUnavailable, ctx.Err()
 	}
@@ -304,6 +338,9 @@ func Subscribe(ctx context.Context, topic, group string, options *Options, f fun
 		conf.Consumer.Offsets.Initial = sarama.OffsetOldest
 	}
 	handler := newHandler(conf, topic, options, f)
+	if options.master {
+		handler.paused = &k.paused
+	}
 	handler.marshal()
 	handler.client, err = sarama.NewClient(config.KafkaBrokers, conf)
 	if err != nil {
@@ -0,0 +1,179 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/IBM/kar.git/core/internal/config"
+)
+
+// Transport is the message transport underlying the pubsub API
+//
+// The transport is responsible for delivering messages to the partitions of
+// the application topic and to user topics, and for maintaining the routing
+// tables (replicas, hosts, routes, addresses) when sidecars join or leave.
+type Transport interface {
+	// Dial connects to the transport
+	Dial() error
+
+	// Close disconnects from the transport
+	Close()
+
+	// Join joins the sidecar to the application and returns a channel closed when the consumer stops
+	Join(ctx context.Context, f func(Message), port int) (<-chan struct{}, error)
+
+	// Subscribe joins a consumer group and consumes messages on a topic
+	Subscribe(ctx context.Context, topic, group string, options *Options, f func(Message)) (<-chan struct{}, int, error)
+
+	// Publish publishes a message on a topic
+	Publish(topic string, message []byte) (int, error)
+
+	// Produce sends a message to a partition of the application topic
+	Produce(partition int32, message []byte) error
+
+	// CreateTopic creates a topic using the given parameters
+	CreateTopic(topic string, parameters string) error
+
+	// DeleteTopic deletes a topic
+	DeleteTopic(topic string) error
+
+	// Purge deletes the application topic
+	Purge() error
+
+	// Pause stops the delivery of the messages of a partition of the application topic to this sidecar
+	Pause(partition int32)
+
+	// Resume resumes the delivery of the messages of a paused partition of the application topic
+	Resume(partition int32)
+}
+
+// pauses gates the delivery of the messages of the partitions of the application topic
+type pauses struct {
+	lock  sync.Mutex
+	gates map[int32]chan struct{} // paused partitions -> channels closed on resume
+}
+
+// pause pauses a partition
+func (p *pauses) pause(partition int32) {
+	p.lock.Lock()
+	if p.gates == nil {
+		p.gates = map[int32]chan struct{}{}
+	}
+	if p.gates[partition] == nil {
+		p.gates[partition] = make(chan struct{})
+	}
+	p.lock.Unlock()
+}
+
+// resume resumes a partition
+func (p *pauses) resume(partition int32) {
+	p.lock.Lock()
+	if ch := p.gates[partition]; ch != nil {
+		close(ch)
+		delete(p.gates, partition)
+	}
+	p.lock.Unlock()
+}
+
+// wait waits until a partition is not paused, returns false if the context is cancelled
+func (p *pauses) wait(ctx context.Context, partition int32) bool {
+	p.lock.Lock()
+	ch := p.gates[partition]
+	p.lock.Unlock()
+	if ch == nil {
+		return true
+	}
+	select {
+	case <-ch:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+var (
+	// transport selected at Dial time
+	transport Transport
+
+	// ErrTopicAlreadyExists indicates an attempt to create an existing topic
+	ErrTopicAlreadyExists = errors.New("topic already exists")
+
+	// ErrUnknownTopic indicates an attempt to use a topic that does not exist
+	ErrUnknownTopic = errors.New("unknown topic")
+)
+
+// Dial selects and connects the transport specified in the configuration
+func Dial() error {
+	switch config.Transport {
+	case config.TransportMemory:
+		transport = newMemoryTransport()
+	default:
+		transport = &kafkaTransport{}
+	}
+	return transport.Dial()
+}
+
+// Close closes the transport
+func Close() {
+	transport.Close()
+}
+
+// Join joins the sidecar to the application and returns a channel of incoming messages
+func Join(ctx context.Context, f func(Message), port int) (<-chan struct{}, error) {
+	return transport.Join(ctx, f, port)
+}
+
+// Subscribe joins a consumer group and consumes messages on a topic
+// f is invoked on each message (serially for each partition)
+// f must return quickly if the context is cancelled
+func Subscribe(ctx context.Context, topic, group string, options *Options, f func(Message)) (<-chan struct{}, int, error) {
+	return transport.Subscribe(ctx, topic, group, options, f)
+}
+
+// Publish publishes a message on a topic
+func Publish(topic string, message []byte) ( /* httpStatusCode */ int, error) {
+	return transport.Publish(topic, message)
+}
+
+// CreateTopic attempts to create the specified topic using the given parameters
+func CreateTopic(topic string, parameters string) error {
+	return transport.CreateTopic(topic, parameters)
+}
+
+// DeleteTopic attempts to delete the specified topic
+func DeleteTopic(topic string) error {
+	return transport.DeleteTopic(topic)
+}
+
+// Purge deletes the application topic
+func Purge() error {
+	return transport.Purge()
+}
+
+// Pause stops the delivery of the messages of a partition of the application topic to this sidecar
+// messages already delivered are not affected
+func Pause(partition int32) {
+	transport.Pause(partition)
+}
+
+// Resume resumes the delivery of the messages of a paused partition of the application topic
+func Resume(partition int32) {
+	transport.Resume(partition)
+}
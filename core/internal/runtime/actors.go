@@ -63,13 +63,23 @@ type submapOp struct {
 }
 
 type actorEntry struct {
-	actor   Actor
-	time    time.Time     // last release time
-	lock    chan struct{} // entry lock, never held for long, no need to watch ctx.Done()
-	valid   bool          // false iff entry has been removed from table
-	session string        // current session or "" if none
-	depth   int           // session depth
-	busy    chan struct{} // close to notify end of session
+	actor    Actor
+	time     time.Time      // last release time
+	lock     chan struct{}  // entry lock, never held for long, no need to watch ctx.Done()
+	valid    bool           // false iff entry has been removed from table
+	sessions map[string]int // current sessions -> session depths
+	limit    int            // maximum number of concurrent sessions, 1 unless stateless worker
+	fresh    bool           // true until the sessions of the activation end
+	mailbox  []*waiter      // sessions waiting for the end of the current sessions
+	seq      uint64         // arrival counter for the mailbox
+}
+
+// waiter is a session waiting in an actor mailbox
+type waiter struct {
+	session  string
+	priority int       // higher priorities are dispatched first
+	seq      uint64    // arrival order among waiters with the same priority
+	ready    chan bool // receives true when the session is started, false when the entry is removed
 }
 
 var (
@@ -78,65 +88,108 @@ var (
 	errActorAcquireTimeout = errors.New("timeout occurred while acquiring actor")
 )
 
+// newEntry returns a locked entry for an actor
+// stateless worker actors admit up to the configured number of concurrent sessions
+func newEntry(actor Actor) *actorEntry {
+	e := &actorEntry{actor: actor, lock: make(chan struct{}, 1), sessions: map[string]int{}, limit: 1}
+	if n := config.StatelessWorkers[actor.Type]; n > 0 {
+		e.limit = n
+	}
+	e.lock <- struct{}{} // lock entry
+	return e
+}
+
+// admits returns true if the session can start now, the entry lock must be held
+// an exclusive session excludes all other sessions, no concurrent session starts until activation completes
+func (e *actorEntry) admits(session string) bool {
+	if len(e.sessions) == 0 {
+		return true
+	}
+	if session != "exclusive" && e.sessions[session] > 0 {
+		return true
+	}
+	return session != "exclusive" && e.sessions["exclusive"] == 0 && !e.fresh && len(e.sessions) < e.limit
+}
+
 // acquire locks the actor, session must be not be ""
 // "exclusive" and "reminder" are reserved session names
+// sessions waiting for the actor are started in order of priority then arrival
+// acquire fails immediately if the call chain holds the actor in a current session
 // acquire returns true if actor requires activation before invocation
-func (actor Actor) acquire(ctx context.Context, session string) (*actorEntry, bool, error) {
-	e := &actorEntry{actor: actor, lock: make(chan struct{}, 1)}
-	e.lock <- struct{}{} // lock entry
+func (actor Actor) acquire(ctx context.Context, session string, priority int, chain []link) (*actorEntry, bool, error) {
+	defer actorAcquireWait.Since(time.Now())
+	e := newEntry(actor)
 	for {
 		if v, loaded := actorTable.LoadOrStore(actor, e); loaded {
 			e := v.(*actorEntry) // found existing entry, := is required here!
 			e.lock <- struct{}{} // lock entry
 			if e.valid {
-				if e.session == "" { // start new session
-					e.session = session
-					e.depth = 1
-					e.busy = make(chan struct{})
+				if session == "reminder" || session != "exclusive" && e.sessions[session] > 0 { // reenter existing session
+					e.sessions[session]++
 					<-e.lock
 					return e, false, nil
 				}
-				if session == "reminder" || session != "exclusive" && session == e.session { // reenter existing session
-					e.depth++
+				if len(e.mailbox) == 0 && e.admits(session) { // start new session
+					e.sessions[session] = 1
 					<-e.lock
 					return e, false, nil
 				}
-				// another session is in progress
-				busy := e.busy // read while holding the lock
+				if holds(chain, actor, e.sessions) { // waiting on our own call chain
+					<-e.lock
+					actorDeadlocks.Inc()
+					return nil, false, errActorDeadlock
+				}
+				// wait in mailbox
+				e.seq++
+				w := &waiter{session: session, priority: priority, seq: e.seq, ready: make(chan bool, 1)}
+				e.mailbox = append(e.mailbox, w)
 				<-e.lock
+				var timeout <-chan time.Time
 				if config.ActorBusyTimeout > 0 {
-					select {
-					case <-busy: // wait
-					case <-ctx.Done():
-						return nil, false, ctx.Err()
-					case <-time.After(config.ActorBusyTimeout):
-						return nil, false, errActorAcquireTimeout
+					timer := time.NewTimer(config.ActorBusyTimeout)
+					defer timer.Stop()
+					timeout = timer.C
+				}
+				var started bool
+				select {
+				case started = <-w.ready:
+				case <-ctx.Done():
+					if !e.leave(w) {
+						started = <-w.ready // already dispatched
+						break
 					}
-				} else {
-					select {
-					case <-busy: // wait
-					case <-ctx.Done():
-						return nil, false, ctx.Err()
+					return nil, false, ctx.Err()
+				case <-timeout:
+					if !e.leave(w) {
+						started = <-w.ready // already dispatched
+						break
 					}
+					actorAcquireTimeouts.Inc()
+					return nil, false, errActorAcquireTimeout
 				}
-				// loop around
-				// no fairness issue trying to reacquire because we waited on busy
+				if started {
+					return e, false, nil
+				}
+				// entry was removed, loop around
 			} else {
 				<-e.lock // invalid entry
 				// loop around
-				// no fairness issue trying to reacquire because this entry is dead
 			}
 		} else { // new entry
-			sidecar, err := pubsub.GetSidecar(actor.Type, actor.ID)
-			if err != nil {
-				<-e.lock
-				return nil, false, err
+			sidecar := config.ID // stateless workers are not placed
+			if config.StatelessWorkers[actor.Type] == 0 {
+				var err error
+				sidecar, err = pubsub.GetSidecar(actor.Type, actor.ID)
+				if err != nil {
+					actorTable.Delete(actor)
+					<-e.lock
+					return nil, false, err
+				}
 			}
 			if sidecar == config.ID { // start new session
 				e.valid = true
-				e.session = session
-				e.depth = 1
-				e.busy = make(chan struct{})
+				e.sessions[session] = 1
+				e.fresh = true
 				<-e.lock
 				return e, true, nil
 			}
@@ -147,22 +200,77 @@ func (actor Actor) acquire(ctx context.Context, session string) (*actorEntry, bo
 	}
 }
 
+// leave removes a waiter from the mailbox, returns false if the waiter was already dispatched
+func (e *actorEntry) leave(w *waiter) bool {
+	e.lock <- struct{}{}
+	defer func() { <-e.lock }()
+	for i, x := range e.mailbox {
+		if x == w {
+			e.mailbox = append(e.mailbox[:i], e.mailbox[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// next starts the next sessions in the mailbox if any, the entry lock must be held
+// the waiters for the same session are started together
+func (e *actorEntry) next() {
+	for len(e.mailbox) > 0 {
+		first := 0
+		for i, w := range e.mailbox {
+			if w.priority > e.mailbox[first].priority || w.priority == e.mailbox[first].priority && w.seq < e.mailbox[first].seq {
+				first = i
+			}
+		}
+		session := e.mailbox[first].session
+		if !e.admits(session) {
+			return
+		}
+		mailbox := e.mailbox[:0]
+		for i, w := range e.mailbox {
+			if i == first || session != "exclusive" && w.session == session {
+				e.sessions[session]++
+				w.ready <- true
+			} else {
+				mailbox = append(mailbox, w)
+			}
+		}
+		e.mailbox = mailbox
+	}
+}
+
+// remove removes the entry from the table and wakes up all waiters, the entry lock must be held
+func (e *actorEntry) remove() {
+	e.sessions = map[string]int{}
+	if e.valid {
+		e.valid = false
+		actorTable.Delete(e.actor)
+	}
+	for _, w := range e.mailbox {
+		w.ready <- false
+	}
+	e.mailbox = nil
+}
+
 // release releases the actor lock
 // release updates the timestamp if the actor was invoked
-// release removes the actor from the table if it was not activated at depth 0
+// release removes the actor from the table if it was not activated when the last session ends
 func (e *actorEntry) release(session string, invoked bool) {
 	e.lock <- struct{}{} // lock entry
-	e.depth--
+	e.sessions[session]--
 	if invoked {
 		e.time = time.Now() // update last release time
 	}
-	if e.depth == 0 { // end session
-		if !invoked { // actor was not activated
-			e.valid = false
-			actorTable.Delete(e.actor)
+	if e.sessions[session] == 0 { // end session
+		delete(e.sessions, session)
+		if len(e.sessions) == 0 {
+			e.fresh = false
+			if !invoked { // actor was not activated
+				e.remove()
+			}
 		}
-		e.session = ""
-		close(e.busy)
+		e.next()
 	}
 	<-e.lock
 }
@@ -173,20 +281,18 @@ func collect(ctx context.Context, time time.Time) error {
 		e := v.(*actorEntry)
 		select {
 		case e.lock <- struct{}{}: // try acquire
-			if e.valid && e.session == "" && e.time.Before(time) {
-				e.depth = 1
-				e.session = "exclusive"
-				e.busy = make(chan struct{})
+			if e.valid && len(e.sessions) == 0 && e.time.Before(time) {
+				e.sessions["exclusive"] = 1
 				<-e.lock
 				err := deactivate(ctx, actor.(Actor))
 				e.lock <- struct{}{}
-				e.depth--
-				e.session = ""
+				delete(e.sessions, "exclusive")
 				if err == nil {
-					e.valid = false
-					actorTable.Delete(actor)
+					e.remove()
+					touchActor(e.actor, e.time)
+				} else {
+					e.next()
 				}
-				close(e.busy)
 			}
 			<-e.lock
 		default:
@@ -246,6 +352,89 @@ func getAllActiveActors(ctx context.Context, targetedActorType string) (map[stri
 	return information, nil
 }
 
+// getMyMailboxes returns a map of actor types -> active IDs -> mailbox lengths in this sidecar
+func getMyMailboxes(targetedActorType string) map[string]map[string]int {
+	information := make(map[string]map[string]int)
+	actorTable.Range(func(actor, v interface{}) bool {
+		e := v.(*actorEntry)
+		e.lock <- struct{}{}
+		if e.valid {
+			if targetedActorType == "" || targetedActorType == e.actor.Type {
+				if information[e.actor.Type] == nil {
+					information[e.actor.Type] = make(map[string]int)
+				}
+				information[e.actor.Type][e.actor.ID] = len(e.mailbox)
+			}
+		}
+		<-e.lock
+		return true
+	})
+	return information
+}
+
+// getAllMailboxes returns a map of actor types -> active IDs -> mailbox lengths for all sidecars in the app
+func getAllMailboxes(ctx context.Context, targetedActorType string) (map[string]map[string]int, error) {
+	information := make(map[string]map[string]int)
+	for _, sidecar := range pubsub.Sidecars() {
+		var mailboxes map[string]map[string]int
+		if sidecar != config.ID {
+			msg := map[string]string{
+				"protocol":  "sidecar",
+				"sidecar":   sidecar,
+				"command":   "getActiveActors",
+				"actorType": targetedActorType,
+				"mailbox":   "true",
+			}
+			actorReply, err := callHelper(ctx, msg, false)
+			if err != nil || actorReply.StatusCode != 200 {
+				logger.Debug("Error gathering mailbox information: %v", err)
+				return nil, err
+			}
+			err = json.Unmarshal([]byte(actorReply.Payload), &mailboxes)
+			if err != nil {
+				logger.Debug("Error unmarshaling mailbox information: %v", err)
+				return nil, err
+			}
+		} else {
+			mailboxes = getMyMailboxes(targetedActorType)
+		}
+		for actorType, lengths := range mailboxes { // accumulate sidecar's info into information
+			if information[actorType] == nil {
+				information[actorType] = make(map[string]int)
+			}
+			for actorID, length := range lengths {
+				information[actorType][actorID] = length
+			}
+		}
+	}
+	return information, nil
+}
+
+func formatMailboxMap(mailboxes map[string]map[string]int, format string) (string, error) {
+	if format == "json" || format == "application/json" {
+		m, err := json.MarshalIndent(mailboxes, "", "  ")
+		if err != nil {
+			logger.Debug("Error marshaling mailbox information: %v", err)
+			return "", err
+		}
+		return string(m), nil
+	}
+	var str strings.Builder
+	for actorType, lengths := range mailboxes {
+		actorIDs := make([]string, 0, len(lengths))
+		for actorID := range lengths {
+			actorIDs = append(actorIDs, actorID)
+		}
+		sort.Strings(actorIDs)
+		fmt.Fprintf(&str, "%v: [\n", actorType)
+		for _, actorID := range actorIDs {
+			fmt.Fprintf(&str, "    %v: %v\n", actorID, lengths[actorID])
+		}
+		fmt.Fprintf(&str, "]\n")
+	}
+	return str.String(), nil
+}
+
 func formatActorInstanceMap(actorInfo map[string][]string, format string) (string, error) {
 	if format == "json" || format == "application/json" {
 		var m []byte
@@ -272,12 +461,13 @@ func formatActorInstanceMap(actorInfo map[string][]string, format string) (strin
 // the lock cannot be held multiple times
 func (e *actorEntry) migrate(sidecar string) error {
 	e.lock <- struct{}{}
-	e.depth--
-	e.session = ""
 	e.valid = false
 	actorTable.Delete(e.actor)
-	_, err := pubsub.CompareAndSetSidecar(e.actor.Type, e.actor.ID, config.ID, sidecar)
-	close(e.busy)
+	var err error
+	if config.StatelessWorkers[e.actor.Type] == 0 { // stateless workers are not placed
+		_, err = pubsub.CompareAndSetSidecar(e.actor.Type, e.actor.ID, config.ID, sidecar)
+	}
+	e.remove() // wake up waiters after updating the placement
 	<-e.lock
 	return err
 }
@@ -0,0 +1,171 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// activeEntry adds an active entry for an actor to the actor table
+func activeEntry(t *testing.T, actor Actor) *actorEntry {
+	e := newEntry(actor)
+	e.valid = true
+	<-e.lock
+	actorTable.Store(actor, e)
+	t.Cleanup(func() { actorTable.Delete(actor) })
+	return e
+}
+
+// mailboxLength returns the number of waiters in the mailbox of an entry
+func mailboxLength(e *actorEntry) int {
+	e.lock <- struct{}{}
+	defer func() { <-e.lock }()
+	return len(e.mailbox)
+}
+
+// enqueue starts acquiring the actor and waits for the session to reach the mailbox
+// the session is sent on started once acquired
+func enqueue(t *testing.T, ctx context.Context, e *actorEntry, session string, priority int, started chan<- string) <-chan error {
+	errs := make(chan error, 1)
+	n := mailboxLength(e)
+	go func() {
+		_, _, err := e.actor.acquire(ctx, session, priority, nil)
+		if err == nil {
+			started <- session
+		}
+		errs <- err
+	}()
+	for deadline := time.Now().Add(time.Second); mailboxLength(e) == n; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("session %s did not wait in mailbox", session)
+		}
+	}
+	return errs
+}
+
+func receive(t *testing.T, started <-chan string) string {
+	select {
+	case session := <-started:
+		return session
+	case <-time.After(time.Second):
+		t.Fatal("no session started")
+		return ""
+	}
+}
+
+func TestMailboxOrder(t *testing.T) {
+	ctx := context.Background()
+	e := activeEntry(t, Actor{Type: "mailbox", ID: "order"})
+	if _, fresh, err := e.actor.acquire(ctx, "s0", 0, nil); err != nil || fresh {
+		t.Fatalf("acquire = %v, %v", fresh, err)
+	}
+	started := make(chan string, 4)
+	enqueue(t, ctx, e, "a", 0, started)
+	enqueue(t, ctx, e, "b", 1, started)
+	enqueue(t, ctx, e, "c", 0, started)
+	enqueue(t, ctx, e, "d", 1, started)
+
+	order := []string{}
+	session := "s0"
+	for i := 0; i < 4; i++ {
+		e.release(session, true)
+		session = receive(t, started)
+		order = append(order, session)
+	}
+	e.release(session, true)
+	if want := []string{"b", "d", "a", "c"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+	if len(e.sessions) != 0 || len(e.mailbox) != 0 {
+		t.Errorf("sessions = %v, mailbox = %v after release", e.sessions, e.mailbox)
+	}
+}
+
+func TestMailboxSessions(t *testing.T) {
+	ctx := context.Background()
+	e := activeEntry(t, Actor{Type: "mailbox", ID: "sessions"})
+	if _, _, err := e.actor.acquire(ctx, "s0", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan string, 5)
+	enqueue(t, ctx, e, "x", 0, started)
+	enqueue(t, ctx, e, "exclusive", 0, started)
+	enqueue(t, ctx, e, "x", 0, started)
+	enqueue(t, ctx, e, "exclusive", 0, started)
+
+	e.release("s0", true) // both waiters for x start together
+	if a, b := receive(t, started), receive(t, started); a != "x" || b != "x" {
+		t.Fatalf("started %s and %s, want x twice", a, b)
+	}
+	if n := mailboxLength(e); n != 2 {
+		t.Fatalf("mailbox length = %d, want 2", n)
+	}
+	e.release("x", true)
+	e.release("x", true) // exclusive sessions start one at a time
+	if s := receive(t, started); s != "exclusive" {
+		t.Fatalf("started %s, want exclusive", s)
+	}
+	if n := mailboxLength(e); n != 1 {
+		t.Fatalf("mailbox length = %d, want 1", n)
+	}
+	e.release("exclusive", true)
+	receive(t, started)
+	e.release("exclusive", true)
+}
+
+func TestMailboxLeave(t *testing.T) {
+	e := activeEntry(t, Actor{Type: "mailbox", ID: "leave"})
+	if _, _, err := e.actor.acquire(context.Background(), "s0", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan string, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := enqueue(t, ctx, e, "a", 1, started)
+	enqueue(t, context.Background(), e, "b", 0, started)
+	cancel()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("acquire = %v, want %v", err, context.Canceled)
+	}
+	e.release("s0", true)
+	if s := receive(t, started); s != "b" {
+		t.Fatalf("started %s, want b", s)
+	}
+	e.release("b", true)
+}
+
+func TestMailboxDeadlock(t *testing.T) {
+	ctx := context.Background()
+	e := activeEntry(t, Actor{Type: "mailbox", ID: "deadlock"})
+	if _, _, err := e.actor.acquire(ctx, "s0", 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	chain := []link{{Type: "other", ID: "o", Session: "s1"}, {Type: e.actor.Type, ID: e.actor.ID, Session: "s0"}}
+	if _, _, err := e.actor.acquire(ctx, "s2", 0, chain); err != errActorDeadlock {
+		t.Errorf("acquire = %v, want %v", err, errActorDeadlock)
+	}
+	if _, _, err := e.actor.acquire(ctx, "s0", 0, chain); err != nil { // reentrant call
+		t.Errorf("acquire = %v", err)
+	}
+	e.release("s0", true)
+	e.release("s0", true)
+	if n := mailboxLength(e); n != 0 {
+		t.Errorf("mailbox length = %d, want 0", n)
+	}
+}
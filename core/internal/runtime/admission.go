@@ -0,0 +1,209 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+/*
+ * This file contains the admission control of incoming messages.
+ *
+ * Calls and tells are admitted for processing only if the numbers of messages in flight
+ * for the sidecar and for the target actor type are below the configured caps.
+ * Messages that cannot be admitted are queued by actor type (or service) without blocking
+ * the consumption of the partition, so that callbacks, sidecar messages, and messages
+ * to other actor types are still delivered. Queued messages are admitted in arrival order
+ * as in-flight messages complete.
+ *
+ * The number of queued messages per partition is bounded. The consumption of a partition
+ * is paused once the bound is reached and resumed once queued messages are admitted.
+ * Queued messages are not marked as consumed and are redelivered if the sidecar fails
+ * or the partition is reassigned.
+ *
+ * Nested calls within an existing actor session or call chain are always admitted
+ * to avoid deadlocks.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/pubsub"
+)
+
+// label for the messages to services in the in-flight counts
+const serviceLabel = "service"
+
+// pending is a message awaiting admission
+type pending struct {
+	seq       int64             // arrival order
+	partition int32             // partition of the message
+	run       func(done func()) // processes the message, done must be invoked once the message has been processed
+}
+
+var (
+	admissionMutex = &sync.Mutex{}
+	admitted       = map[string]int{}        // in-flight messages by actor type or service label
+	waiting        = map[string]int{}        // messages awaiting admission by actor type or service label
+	queues         = map[string][]*pending{} // messages awaiting admission by actor type or service label, in arrival order
+	inFlightTotal  = 0                       // in-flight messages
+	arrivals       int64                     // number of messages queued so far
+	held           = map[int32]int{}         // messages awaiting admission by partition
+	paused         = map[int32]bool{}        // partitions paused by admission control
+
+	queueBound = 256 // max number of messages awaiting admission per partition before pausing the partition
+
+	pause  = pubsub.Pause  // pauses the consumption of a partition
+	resume = pubsub.Resume // resumes the consumption of a partition
+)
+
+// admit runs a message now if it can be processed without exceeding the in-flight caps
+// or queues it until it can, admit never blocks but pauses the partition if too many messages are queued
+// run must invoke done once the message has been processed
+// queued messages are dropped if the context is cancelled
+func admit(ctx context.Context, value []byte, partition int32, run func(done func())) {
+	var msg struct {
+		Protocol string `json:"protocol"`
+		Service  string `json:"service"`
+		Type     string `json:"type"`
+		Command  string `json:"command"`
+		Session  string `json:"session"`
+		Chain    string `json:"chain"`
+	}
+	json.Unmarshal(value, &msg) // malformed messages are admitted and dropped by Process
+	if msg.Command != "call" && msg.Command != "tell" || msg.Session != "" || msg.Chain != "" {
+		run(func() {})
+		return
+	}
+	label := serviceLabel
+	if msg.Protocol == "actor" {
+		label = msg.Type
+	} else if msg.Protocol != "service" || msg.Service != config.ServiceName {
+		run(func() {}) // message to forward
+		return
+	}
+
+	admissionMutex.Lock()
+	if len(queues[label]) == 0 && fits(label) {
+		take(label)
+		admissionMutex.Unlock()
+		run(release(ctx, label))
+		return
+	}
+	arrivals++
+	queues[label] = append(queues[label], &pending{seq: arrivals, partition: partition, run: run})
+	waiting[label]++
+	held[partition]++
+	if held[partition] >= queueBound && !paused[partition] {
+		paused[partition] = true
+		pause(partition)
+	}
+	admissionMutex.Unlock()
+}
+
+// fits returns true if a message with the given label can be admitted
+// must be called while holding the admission mutex
+func fits(label string) bool {
+	if config.MaxInFlight > 0 && inFlightTotal >= config.MaxInFlight {
+		return false
+	}
+	n := config.ActorMaxInFlight[label]
+	return label == serviceLabel || n <= 0 || admitted[label] < n
+}
+
+// take records the admission of a message with the given label
+// must be called while holding the admission mutex
+func take(label string) {
+	admitted[label]++
+	inFlightTotal++
+}
+
+// release returns the function to invoke once an admitted message has been processed
+// the function admits the oldest queued messages that fit
+func release(ctx context.Context, label string) func() {
+	return func() {
+		admissionMutex.Lock()
+		admitted[label]--
+		if admitted[label] == 0 {
+			delete(admitted, label)
+		}
+		inFlightTotal--
+		var next []func()
+		for {
+			l := oldest()
+			if l == "" {
+				break
+			}
+			p := queues[l][0]
+			queues[l] = queues[l][1:]
+			if len(queues[l]) == 0 {
+				delete(queues, l)
+			}
+			waiting[l]--
+			if waiting[l] == 0 {
+				delete(waiting, l)
+			}
+			unhold(p.partition)
+			if ctx.Err() != nil { // drop queued message
+				continue
+			}
+			take(l)
+			next = append(next, func() { p.run(release(ctx, l)) })
+		}
+		admissionMutex.Unlock()
+		for _, f := range next {
+			f()
+		}
+	}
+}
+
+// unhold records the dequeuing of a message of a partition and resumes the partition if paused
+// must be called while holding the admission mutex
+func unhold(partition int32) {
+	held[partition]--
+	if held[partition] == 0 {
+		delete(held, partition)
+	}
+	if paused[partition] && held[partition] < queueBound {
+		delete(paused, partition)
+		resume(partition)
+	}
+}
+
+// oldest returns the label of the oldest queued message that fits if any
+// must be called while holding the admission mutex
+func oldest() string {
+	label := ""
+	var seq int64
+	for l, q := range queues {
+		if (label == "" || q[0].seq < seq) && fits(l) {
+			label = l
+			seq = q[0].seq
+		}
+	}
+	return label
+}
+
+// messageCounts returns a snapshot of a message count
+func messageCounts(counts map[string]int) map[string]float64 {
+	admissionMutex.Lock()
+	defer admissionMutex.Unlock()
+	m := make(map[string]float64, len(counts))
+	for label, n := range counts {
+		m[label] = float64(n)
+	}
+	return m
+}
@@ -0,0 +1,169 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/IBM/kar.git/core/internal/config"
+)
+
+// admissionTest records the messages started by admit and their done functions
+type admissionTest struct {
+	started []string
+	done    map[string]func()
+}
+
+func (a *admissionTest) admit(ctx context.Context, name string, msg map[string]string) {
+	value, _ := json.Marshal(msg)
+	admit(ctx, value, 0, func(done func()) {
+		a.started = append(a.started, name)
+		a.done[name] = done
+	})
+}
+
+func (a *admissionTest) finish(name string) {
+	a.done[name]()
+}
+
+func setAdmissionLimits(t *testing.T, max int, actorMax map[string]int) {
+	max0, actorMax0, service0 := config.MaxInFlight, config.ActorMaxInFlight, config.ServiceName
+	t.Cleanup(func() { config.MaxInFlight, config.ActorMaxInFlight, config.ServiceName = max0, actorMax0, service0 })
+	config.MaxInFlight, config.ActorMaxInFlight, config.ServiceName = max, actorMax, "svc"
+}
+
+func callMessage(actorType string) map[string]string {
+	return map[string]string{"protocol": "actor", "type": actorType, "id": "a", "command": "call"}
+}
+
+func TestAdmissionPerType(t *testing.T) {
+	setAdmissionLimits(t, 0, map[string]int{"A": 1})
+	a := &admissionTest{done: map[string]func(){}}
+	ctx := context.Background()
+
+	a.admit(ctx, "a1", callMessage("A"))
+	a.admit(ctx, "a2", callMessage("A"))
+	a.admit(ctx, "b1", callMessage("B"))                                                                                                              // other type is not blocked
+	a.admit(ctx, "callback", map[string]string{"protocol": "sidecar", "command": "response"})                                                         // callback is not blocked
+	a.admit(ctx, "nested", map[string]string{"protocol": "actor", "type": "A", "command": "call", "session": "s"})                                    // nested call
+	a.admit(ctx, "chained", map[string]string{"protocol": "actor", "type": "A", "command": "call", "chain": `[{"type":"B","id":"b","session":"s"}]`}) // nested call without session
+	a.admit(ctx, "a3", callMessage("A"))
+	if want := []string{"a1", "b1", "callback", "nested", "chained"}; !reflect.DeepEqual(a.started, want) {
+		t.Fatalf("started %v, want %v", a.started, want)
+	}
+	if w := messageCounts(waiting); w["A"] != 2 {
+		t.Errorf("waiting = %v, want 2 for A", w)
+	}
+	a.finish("b1")
+	if len(a.started) != 5 {
+		t.Fatalf("completing another type admitted %v", a.started[5:])
+	}
+	a.finish("a1")
+	a.finish("a2")
+	if want := []string{"a1", "b1", "callback", "nested", "chained", "a2", "a3"}; !reflect.DeepEqual(a.started, want) {
+		t.Fatalf("started %v, want %v", a.started, want)
+	}
+	a.finish("a3")
+	if n := messageCounts(admitted); len(n) != 0 {
+		t.Errorf("admitted = %v after completion", n)
+	}
+	if w := messageCounts(waiting); len(w) != 0 {
+		t.Errorf("waiting = %v after completion", w)
+	}
+}
+
+func TestAdmissionGlobal(t *testing.T) {
+	setAdmissionLimits(t, 2, map[string]int{"A": 1})
+	a := &admissionTest{done: map[string]func(){}}
+	ctx := context.Background()
+
+	a.admit(ctx, "a1", callMessage("A"))
+	a.admit(ctx, "a2", callMessage("A"))
+	a.admit(ctx, "s1", map[string]string{"protocol": "service", "service": "svc", "command": "tell"})
+	a.admit(ctx, "b1", callMessage("B"))
+	a.admit(ctx, "fwd", map[string]string{"protocol": "service", "service": "other", "command": "call"}) // forwarded
+	if want := []string{"a1", "s1", "fwd"}; !reflect.DeepEqual(a.started, want) {
+		t.Fatalf("started %v, want %v", a.started, want)
+	}
+	a.finish("s1") // a2 is older than b1 but A is full
+	if want := []string{"a1", "s1", "fwd", "b1"}; !reflect.DeepEqual(a.started, want) {
+		t.Fatalf("started %v, want %v", a.started, want)
+	}
+	a.finish("a1")
+	if want := []string{"a1", "s1", "fwd", "b1", "a2"}; !reflect.DeepEqual(a.started, want) {
+		t.Fatalf("started %v, want %v", a.started, want)
+	}
+	a.finish("b1")
+	a.finish("a2")
+	if n := messageCounts(admitted); len(n) != 0 || inFlightTotal != 0 {
+		t.Errorf("admitted = %v, total %v after completion", n, inFlightTotal)
+	}
+}
+
+func TestAdmissionCancelled(t *testing.T) {
+	setAdmissionLimits(t, 1, nil)
+	a := &admissionTest{done: map[string]func(){}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	a.admit(ctx, "a1", callMessage("A"))
+	a.admit(ctx, "a2", callMessage("A"))
+	cancel()
+	a.finish("a1")
+	if want := []string{"a1"}; !reflect.DeepEqual(a.started, want) {
+		t.Fatalf("started %v, want %v", a.started, want)
+	}
+	if w := messageCounts(waiting); len(w) != 0 {
+		t.Errorf("waiting = %v after cancellation", w)
+	}
+}
+
+func TestAdmissionPause(t *testing.T) {
+	setAdmissionLimits(t, 1, nil)
+	bound0, pause0, resume0 := queueBound, pause, resume
+	t.Cleanup(func() { queueBound, pause, resume = bound0, pause0, resume0 })
+	var events []string
+	queueBound = 2
+	pause = func(int32) { events = append(events, "pause") }
+	resume = func(int32) { events = append(events, "resume") }
+	a := &admissionTest{done: map[string]func(){}}
+	ctx := context.Background()
+
+	a.admit(ctx, "a1", callMessage("A"))
+	a.admit(ctx, "a2", callMessage("A"))
+	if len(events) != 0 {
+		t.Fatalf("events %v before reaching the bound", events)
+	}
+	a.admit(ctx, "a3", callMessage("A"))
+	if want := []string{"pause"}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("events %v, want %v", events, want)
+	}
+	a.finish("a1")
+	if want := []string{"pause", "resume"}; !reflect.DeepEqual(events, want) {
+		t.Fatalf("events %v, want %v", events, want)
+	}
+	a.finish("a2")
+	a.finish("a3")
+	if want := []string{"a1", "a2", "a3"}; !reflect.DeepEqual(a.started, want) {
+		t.Fatalf("started %v, want %v", a.started, want)
+	}
+	if len(held) != 0 || len(paused) != 0 {
+		t.Errorf("held = %v, paused = %v after completion", held, paused)
+	}
+}
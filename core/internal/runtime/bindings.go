@@ -18,11 +18,15 @@ package runtime
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"math/rand"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/IBM/kar.git/core/internal/config"
 	"github.com/IBM/kar.git/core/internal/pubsub"
@@ -186,3 +190,114 @@ func loadBindings(ctx context.Context, partitions []int32) error {
 	logger.Debug("loadBindings completed")
 	return nil
 }
+
+// move all the bindings of an actor to a partition of the given sidecar
+// bindings already on a partition of the sidecar are not moved
+func migrateBindings(ctx context.Context, actor Actor, sidecar string) error {
+	owned := map[string]bool{} // partitions of the target sidecar
+	for _, p := range pubsub.SidecarPartitions(sidecar) {
+		owned[strconv.Itoa(int(p))] = true
+	}
+	for kind, pair := range pairs {
+		pair.mu.Lock()
+		pair.bindings.cancel(actor, "") // remove from memory
+		keys, err := store.Keys(bindingKey(kind, actor, "*", "*"))
+		if err != nil {
+			pair.mu.Unlock()
+			return err
+		}
+		moved := map[string]string{} // binding id -> new partition
+		for _, key := range keys {
+			_, _, partition, id := keyBinding(key)
+			if owned[partition] { // no need to move
+				moved[id] = partition
+				continue
+			}
+			p, err := pubsub.RouteToSidecar(sidecar)
+			if err != nil {
+				pair.mu.Unlock()
+				return err
+			}
+			partition = strconv.Itoa(int(p))
+			n, err := store.Rename(key, bindingKey(kind, actor, partition, id))
+			if err != nil {
+				pair.mu.Unlock()
+				return err
+			}
+			if n == 0 { // binding no longer exists
+				continue
+			}
+			moved[id] = partition
+		}
+		pair.mu.Unlock()
+		logger.Debug("migrated %v %v of %v to sidecar %v", len(moved), kind, actor, sidecar)
+		for id, partition := range moved { // load bindings in new sidecar
+			if err := tellBinding(ctx, kind, actor, partition, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// list the persisted bindings of a kind across all partitions
+// optionally restricted to an actor type and an actor instance
+func listBindings(kind, actorType, actorID string) ([]binding, error) {
+	if actorType == "" {
+		actorType = "*"
+	}
+	if actorID == "" {
+		actorID = "*"
+	}
+	keys, err := store.Keys(bindingKey(kind, Actor{Type: actorType, ID: actorID}, "*", "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	found := make([]binding, 0, len(keys))
+	for _, key := range keys {
+		_, actor, _, id := keyBinding(key)
+		data, err := store.HGetAll(key)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 { // binding no longer exists
+			continue
+		}
+		b, err := pairs[kind].bindings.load(actor, id, key, data)
+		if err != nil {
+			logger.Warning("skipping malformed binding %v: %v", key, err)
+			continue
+		}
+		found = append(found, b)
+	}
+	return found, nil
+}
+
+// format a list of bindings as JSON or as human-readable text
+func formatBindings(found []binding, format string) (string, error) {
+	if format == "json" || format == "application/json" {
+		m, err := json.MarshalIndent(found, "", "  ")
+		if err != nil {
+			logger.Debug("Error marshaling bindings: %v", err)
+			return "", err
+		}
+		return string(m), nil
+	}
+	var str strings.Builder
+	for _, b := range found {
+		switch b := b.(type) {
+		case Reminder:
+			schedule := "once"
+			if b.Cron != "" {
+				schedule = "cron " + b.Cron
+			} else if b.Period > 0 {
+				schedule = "every " + b.Period.String()
+			}
+			fmt.Fprintf(&str, "%v[%v] %v: path %v, next %v, %v\n", b.Actor.Type, b.Actor.ID, b.ID, b.Path, b.TargetTime.Format(time.RFC3339), schedule)
+		case source:
+			fmt.Fprintf(&str, "%v[%v] %v: path %v, topic %v\n", b.Actor.Type, b.Actor.ID, b.ID, b.Path, b.Topic)
+		}
+	}
+	return str.String(), nil
+}
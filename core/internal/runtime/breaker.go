@@ -0,0 +1,229 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+/*
+ * This file contains the circuit breakers and the bulkhead protecting the application process.
+ *
+ * A circuit breaker tracks the requests to an actor type or the first segment of a service path.
+ * It opens after a number of consecutive failures. While open, requests fail fast.
+ * After some time, one trial request is let through (half-open state). The breaker closes
+ * if the trial request succeeds and opens again otherwise.
+ *
+ * The bulkhead bounds the number of concurrent requests to the application process.
+ * Requests wait for a slot, except for actor activations and deactivations and nested
+ * actor calls, i.e., calls made by an actor method in progress, since the caller may hold
+ * the last slot. Nested service calls cannot be identified and may still wait.
+ *
+ * Requests rejected by a circuit breaker are not invoked, hence not recorded as failures,
+ * and do not wait for a slot.
+ */
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/pkg/logger"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// breaker is the circuit breaker of an actor type or path prefix
+type breaker struct {
+	State    string    `json:"state"`
+	Failures int       `json:"failures"` // consecutive failures
+	OpenedAt time.Time `json:"openedAt"` // time of the last transition to the open state
+	trialAt  time.Time // start time of the trial request in progress if any
+}
+
+// bulkhead is the state of the bulkhead
+type bulkhead struct {
+	InFlight int `json:"inFlight"`
+	Limit    int `json:"limit"` // 0 if unlimited
+}
+
+var (
+	breakers      = map[string]*breaker{}
+	breakersMutex = &sync.Mutex{}
+
+	// bulkhead slots, nil if unlimited
+	inFlight chan struct{}
+
+	// number of requests to the application in progress, excluding exempt requests
+	inFlightCount int64
+)
+
+func newSlots(n int) chan struct{} {
+	if n <= 0 {
+		return nil
+	}
+	return make(chan struct{}, n)
+}
+
+// breakerKey returns the actor type or the first segment of the path of a request to the application
+func breakerKey(path string) string {
+	if strings.HasPrefix(path, actorRuntimeRoutePrefix) {
+		return "actor:" + strings.SplitN(strings.TrimPrefix(path, actorRuntimeRoutePrefix), "/", 2)[0]
+	}
+	return "/" + strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
+
+// allow returns true if the circuit breaker for key lets a request through
+func allow(key string) bool {
+	if config.BreakerFailures <= 0 {
+		return true
+	}
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	b := breakers[key]
+	if b == nil {
+		return true
+	}
+	switch b.State {
+	case breakerOpen:
+		if time.Since(b.OpenedAt) < config.BreakerOpenDuration {
+			return false
+		}
+		b.State = breakerHalfOpen
+		b.trialAt = time.Now()
+		logger.Info("circuit breaker for %s is half-open", key)
+		return true
+	case breakerHalfOpen:
+		// only one trial request at a time unless the trial request did not complete in time
+		if !b.trialAt.IsZero() && time.Since(b.trialAt) < config.BreakerOpenDuration {
+			return false
+		}
+		b.trialAt = time.Now()
+		return true
+	}
+	return true
+}
+
+// record records the outcome of a request to the application through the circuit breaker for key
+func record(key string, failed bool) {
+	if config.BreakerFailures <= 0 {
+		return
+	}
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	b := breakers[key]
+	if b == nil {
+		if !failed {
+			return
+		}
+		b = &breaker{State: breakerClosed}
+		breakers[key] = b
+	}
+	b.trialAt = time.Time{}
+	if !failed {
+		if b.State != breakerClosed {
+			logger.Info("circuit breaker for %s is closed", key)
+		}
+		delete(breakers, key)
+		return
+	}
+	b.Failures++
+	if b.State == breakerHalfOpen || b.Failures >= config.BreakerFailures {
+		if b.State != breakerOpen {
+			logger.Warning("circuit breaker for %s is open after %v consecutive failures", key, b.Failures)
+		}
+		b.State = breakerOpen
+		b.OpenedAt = time.Now()
+	}
+}
+
+// isOpen returns true if the circuit breaker for key is open
+func isOpen(key string) bool {
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	b := breakers[key]
+	return b != nil && b.State == breakerOpen
+}
+
+// failure returns true if a request to the application failed
+func failure(reply *Reply, err error) bool {
+	return err != nil || reply == nil || reply.StatusCode >= http.StatusInternalServerError || reply.StatusCode == http.StatusRequestTimeout
+}
+
+// getBreakers returns a snapshot of the circuit breakers that are open or have recent failures
+func getBreakers() map[string]breaker {
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	m := make(map[string]breaker, len(breakers))
+	for k, b := range breakers {
+		m[k] = *b
+	}
+	return m
+}
+
+// exempt returns true if a request to the application does not need a bulkhead slot,
+// i.e., the request activates, deactivates, or checks an actor type instead of invoking a method
+func exempt(path string) bool {
+	if !strings.HasPrefix(path, actorRuntimeRoutePrefix) {
+		return false
+	}
+	return strings.Count(strings.TrimPrefix(path, actorRuntimeRoutePrefix), "/") < 2
+}
+
+// nested returns true if a request to the application is an actor call made by an actor method in progress
+func nested(msg map[string]string) bool {
+	return len(decodeChain(msg["chain"])) > 1 // the chain includes the invoked actor
+}
+
+// acquireSlot waits for a bulkhead slot if needed and returns true if the request may proceed
+// nested requests proceed without a slot
+func acquireSlot(ctx context.Context, path string, nested bool) bool {
+	if exempt(path) {
+		return true
+	}
+	atomic.AddInt64(&inFlightCount, 1)
+	if inFlight == nil || nested {
+		return true
+	}
+	select {
+	case inFlight <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		atomic.AddInt64(&inFlightCount, -1)
+		return false
+	}
+}
+
+// releaseSlot releases the bulkhead slot acquired for a request if any
+func releaseSlot(path string, nested bool) {
+	if exempt(path) {
+		return
+	}
+	atomic.AddInt64(&inFlightCount, -1)
+	if inFlight != nil && !nested {
+		<-inFlight
+	}
+}
+
+// getBulkhead returns the state of the bulkhead
+func getBulkhead() bulkhead {
+	return bulkhead{InFlight: int(atomic.LoadInt64(&inFlightCount)), Limit: cap(inFlight)}
+}
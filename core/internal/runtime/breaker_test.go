@@ -0,0 +1,162 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+)
+
+func TestBreakerKey(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{actorRuntimeRoutePrefix + "Foo/a/session/method", "actor:Foo"},
+		{actorRuntimeRoutePrefix + "Foo", "actor:Foo"},
+		{"/orders/42", "/orders"},
+		{"/", "/"},
+	}
+	for _, tt := range tests {
+		if got := breakerKey(tt.path); got != tt.want {
+			t.Errorf("breakerKey(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestBreakerStates(t *testing.T) {
+	defer func(failures int, open time.Duration) {
+		config.BreakerFailures, config.BreakerOpenDuration = failures, open
+	}(config.BreakerFailures, config.BreakerOpenDuration)
+	config.BreakerFailures = 2
+	config.BreakerOpenDuration = 20 * time.Millisecond
+	key := "actor:TestBreakerStates"
+
+	record(key, false)
+	if len(getBreakers()) != 0 {
+		t.Errorf("a success created a breaker")
+	}
+	record(key, true)
+	if !allow(key) || isOpen(key) {
+		t.Fatalf("breaker opened before the failure threshold")
+	}
+	record(key, false) // resets the consecutive failures
+	record(key, true)
+	if isOpen(key) {
+		t.Fatalf("breaker counted non-consecutive failures")
+	}
+	record(key, true)
+	if !isOpen(key) || allow(key) {
+		t.Fatalf("breaker did not open after consecutive failures")
+	}
+
+	time.Sleep(config.BreakerOpenDuration)
+	if !allow(key) {
+		t.Fatalf("breaker did not let a trial request through")
+	}
+	if getBreakers()[key].State != breakerHalfOpen {
+		t.Errorf("breaker state = %v, want half-open", getBreakers()[key].State)
+	}
+	if allow(key) {
+		t.Errorf("half-open breaker let a second request through")
+	}
+	record(key, true) // failed trial
+	if !isOpen(key) {
+		t.Fatalf("breaker did not open again after a failed trial")
+	}
+
+	time.Sleep(config.BreakerOpenDuration)
+	if !allow(key) {
+		t.Fatalf("breaker did not let a trial request through")
+	}
+	record(key, false) // successful trial
+	if !allow(key) || len(getBreakers()) != 0 {
+		t.Errorf("breaker did not close after a successful trial")
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	defer func(slots chan struct{}) { inFlight = slots }(inFlight)
+	inFlight = newSlots(1)
+	method := actorRuntimeRoutePrefix + "Foo/a/session/method"
+	activation := actorRuntimeRoutePrefix + "Foo/a"
+
+	if !acquireSlot(context.Background(), method, false) {
+		t.Fatal("failed to acquire a free slot")
+	}
+	if b := getBulkhead(); b.InFlight != 1 || b.Limit != 1 {
+		t.Errorf("bulkhead = %+v", b)
+	}
+	if !acquireSlot(context.Background(), activation, false) || !acquireSlot(context.Background(), actorRuntimeRoutePrefix+"Foo", false) {
+		t.Error("activation or type check waited for a slot")
+	}
+	if !acquireSlot(context.Background(), method, true) {
+		t.Error("nested call waited for a slot")
+	}
+	if b := getBulkhead(); b.InFlight != 2 {
+		t.Errorf("bulkhead = %+v with a nested call", b)
+	}
+	releaseSlot(method, true)
+	releaseSlot(activation, false)
+	releaseSlot(actorRuntimeRoutePrefix+"Foo", false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if acquireSlot(ctx, "/service", false) {
+		t.Fatal("acquired a slot beyond the limit")
+	}
+
+	acquired := make(chan bool)
+	go func() { acquired <- acquireSlot(context.Background(), method, false) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot beyond the limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+	releaseSlot(method, false)
+	if !<-acquired {
+		t.Fatal("waiting request did not get the released slot")
+	}
+	releaseSlot(method, false)
+	if b := getBulkhead(); b.InFlight != 0 {
+		t.Errorf("bulkhead = %+v after releasing all slots", b)
+	}
+}
+
+func TestBulkheadUnlimited(t *testing.T) {
+	defer func(slots chan struct{}) { inFlight = slots }(inFlight)
+	inFlight = newSlots(0)
+	method := actorRuntimeRoutePrefix + "Foo/a/session/method"
+
+	acquireSlot(context.Background(), method, false)
+	if b := getBulkhead(); b.InFlight != 1 || b.Limit != 0 {
+		t.Errorf("bulkhead = %+v", b)
+	}
+	releaseSlot(method, false)
+	if b := getBulkhead(); b.InFlight != 0 {
+		t.Errorf("bulkhead = %+v after releasing all slots", b)
+	}
+}
+
+func TestNested(t *testing.T) {
+	if nested(map[string]string{"chain": `[{"type":"A","id":"a","session":"s"}]`}) {
+		t.Error("top-level actor call is nested")
+	}
+	if !nested(map[string]string{"chain": `[{"type":"A","id":"a","session":"s"},{"type":"B","id":"b","session":"t"}]`}) {
+		t.Error("actor call from an actor method is not nested")
+	}
+}
@@ -0,0 +1,137 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+/*
+ * This file contains the support for deadlock detection in actor call chains.
+ *
+ * The actor invocations of a call chain are recorded in the "chain" field of
+ * actor messages as a JSON array of links. The sidecar passes the chain
+ * including the invoked actor to the application in the Kar-Call-Chain header
+ * and the application returns it with its nested actor calls. The KAR SDKs
+ * retain the header for the duration of the invocation and add it to the
+ * actor calls it makes. A call to an actor still held by the chain in another
+ * session cannot complete. Calls made without the header start a new chain.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/IBM/kar.git/core/pkg/logger"
+)
+
+// callChainHeader is the header carrying the call chain to and from the application
+const callChainHeader = "Kar-Call-Chain"
+
+var errActorDeadlock = errors.New("deadlock detected")
+
+// link is an actor invocation in a call chain
+type link struct {
+	Type    string `json:"type"`
+	ID      string `json:"id"`
+	Session string `json:"session"`
+}
+
+// context key for call chains
+type callChainType struct{}
+
+// withCallChain returns a context carrying a call chain if not empty
+func withCallChain(ctx context.Context, chain string) context.Context {
+	if chain == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, callChainType{}, chain)
+}
+
+// setCallChain records the call chain of the context if any in an actor message
+func setCallChain(ctx context.Context, msg map[string]string) {
+	if chain, ok := ctx.Value(callChainType{}).(string); ok {
+		msg["chain"] = chain
+	}
+}
+
+// decodeChain returns the links of a call chain, ignoring malformed chains
+func decodeChain(chain string) []link {
+	if chain == "" {
+		return nil
+	}
+	var links []link
+	if err := json.Unmarshal([]byte(chain), &links); err != nil {
+		logger.Debug("ignoring malformed call chain %s: %v", chain, err)
+		return nil
+	}
+	return links
+}
+
+// pushChain returns the call chain extended with an actor invocation
+func pushChain(chain string, actor Actor, session string) string {
+	b, err := json.Marshal(append(decodeChain(chain), link{Type: actor.Type, ID: actor.ID, Session: session}))
+	if err != nil {
+		return chain
+	}
+	return string(b)
+}
+
+// popChain returns the call chain without its last link if the link is an invocation of the actor
+func popChain(chain string, actor Actor) string {
+	links := decodeChain(chain)
+	if len(links) == 0 {
+		return chain
+	}
+	if l := links[len(links)-1]; l.Type != actor.Type || l.ID != actor.ID {
+		return chain
+	}
+	if len(links) == 1 {
+		return ""
+	}
+	b, err := json.Marshal(links[:len(links)-1])
+	if err != nil {
+		return chain
+	}
+	return string(b)
+}
+
+// holds returns true if the call chain holds the actor in one of the given sessions
+func holds(chain []link, actor Actor, sessions map[string]int) bool {
+	for _, l := range chain {
+		if l.Type == actor.Type && l.ID == actor.ID && sessions[l.Session] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// formatCycle describes the cycle closed by a call to the actor
+func formatCycle(chain []link, actor Actor) string {
+	var str strings.Builder
+	start := 0
+	for i, l := range chain {
+		if l.Type == actor.Type && l.ID == actor.ID {
+			start = i
+			break
+		}
+	}
+	for _, l := range chain[start:] {
+		fmt.Fprintf(&str, "%v[%v] -> ", l.Type, l.ID)
+	}
+	fmt.Fprintf(&str, "%v[%v]", actor.Type, actor.ID)
+	return str.String()
+}
@@ -20,6 +20,7 @@ package runtime
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -31,39 +32,56 @@ import (
 	"github.com/IBM/kar.git/core/internal/config"
 	"github.com/IBM/kar.git/core/internal/pubsub"
 	"github.com/IBM/kar.git/core/internal/store"
+	"github.com/IBM/kar.git/core/internal/tracing"
 	"github.com/IBM/kar.git/core/pkg/logger"
 )
 
 const (
 	actorRuntimeRoutePrefix = "/kar/impl/v1/actor/"
+
+	// status code of cancelled requests
+	statusCancelled = 499
 )
 
 var (
 	// pending requests: map request uuid (string) to channel (chan Reply)
 	requests = sync.Map{}
+
+	// pending promises of this sidecar: map request uuid (string) to creation time (time.Time)
+	promises = sync.Map{}
+
+	// in-flight requests: map request uuid (string) to cancel function (context.CancelFunc)
+	inflight = sync.Map{}
+
+	errUnknownRequest = errors.New("unknown request")
 )
 
 // TellService sends a message to a service and does not wait for a reply
 func TellService(ctx context.Context, service, path, payload, header, method string, direct bool) error {
-	return pubsub.Send(ctx, direct, map[string]string{
+	msg := map[string]string{
 		"protocol": "service",
 		"service":  service,
 		"command":  "tell", // post with no callback expected
 		"path":     path,
 		"header":   header,
 		"method":   method,
-		"payload":  payload})
+		"payload":  payload}
+	setIdempotencyKey(ctx, msg)
+	return pubsub.Send(ctx, direct, msg)
 }
 
 // TellActor sends a message to an actor and does not wait for a reply
 func TellActor(ctx context.Context, actor Actor, path, payload string, direct bool) error {
-	return pubsub.Send(ctx, direct, map[string]string{
+	msg := map[string]string{
 		"protocol": "actor",
 		"type":     actor.Type,
 		"id":       actor.ID,
 		"command":  "tell", // post with no callback expected
 		"path":     path,
-		"payload":  payload})
+		"payload":  payload}
+	setIdempotencyKey(ctx, msg)
+	setPriority(ctx, msg)
+	return pubsub.Send(ctx, direct, msg)
 }
 
 // DeleteActor sends a delete message to an actor and does not wait for a reply
@@ -91,16 +109,19 @@ type Reply struct {
 	StatusCode  int
 	ContentType string
 	Payload     string
+	rejected    bool // the sidecar rejected the request without invoking the application
 }
 
 // callHelper makes a call via pubsub to a sidecar and waits for a reply
 func callHelper(ctx context.Context, msg map[string]string, direct bool) (*Reply, error) {
 	request := uuid.New().String()
-	ch := make(chan *Reply)
+	ch := make(chan *Reply, 1) // buffered so that answers to abandoned or cancelled requests never block
 	requests.Store(request, ch)
 	defer requests.Delete(request)
 	msg["from"] = config.ID // this sidecar
 	msg["request"] = request
+	setDeadline(ctx, msg)
+	setIdempotencyKey(ctx, msg)
 	err := pubsub.Send(ctx, direct, msg)
 	if err != nil {
 		return nil, err
@@ -113,32 +134,54 @@ func callHelper(ctx context.Context, msg map[string]string, direct bool) (*Reply
 	}
 }
 
+// setDeadline records the deadline of the context if any in a call message
+func setDeadline(ctx context.Context, msg map[string]string) {
+	if deadline, ok := ctx.Deadline(); ok {
+		msg["deadline"] = strconv.FormatInt(deadline.UnixNano()/int64(time.Millisecond), 10)
+	}
+}
+
+// context key for actor message priorities
+type priorityType struct{}
+
+// withPriority returns a context carrying a message priority if not empty
+func withPriority(ctx context.Context, priority string) context.Context {
+	if priority == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, priorityType{}, priority)
+}
+
+// setPriority records the priority of the context if any in an actor message
+func setPriority(ctx context.Context, msg map[string]string) {
+	if priority, ok := ctx.Value(priorityType{}).(string); ok {
+		msg["priority"] = priority
+	}
+}
+
 func callPromiseHelper(ctx context.Context, msg map[string]string, direct bool) (string, error) {
 	request := uuid.New().String()
-	ch := make(chan *Reply)
+	ch := make(chan *Reply, 1) // buffered so that answers to abandoned or cancelled requests never block
+	version, err := createPromise(request, msg)
+	if err != nil {
+		return "", err
+	}
 	requests.Store(request, ch)
-	// defer requests.Delete(request)
+	promises.Store(request, time.Now())
 	msg["from"] = config.ID // this sidecar
 	msg["request"] = request
-	err := pubsub.Send(ctx, direct, msg)
+	msg["promise"] = "true"
+	setDeadline(ctx, msg)
+	setIdempotencyKey(ctx, msg)
+	err = pubsub.Send(ctx, direct, msg)
 	if err != nil {
+		consumePromise(request)
 		return "", err
 	}
-	return request, nil
-}
-
-// AwaitPromise awaits the response to an actor or service call
-func AwaitPromise(ctx context.Context, request string) (*Reply, error) {
-	if ch, ok := requests.Load(request); ok {
-		defer requests.Delete(request)
-		select {
-		case r := <-ch.(chan *Reply):
-			return r, nil
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		}
+	if msg["sidecar"] != "" {
+		routePromise(request, version, msg["sidecar"])
 	}
-	return nil, fmt.Errorf("unexpected request %s", request)
+	return request, nil
 }
 
 // CallService calls a service and waits for a reply
@@ -177,6 +220,8 @@ func CallActor(ctx context.Context, actor Actor, path, payload, session string,
 		"path":     path,
 		"session":  session,
 		"payload":  payload}
+	setPriority(ctx, msg)
+	setCallChain(ctx, msg)
 	return callHelper(ctx, msg, direct)
 }
 
@@ -189,6 +234,8 @@ func CallPromiseActor(ctx context.Context, actor Actor, path, payload string, di
 		"command":  "call",
 		"path":     path,
 		"payload":  payload}
+	setPriority(ctx, msg)
+	setCallChain(ctx, msg)
 	return callPromiseHelper(ctx, msg, direct)
 }
 
@@ -211,12 +258,30 @@ func Bindings(ctx context.Context, kind string, actor Actor, bindingID, nilOnAbs
 // helper methods to handle incoming messages
 // log ignored errors to logger.Error
 
+// msgLogger returns a logger attaching the target and request id of a message to log messages
+func msgLogger(msg map[string]string) *logger.Entry {
+	return logger.WithFunc(func() logger.Fields {
+		return logger.Fields{
+			"targetService": msg["service"],
+			"actorType":     msg["type"],
+			"actorId":       msg["id"],
+			"requestId":     msg["request"],
+		}
+	})
+}
+
 func respond(ctx context.Context, msg map[string]string, reply *Reply) error {
+	if msg["promise"] == "true" { // persist result so that any sidecar can answer the promise
+		if _, err := completePromise(msg["request"], reply); err != nil && err != errUnknownRequest {
+			msgLogger(msg).Error("failed to persist result of request %s: %v", msg["request"], err)
+		}
+	}
 	err := pubsub.Send(ctx, msg["direct"] == "true", map[string]string{
 		"protocol":     "sidecar",
 		"sidecar":      msg["from"],
 		"command":      "callback",
 		"request":      msg["request"],
+		"promise":      msg["promise"],
 		"statusCode":   strconv.Itoa(reply.StatusCode),
 		"content-type": reply.ContentType,
 		"payload":      reply.Payload})
@@ -227,14 +292,66 @@ func respond(ctx context.Context, msg map[string]string, reply *Reply) error {
 	return err
 }
 
+// deadline returns the deadline of a call message if any
+func deadline(msg map[string]string) (time.Time, bool) {
+	if msg["deadline"] == "" {
+		return time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(msg["deadline"], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// expired returns true if the deadline of a call message has passed
+func expired(msg map[string]string) bool {
+	d, ok := deadline(msg)
+	return ok && !time.Now().Before(d)
+}
+
+// deadlineExceeded reports to the caller that the deadline of a call has passed
+func deadlineExceeded(ctx context.Context, msg map[string]string) error {
+	msgLogger(msg).Debug("deadline of call %s has passed", msg["path"])
+	return respond(ctx, msg, &Reply{StatusCode: http.StatusGatewayTimeout, Payload: "Gateway Timeout", ContentType: "text/plain"})
+}
+
 func call(ctx context.Context, msg map[string]string) error {
-	reply, err := invoke(ctx, msg["method"], msg)
+	if expired(msg) {
+		return deadlineExceeded(ctx, msg)
+	}
+	reply, version, err := awaitClaim(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if reply != nil {
+		return respond(ctx, msg, reply)
+	}
+	var final *Reply // reply to record for the idempotency key, the claim is released otherwise
+	defer func() { recordReply(msg, version, final) }()
+	invokeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if d, ok := deadline(msg); ok {
+		invokeCtx, cancel = context.WithDeadline(invokeCtx, d)
+		defer cancel()
+	}
+	inflight.Store(msg["request"], cancel)
+	defer inflight.Delete(msg["request"])
+	reply, err = invoke(invokeCtx, msg["method"], msg)
+	if invokeCtx.Err() == context.Canceled && ctx.Err() == nil { // caller has cancelled the request
+		msgLogger(msg).Debug("call %s cancelled", msg["path"])
+		return nil
+	}
+	if invokeCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil { // deadline has passed
+		return deadlineExceeded(ctx, msg)
+	}
 	if err != nil {
 		if err != ctx.Err() {
-			logger.Debug("call failed to invoke %s: %v", msg["path"], err)
+			msgLogger(msg).Debug("call failed to invoke %s: %v", msg["path"], err)
 		}
 		return err
 	}
+	final = reply
 	return respond(ctx, msg, reply)
 }
 
@@ -242,12 +359,16 @@ func callback(ctx context.Context, msg map[string]string) error {
 	if ch, ok := requests.Load(msg["request"]); ok {
 		statusCode, _ := strconv.Atoi(msg["statusCode"])
 		select {
-		case <-ctx.Done():
-			return ctx.Err()
 		case ch.(chan *Reply) <- &Reply{StatusCode: statusCode, ContentType: msg["content-type"], Payload: msg["payload"]}:
+		default: // request already completed, e.g., cancelled
+			msgLogger(msg).Debug("dropping answer to completed request %s", msg["request"])
 		}
+	} else if msg["statusCode"] == strconv.Itoa(http.StatusGatewayTimeout) {
+		msgLogger(msg).Debug("dropping answer to expired request %s", msg["request"]) // caller stopped waiting
+	} else if msg["promise"] == "true" {
+		msgLogger(msg).Debug("dropping answer to promise %s", msg["request"]) // result is in the store
 	} else {
-		logger.Error("unexpected request in callback %s", msg["request"])
+		msgLogger(msg).Error("unexpected request in callback %s", msg["request"])
 	}
 	return nil
 }
@@ -306,52 +427,76 @@ func bindingTell(ctx context.Context, msg map[string]string) error {
 	err := loadBinding(ctx, msg["kind"], actor, msg["partition"], msg["bindingId"])
 	if err != nil {
 		if err != ctx.Err() {
-			logger.Error("load binding failed: %v", err)
+			msgLogger(msg).Error("load binding failed: %v", err)
 		}
 	}
 	return nil
 }
 
 func tell(ctx context.Context, msg map[string]string) error {
-	reply, err := invoke(ctx, msg["method"], msg)
+	reply, version, err := claimReply(msg)
+	if err == errReplyPending {
+		msgLogger(msg).Debug("dropping duplicate tell %s in progress", msg["path"])
+		return nil
+	}
+	if reply != nil {
+		return nil
+	}
+	reply, err = invoke(ctx, msg["method"], msg)
 	if err != nil {
+		recordReply(msg, version, nil) // release the claim before redelivery
 		if err != ctx.Err() {
-			logger.Debug("tell failed to invoke %s: %v", msg["path"], err)
+			msgLogger(msg).Debug("tell failed to invoke %s: %v", msg["path"], err)
 		}
 		return err
 	}
+	if reply.rejected {
+		recordReply(msg, version, nil) // release the claim before retrying
+		return rejectedTell(ctx, msg, reply.Payload)
+	}
 
 	// Examine the reply and log any that represent appliction-level errors.
 	// We do this because a tell does not have a caller to which such reporting can be delegated.
 	if reply.StatusCode == http.StatusNoContent {
-		logger.Debug("Asynchronous invoke of %s returned void", msg["path"])
+		msgLogger(msg).Debug("Asynchronous invoke of %s returned void", msg["path"])
 	} else if reply.StatusCode == http.StatusOK {
 		if strings.HasPrefix(reply.ContentType, "application/kar+json") {
 			var result actorCallResult
 			if err := json.Unmarshal([]byte(reply.Payload), &result); err != nil {
-				logger.Error("Asynchronous invoke of %s had malformed result. %v", msg["path"], err)
+				msgLogger(msg).Error("Asynchronous invoke of %s had malformed result. %v", msg["path"], err)
 			} else {
 				if result.Error {
-					logger.Error("Asynchronous invoke of %s raised error %s", msg["path"], result.Message)
-					logger.Error("Stacktrace: %v", result.Stack)
+					msgLogger(msg).Error("Asynchronous invoke of %s raised error %s", msg["path"], result.Message)
+					msgLogger(msg).Error("Stacktrace: %v", result.Stack)
+					recordReply(msg, version, nil) // release the claim before retrying
+					return failedTell(ctx, msg, reply.StatusCode, result.Message)
 				} else {
-					logger.Debug("Asynchronous invoke of %s returned %v", msg["path"], result.Value)
+					msgLogger(msg).Debug("Asynchronous invoke of %s returned %v", msg["path"], result.Value)
 				}
 			}
 		} else {
-			logger.Error("Asynchronous invoke of %s returned unexpected Content-Type %v", msg["path"], reply.ContentType)
+			msgLogger(msg).Error("Asynchronous invoke of %s returned unexpected Content-Type %v", msg["path"], reply.ContentType)
 		}
 	} else {
-		logger.Error("Asynchronous invoke of %s returned status %v with body %s", msg["path"], reply.StatusCode, reply.Payload)
+		msgLogger(msg).Error("Asynchronous invoke of %s returned status %v with body %s", msg["path"], reply.StatusCode, reply.Payload)
+		recordReply(msg, version, nil) // release the claim before retrying
+		return failedTell(ctx, msg, reply.StatusCode, reply.Payload)
 	}
 
+	recordReply(msg, version, reply)
 	return nil
 }
 
 // Returns information about this sidecar's actors
+// or about their mailboxes if requested
 func getActorInformation(ctx context.Context, msg map[string]string) error {
-	actorInfo := getMyActiveActors(msg["actorType"])
-	m, err := json.Marshal(actorInfo)
+	var m []byte
+	var err error
+	if msg["mailbox"] == "true" {
+		m, err = json.Marshal(getMyMailboxes(msg["actorType"]))
+	} else {
+		m, err = json.Marshal(getMyActiveActors(msg["actorType"]))
+	}
 	var reply *Reply
 	if err != nil {
 		logger.Debug("Error marshaling actor information data: %v", err)
@@ -362,14 +507,42 @@ func getActorInformation(ctx context.Context, msg map[string]string) error {
 	return respond(ctx, msg, reply)
 }
 
+// traced starts a span for an invocation of the application and propagates its trace context
+func traced(ctx context.Context, msg map[string]string, f func(context.Context, map[string]string) error) error {
+	path := msg["path"]
+	if msg["protocol"] == "actor" {
+		path = actorPath(msg)
+	}
+	ctx, span := tracing.Start(ctx, msg["command"]+" "+path, tracing.KindServer)
+	defer span.End()
+	if msg["protocol"] == "actor" {
+		span.SetAttribute("kar.actor.type", msg["type"])
+		span.SetAttribute("kar.actor.id", msg["id"])
+	} else if msg["service"] != "" {
+		span.SetAttribute("kar.service", msg["service"])
+	}
+	span.SetAttribute("kar.path", path)
+	span.SetAttribute("kar.sidecar", config.ID)
+	delete(msg, "traceparent")
+	delete(msg, "tracestate")
+	tracing.Inject(ctx, msg)
+	err := f(ctx, msg)
+	span.SetError(err)
+	return err
+}
+
 func dispatch(ctx context.Context, cancel context.CancelFunc, msg map[string]string) error {
 	switch msg["command"] {
 	case "call":
-		return call(ctx, msg)
+		return traced(ctx, msg, call)
 	case "callback":
 		return callback(ctx, msg)
 	case "cancel":
-		cancel() // never fails
+		if msg["request"] != "" { // cancel one request
+			cancelRequest(msg["request"])
+		} else {
+			cancel() // never fails
+		}
 	case "binding:del":
 		return bindingDel(ctx, msg)
 	case "binding:get":
@@ -379,7 +552,7 @@ func dispatch(ctx context.Context, cancel context.CancelFunc, msg map[string]str
 	case "binding:tell":
 		return bindingTell(ctx, msg)
 	case "tell":
-		return tell(ctx, msg)
+		return traced(ctx, msg, tell)
 	case "getActiveActors":
 		return getActorInformation(ctx, msg)
 	default:
@@ -406,6 +579,12 @@ func Process(ctx context.Context, cancel context.CancelFunc, message pubsub.Mess
 		message.Mark()
 		return
 	}
+	ctx = tracing.Extract(ctx, msg["traceparent"], msg["tracestate"])
+	if isCancelled(msg) { // skip cancelled call awaiting admission
+		logger.Debug("skipping cancelled request %s", msg["request"])
+		message.Mark()
+		return
+	}
 	switch msg["protocol"] {
 	case "service":
 		if msg["service"] == config.ServiceName {
@@ -427,7 +606,7 @@ func Process(ctx context.Context, cancel context.CancelFunc, message pubsub.Mess
 		if session == "" {
 			if strings.HasPrefix(msg["command"], "binding:") {
 				session = "reminder"
-			} else if msg["command"] == "delete" {
+			} else if msg["command"] == "delete" || msg["command"] == "migrate" {
 				session = "exclusive"
 			} else {
 				session = uuid.New().String() // start new session
@@ -435,9 +614,19 @@ func Process(ctx context.Context, cancel context.CancelFunc, message pubsub.Mess
 		}
 		var e *actorEntry
 		var fresh bool
-		e, fresh, err = actor.acquire(ctx, session)
+		priority, _ := strconv.Atoi(msg["priority"]) // default to 0
+		chain := decodeChain(msg["chain"])
+		e, fresh, err = actor.acquire(ctx, session, priority, chain)
 		if err == errActorHasMoved {
 			err = pubsub.Send(ctx, false, msg) // forward
+		} else if err == errActorDeadlock {
+			payload := fmt.Sprintf("deadlock detected, aborting command %s with path %s in session %s: %s", msg["command"], msg["path"], session, formatCycle(chain, actor))
+			logger.Warning("%s", payload)
+			if msg["command"] == "call" {
+				err = respond(ctx, msg, &Reply{StatusCode: http.StatusConflict, Payload: payload, ContentType: "text/plain"})
+			} else {
+				err = nil
+			}
 		} else if err == errActorAcquireTimeout {
 			payload := fmt.Sprintf("acquiring actor %v timed out, aborting command %s with path %s in session %s", actor, msg["command"], msg["path"], session)
 			logger.Error("%s", payload)
@@ -454,7 +643,16 @@ func Process(ctx context.Context, cancel context.CancelFunc, message pubsub.Mess
 				break
 			}
 
+			if msg["command"] == "migrate" {
+				err = migrate(ctx, e, fresh, msg)
+				break
+			}
+
 			if msg["command"] == "delete" {
+				if msg["idleBefore"] != "" && !idle(actor, fresh, msg["idleBefore"]) {
+					e.release(session, false)
+					break
+				}
 				// delete SDK-level in-memory state
 				if !fresh {
 					deactivate(ctx, actor)
@@ -463,6 +661,10 @@ func Process(ctx context.Context, cancel context.CancelFunc, message pubsub.Mess
 				if _, err := store.Del(stateKey(actor.Type, actor.ID)); err != nil && err != store.ErrNil {
 					logger.Error("deleting persistent state of %v failed with %v", actor, err)
 				}
+				if msg["idleBefore"] != "" {
+					deleteActorBindings(actor)
+				}
+				store.HDel(idleKey(actor.Type), actor.ID)
 				// clear placement data and sidecar's in-memory state
 				err = e.migrate("")
 				if err != nil {
@@ -471,22 +673,40 @@ func Process(ctx context.Context, cancel context.CancelFunc, message pubsub.Mess
 				break
 			}
 
+			if isCancelled(msg) { // cancelled while awaiting the actor
+				logger.Debug("skipping cancelled request %s", msg["request"])
+				e.release(session, false)
+				break
+			}
+
+			if msg["command"] == "call" && expired(msg) { // skip activation
+				err = deadlineExceeded(ctx, msg)
+				e.release(session, false)
+				break
+			}
+
 			var reply *Reply
 			if fresh {
 				reply, err = activate(ctx, actor)
+				if reply == nil && err == nil {
+					touchActor(actor, time.Now())
+				}
 			}
 			if reply != nil { // activate returned an error, report or log error, do not retry
 				if msg["command"] == "call" {
-					logger.Debug("activate %v returned status %v with body %s, aborting call %s", actor, reply.StatusCode, reply.Payload, msg["path"])
+					msgLogger(msg).Debug("activate %v returned status %v with body %s, aborting call %s", actor, reply.StatusCode, reply.Payload, msg["path"])
 					err = respond(ctx, msg, reply) // return activation error to caller
+				} else if reply.rejected {
+					err = rejectedTell(ctx, msg, reply.Payload)
 				} else {
-					logger.Error("activate %v returned status %v with body %s, aborting tell %s", actor, reply.StatusCode, reply.Payload, msg["path"])
-					err = nil // not to be retried
+					msgLogger(msg).Error("activate %v returned status %v with body %s, aborting tell %s", actor, reply.StatusCode, reply.Payload, msg["path"])
+					err = failedTell(ctx, msg, reply.StatusCode, reply.Payload) // not to be retried unless dead-lettering is enabled
 				}
 				e.release(session, false)
 			} else if err != nil { // failed to invoke activate
 				e.release(session, false)
 			} else { // invoke actor method
+				msg["chain"] = pushChain(msg["chain"], actor, session)
 				msg["path"] = actorRuntimeRoutePrefix + actor.Type + "/" + actor.ID + "/" + session + msg["path"]
 				msg["content-type"] = "application/kar+json"
 				msg["method"] = "POST"
@@ -504,7 +724,14 @@ func Process(ctx context.Context, cancel context.CancelFunc, message pubsub.Mess
 
 // activate an actor
 func activate(ctx context.Context, actor Actor) (*Reply, error) {
-	reply, err := invoke(ctx, "GET", map[string]string{"path": actorRuntimeRoutePrefix + actor.Type + "/" + actor.ID})
+	ctx, span := tracing.Start(ctx, "activate", tracing.KindInternal)
+	defer span.End()
+	span.SetAttribute("kar.actor.type", actor.Type)
+	span.SetAttribute("kar.actor.id", actor.ID)
+	msg := map[string]string{"path": actorRuntimeRoutePrefix + actor.Type + "/" + actor.ID}
+	tracing.Inject(ctx, msg)
+	reply, err := invoke(ctx, "GET", msg)
+	span.SetError(err)
 	if err != nil {
 		if err != ctx.Err() {
 			logger.Debug("activate failed to invoke %s: %v", actorRuntimeRoutePrefix+actor.Type+"/"+actor.ID, err)
@@ -536,6 +763,7 @@ func deactivate(ctx context.Context, actor Actor) error {
 }
 
 // Collect periodically collect actors with no recent usage (but retains placement)
+// and publishes the resulting number of resident actors of each type
 func Collect(ctx context.Context) {
 	lock := make(chan struct{}, 1) // trylock
 	ticker := time.NewTicker(config.ActorCollectorInterval)
@@ -545,6 +773,14 @@ func Collect(ctx context.Context) {
 			select {
 			case lock <- struct{}{}:
 				collect(ctx, now.Add(-config.ActorCollectorInterval))
+				collectPromises(now.Add(-config.PromiseTTL))
+				counts := map[string]int{}
+				for t, ids := range getMyActiveActors("") {
+					counts[t] = len(ids)
+				}
+				if err := pubsub.PublishLoad(counts); err != nil {
+					logger.Error("failed to publish actor load: %v", err)
+				}
 				<-lock
 			default: // skip this collection if collection is already in progress
 			}
@@ -556,12 +792,14 @@ func Collect(ctx context.Context) {
 }
 
 // ProcessReminders runs periodically and schedules delivery of all reminders whose targetTime has passed
+// and of all the failed asynchronous invocations due for a retry
 func ProcessReminders(ctx context.Context) {
 	ticker := time.NewTicker(config.ActorReminderInterval)
 	for {
 		select {
 		case now := <-ticker.C:
 			processReminders(ctx, now)
+			processRetries(ctx, now)
 		case <-ctx.Done():
 			ticker.Stop()
 			return
@@ -600,23 +838,55 @@ func ValidateActorConfig(ctx context.Context) {
 	}
 }
 
-// Migrate migrates an actor and associated reminders to a new sidecar
-// NOTE: This method is currently unused and not exposed via the KAR REST API.
-func Migrate(ctx context.Context, actor Actor, sidecar string) error {
-	e, fresh, err := actor.acquire(ctx, "exclusive")
-	if err != nil {
-		return err
+// Migrate sends a migrate message to an actor and waits for a reply
+func Migrate(ctx context.Context, actor Actor, sidecar string) (*Reply, error) {
+	msg := map[string]string{
+		"protocol": "actor",
+		"type":     actor.Type,
+		"id":       actor.ID,
+		"command":  "migrate",
+		"target":   sidecar}
+	return callHelper(ctx, msg, false)
+}
+
+// migrate deactivates an actor, updates its placement, and moves its bindings to the target sidecar
+// the actor must be held in an exclusive session
+func migrate(ctx context.Context, e *actorEntry, fresh bool, msg map[string]string) error {
+	actor := e.actor
+	target := msg["target"]
+	if config.StatelessWorkers[actor.Type] > 0 { // not placed
+		e.release("exclusive", !fresh)
+		return respond(ctx, msg, &Reply{StatusCode: http.StatusBadRequest, Payload: fmt.Sprintf("actor type %s is a stateless worker", actor.Type), ContentType: "text/plain"})
+	}
+	if target == config.ID { // nothing to do
+		e.release("exclusive", !fresh)
+		return respond(ctx, msg, &Reply{StatusCode: http.StatusOK, Payload: "OK", ContentType: "text/plain"})
+	}
+	if _, err := pubsub.RouteToSidecar(target); err != nil {
+		e.release("exclusive", !fresh)
+		return respond(ctx, msg, &Reply{StatusCode: http.StatusNotFound, Payload: fmt.Sprintf("unknown sidecar %s", target), ContentType: "text/plain"})
 	}
 	if !fresh {
-		err = deactivate(ctx, actor)
-		if err != nil {
-			logger.Error("failed to deactivate actor %v before migration: %v", actor, err)
+		if err := deactivate(ctx, actor); err != nil {
+			e.release("exclusive", true)
+			if err == ctx.Err() {
+				return err
+			}
+			logger.Error("failed to deactivate actor %v: %v", actor, err)
+			return respond(ctx, msg, &Reply{StatusCode: http.StatusInternalServerError, Payload: err.Error(), ContentType: "text/plain"})
 		}
 	}
-	err = e.migrate(sidecar)
-	if err != nil {
-		return err
+	if err := e.migrate(target); err != nil {
+		logger.Error("failed to update placement of actor %v: %v", actor, err)
+		return respond(ctx, msg, &Reply{StatusCode: http.StatusInternalServerError, Payload: err.Error(), ContentType: "text/plain"})
 	}
-	// migrateReminders(ctx, actor) TODO
-	return nil
+	if err := migrateBindings(ctx, actor, target); err != nil {
+		if err == ctx.Err() {
+			return err
+		}
+		logger.Error("failed to migrate bindings of actor %v: %v", actor, err)
+		return respond(ctx, msg, &Reply{StatusCode: http.StatusInternalServerError, Payload: err.Error(), ContentType: "text/plain"})
+	}
+	logger.Info("migrated actor %v to sidecar %s", actor, target)
+	return respond(ctx, msg, &Reply{StatusCode: http.StatusOK, Payload: "OK", ContentType: "text/plain"})
 }
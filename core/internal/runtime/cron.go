@@ -0,0 +1,299 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression
+//
+// Each field is a bit set of the values it matches.
+type cronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	domStar, dowStar                      bool // true if the field was unrestricted
+	loc                                   *time.Location
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	cronSeconds = cronField{0, 59, nil}
+	cronMinutes = cronField{0, 59, nil}
+	cronHours   = cronField{0, 23, nil}
+	cronDom     = cronField{1, 31, nil}
+	cronMonths  = cronField{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}}
+	cronDow = cronField{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// parseCron parses a cron expression
+//
+// The expression has 5 fields (minute hour day-of-month month day-of-week)
+// or 6 fields (with a leading second field) or is one of the @yearly, @monthly,
+// @weekly, @daily, @hourly descriptors. It may be prefixed with CRON_TZ=zone
+// or TZ=zone to specify an IANA time zone. The default time zone is UTC.
+func parseCron(spec string) (*cronSchedule, error) {
+	s := &cronSchedule{loc: time.UTC}
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i == -1 {
+			return nil, fmt.Errorf("invalid cron expression %q: missing fields", spec)
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+		}
+		s.loc = loc
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if d, ok := cronDescriptors[spec]; ok {
+		spec = d
+	}
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 or 6 fields, found %d", spec, len(fields))
+	}
+	var err error
+	if s.second, _, err = cronSeconds.parse(fields[0]); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+	}
+	if s.minute, _, err = cronMinutes.parse(fields[1]); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+	}
+	if s.hour, _, err = cronHours.parse(fields[2]); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+	}
+	if s.dom, s.domStar, err = cronDom.parse(fields[3]); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+	}
+	if s.month, _, err = cronMonths.parse(fields[4]); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+	}
+	if s.dow, s.dowStar, err = cronDow.parse(fields[5]); err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %v", spec, err)
+	}
+	if s.dow&(1<<7) != 0 { // 7 is an alias for Sunday
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parse a comma-separated list of values, ranges, and steps
+func (f cronField) parse(field string) (uint64, bool, error) {
+	var bits uint64
+	star := false
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i != -1 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, false, fmt.Errorf("invalid step in %q", part)
+			}
+			step = n
+			part = part[:i]
+		}
+		lo, hi := f.min, f.max
+		switch {
+		case part == "*" || part == "?":
+			star = star || step == 1
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err error
+			if lo, err = f.value(part[:i]); err != nil {
+				return 0, false, err
+			}
+			if hi, err = f.value(part[i+1:]); err != nil {
+				return 0, false, err
+			}
+			if lo > hi {
+				return 0, false, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			v, err := f.value(part)
+			if err != nil {
+				return 0, false, err
+			}
+			lo = v
+			if step == 1 {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, star, nil
+}
+
+// value parses a single number or name and checks bounds
+func (f cronField) value(s string) (int, error) {
+	if v, ok := f.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("value %d out of range [%d-%d]", v, f.min, f.max)
+	}
+	return v, nil
+}
+
+// dayMatches follows the cron convention: if both day fields are restricted,
+// a day matches if either field matches
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first activation time strictly after t
+// or the zero time if the schedule cannot be satisfied within five years
+//
+// Wall-clock fields are evaluated in the schedule time zone, so a job scheduled
+// at 09:00 keeps firing at 09:00 local time across daylight saving changes.
+// Times skipped by a forward transition are skipped, times repeated by
+// a backward transition fire once.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	for {
+		t = s.nextWallClock(t)
+		if t.IsZero() || !s.repeated(t) {
+			return t
+		}
+	}
+}
+
+// repeated returns true if the wall-clock time of t already occurred
+// before a backward daylight saving transition
+func (s *cronSchedule) repeated(t time.Time) bool {
+	t = t.In(s.loc)
+	_, offset := t.Zone()
+	_, before := t.Add(-24 * time.Hour).Zone()
+	if before <= offset {
+		return false
+	}
+	earlier := t.Add(-time.Duration(before-offset) * time.Second)
+	_, o := earlier.Zone()
+	return o == before
+}
+
+// nextWallClock returns the first time strictly after t matching the schedule
+// in the schedule time zone, including repeated wall-clock times
+func (s *cronSchedule) nextWallClock(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(s.loc).Truncate(time.Second).Add(time.Second)
+	yearLimit := t.Year() + 5
+	added := false // true once t has been reset to the start of a unit
+
+wrap:
+	for t.Year() <= yearLimit {
+		for s.month&(1<<uint(t.Month())) == 0 {
+			if !added {
+				added = true
+				t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, s.loc)
+			}
+			t = t.AddDate(0, 1, 0)
+			if t.Month() == time.January {
+				continue wrap
+			}
+		}
+
+		for !s.dayMatches(t) {
+			if !added {
+				added = true
+				t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, s.loc)
+			}
+			t = t.AddDate(0, 0, 1)
+			// midnight may not exist or be ambiguous on a daylight saving change
+			if t.Hour() != 0 {
+				if t.Hour() > 12 {
+					t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+				} else {
+					t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+				}
+			}
+			if t.Day() == 1 {
+				continue wrap
+			}
+		}
+
+		for s.hour&(1<<uint(t.Hour())) == 0 {
+			if !added {
+				added = true
+				t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, s.loc)
+			}
+			t = t.Add(time.Hour)
+			if t.Hour() == 0 {
+				continue wrap
+			}
+		}
+
+		for s.minute&(1<<uint(t.Minute())) == 0 {
+			if !added {
+				added = true
+				t = t.Truncate(time.Minute)
+			}
+			t = t.Add(time.Minute)
+			if t.Minute() == 0 {
+				continue wrap
+			}
+		}
+
+		for s.second&(1<<uint(t.Second())) == 0 {
+			if !added {
+				added = true
+				t = t.Truncate(time.Second)
+			}
+			t = t.Add(time.Second)
+			if t.Second() == 0 {
+				continue wrap
+			}
+		}
+
+		return t.In(origLoc)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,118 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"x * * * *",
+		"CRON_TZ=Nowhere/Special * * * * *",
+		"CRON_TZ=UTC",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("parseCron(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tests := []struct {
+		name, spec string
+		from       time.Time
+		want       []time.Time // successive activations, zero time if none
+	}{
+		{"every minute", "* * * * *", utc("2021-01-01T00:00:30Z"),
+			[]time.Time{utc("2021-01-01T00:01:00Z"), utc("2021-01-01T00:02:00Z")}},
+		{"seconds field", "*/20 * * * * *", utc("2021-01-01T00:00:00Z"),
+			[]time.Time{utc("2021-01-01T00:00:20Z"), utc("2021-01-01T00:00:40Z"), utc("2021-01-01T00:01:00Z")}},
+		{"minute step", "*/15 * * * *", utc("2021-01-01T00:50:00Z"),
+			[]time.Time{utc("2021-01-01T01:00:00Z"), utc("2021-01-01T01:15:00Z")}},
+		{"range step", "0 1-12/5 * * *", utc("2021-01-01T00:00:00Z"),
+			[]time.Time{utc("2021-01-01T01:00:00Z"), utc("2021-01-01T06:00:00Z"), utc("2021-01-01T11:00:00Z"), utc("2021-01-02T01:00:00Z")}},
+		{"value step", "0 20/2 * * *", utc("2021-01-01T00:00:00Z"),
+			[]time.Time{utc("2021-01-01T20:00:00Z"), utc("2021-01-01T22:00:00Z"), utc("2021-01-02T20:00:00Z")}},
+		{"list and names", "0 0 * jan,mar mon", utc("2021-01-30T00:00:00Z"),
+			[]time.Time{utc("2021-03-01T00:00:00Z"), utc("2021-03-08T00:00:00Z")}},
+		{"sunday as 7", "0 0 * * 7", utc("2021-01-01T00:00:00Z"),
+			[]time.Time{utc("2021-01-03T00:00:00Z"), utc("2021-01-10T00:00:00Z")}},
+		{"day of month or week", "0 0 13 * fri", utc("2021-08-01T00:00:00Z"),
+			[]time.Time{utc("2021-08-06T00:00:00Z"), utc("2021-08-13T00:00:00Z"), utc("2021-08-20T00:00:00Z")}},
+		{"descriptor", "@monthly", utc("2021-12-15T00:00:00Z"),
+			[]time.Time{utc("2022-01-01T00:00:00Z"), utc("2022-02-01T00:00:00Z")}},
+		{"31st skips short months", "0 0 31 * *", utc("2021-04-01T00:00:00Z"),
+			[]time.Time{utc("2021-05-31T00:00:00Z"), utc("2021-07-31T00:00:00Z")}},
+		{"february 29", "0 0 29 2 *", utc("2021-01-01T00:00:00Z"),
+			[]time.Time{utc("2024-02-29T00:00:00Z"), utc("2028-02-29T00:00:00Z")}},
+		{"february 31", "0 0 31 2 *", utc("2021-01-01T00:00:00Z"),
+			[]time.Time{{}}},
+		{"time zone", "CRON_TZ=America/New_York 0 9 * * *", utc("2021-01-01T00:00:00Z"),
+			[]time.Time{utc("2021-01-01T14:00:00Z"), utc("2021-01-02T14:00:00Z")}},
+		{"wall clock across dst", "TZ=America/New_York 0 9 * * *", time.Date(2021, 3, 13, 10, 0, 0, 0, ny),
+			[]time.Time{time.Date(2021, 3, 14, 9, 0, 0, 0, ny), time.Date(2021, 3, 15, 9, 0, 0, 0, ny)}},
+		{"dst gap is skipped", "CRON_TZ=America/New_York 30 2 * * *", time.Date(2021, 3, 13, 3, 0, 0, 0, ny),
+			[]time.Time{time.Date(2021, 3, 15, 2, 30, 0, 0, ny)}},
+		{"hourly across dst gap", "CRON_TZ=America/New_York 0 * * * *", time.Date(2021, 3, 14, 0, 30, 0, 0, ny),
+			[]time.Time{utc("2021-03-14T06:00:00Z"), utc("2021-03-14T07:00:00Z"), utc("2021-03-14T08:00:00Z")}},
+		{"dst overlap fires once", "CRON_TZ=America/New_York 30 1 * * *", time.Date(2021, 11, 6, 12, 0, 0, 0, ny),
+			[]time.Time{utc("2021-11-07T05:30:00Z"), utc("2021-11-08T06:30:00Z")}},
+		{"dst overlap every 30 minutes", "CRON_TZ=America/New_York */30 * * * *", utc("2021-11-07T04:45:00Z"),
+			[]time.Time{utc("2021-11-07T05:00:00Z"), utc("2021-11-07T05:30:00Z"), utc("2021-11-07T07:00:00Z"), utc("2021-11-07T07:30:00Z")}},
+		{"midnight dst gap", "CRON_TZ=America/Sao_Paulo 0 12 * * *", utc("2018-11-03T16:00:00Z"),
+			[]time.Time{utc("2018-11-04T14:00:00Z")}},
+	}
+	for _, tt := range tests {
+		s, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("%s: parseCron(%q): %v", tt.name, tt.spec, err)
+			continue
+		}
+		from := tt.from
+		for i, want := range tt.want {
+			got := s.next(from)
+			if !got.Equal(want) {
+				t.Errorf("%s: activation %d after %v = %v, want %v", tt.name, i+1, from, got.UTC(), want.UTC())
+				break
+			}
+			from = got
+		}
+	}
+}
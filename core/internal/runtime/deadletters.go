@@ -0,0 +1,346 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/pubsub"
+	"github.com/IBM/kar.git/core/internal/store"
+	"github.com/IBM/kar.git/core/pkg/logger"
+	"github.com/google/uuid"
+)
+
+// deadLetter describes an asynchronous invocation that could not be delivered
+type deadLetter struct {
+	// The dead letter id
+	ID string `json:"id"`
+	// The dead-letter topic the invocation was published to
+	Topic string `json:"topic"`
+	// The time the invocation was declared undeliverable
+	Time time.Time `json:"time"`
+	// The number of delivery attempts
+	Attempts int `json:"attempts"`
+	// The HTTP status of the last attempt
+	Status int `json:"status,omitempty"`
+	// The error returned by the last attempt
+	Error string `json:"error,omitempty"`
+	// The target actor
+	Actor *Actor `json:"actor,omitempty"`
+	// The target service
+	Service string `json:"service,omitempty"`
+	// The target path
+	Path string `json:"path"`
+	// The HTTP method and headers of a service invocation
+	Method string `json:"method,omitempty"`
+	Header string `json:"header,omitempty"`
+	// The topic of the event that triggered the invocation if any
+	Source string `json:"source,omitempty"`
+	// The original payload
+	Payload string `json:"payload"`
+}
+
+// retry describes a failed asynchronous invocation waiting to be retried
+type retry struct {
+	// The retry id, making identical messages distinct in the retry queue
+	ID string `json:"id"`
+	// The tell message to resend
+	Message map[string]string `json:"message"`
+}
+
+// maximum delay between two delivery attempts
+const maxRetryDelay = 5 * time.Minute
+
+// store key for the queue of retries sorted by due time in milliseconds
+const retriesKey = "retries"
+
+// store key for a dead letter
+func deadLetterKey(id string) string {
+	return "deadletter" + config.Separator + id
+}
+
+// retryDelay returns the delay before a delivery attempt, doubling on each attempt
+func retryDelay(attempt int) time.Duration {
+	delay := config.DeadLetterBackoff
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// deadLetterTopic returns the dead-letter topic for a message, if any
+func deadLetterTopic(msg map[string]string) string {
+	if msg["deadLetterTopic"] != "" {
+		return msg["deadLetterTopic"]
+	}
+	return config.DeadLetterTopic
+}
+
+// actorPath returns the actor method path of an actor message
+// even if it has already been expanded by Process
+func actorPath(msg map[string]string) string {
+	prefix := actorRuntimeRoutePrefix + msg["type"] + "/" + msg["id"] + "/"
+	if strings.HasPrefix(msg["path"], prefix) { // strip prefix and session
+		p := strings.TrimPrefix(msg["path"], prefix)
+		if i := strings.Index(p, "/"); i != -1 {
+			return p[i:]
+		}
+	}
+	return msg["path"]
+}
+
+// tellMessage reconstructs the tell message for a failed invocation
+func tellMessage(msg map[string]string) map[string]string {
+	m := map[string]string{
+		"protocol": msg["protocol"],
+		"command":  "tell",
+		"path":     msg["path"],
+		"payload":  msg["payload"],
+	}
+	if msg["protocol"] == "actor" {
+		m["type"] = msg["type"]
+		m["id"] = msg["id"]
+		m["path"] = actorPath(msg)
+		if chain := popChain(msg["chain"], Actor{Type: msg["type"], ID: msg["id"]}); chain != "" {
+			m["chain"] = chain
+		}
+	} else {
+		m["service"] = msg["service"]
+		m["method"] = msg["method"]
+		m["header"] = msg["header"]
+	}
+	for _, k := range []string{"deadLetterTopic", "source", "traceparent", "tracestate", "idempotencyKey", "priority"} {
+		if msg[k] != "" {
+			m[k] = msg[k]
+		}
+	}
+	return m
+}
+
+// failedTell handles a failed asynchronous invocation
+// the invocation is retried with exponential backoff until the attempt limit is reached,
+// then published to the dead-letter topic
+// failures are only logged if there is no dead-letter topic
+func failedTell(ctx context.Context, msg map[string]string, status int, reason string) error {
+	topic := deadLetterTopic(msg)
+	if topic == "" {
+		return nil
+	}
+	attempt, _ := strconv.Atoi(msg["attempt"])
+	attempt++
+	m := tellMessage(msg)
+	if attempt < config.DeadLetterAttempts {
+		m["attempt"] = strconv.Itoa(attempt)
+		delay := retryDelay(attempt)
+		logger.Debug("retrying asynchronous invoke of %s in %v (attempt %v)", m["path"], delay, attempt+1)
+		return delayTell(ctx, m, delay)
+	}
+	d := deadLetter{
+		ID:       uuid.New().String(),
+		Topic:    topic,
+		Time:     time.Now(),
+		Attempts: attempt,
+		Status:   status,
+		Error:    reason,
+		Service:  m["service"],
+		Path:     m["path"],
+		Method:   m["method"],
+		Header:   m["header"],
+		Source:   m["source"],
+		Payload:  m["payload"],
+	}
+	if m["protocol"] == "actor" {
+		d.Actor = &Actor{Type: m["type"], ID: m["id"]}
+	}
+	return publishDeadLetter(d)
+}
+
+// rejectedTell handles an asynchronous invocation rejected by the sidecar without invoking the application
+// the invocation is retried once the circuit breaker may let it through, this is not a failed attempt
+func rejectedTell(ctx context.Context, msg map[string]string, reason string) error {
+	m := tellMessage(msg)
+	if msg["attempt"] != "" {
+		m["attempt"] = msg["attempt"]
+	}
+	logger.Debug("delaying asynchronous invoke of %s by %v: %s", m["path"], config.BreakerOpenDuration, reason)
+	return delayTell(ctx, m, config.BreakerOpenDuration)
+}
+
+// delayTell resends a tell message after a delay
+func delayTell(ctx context.Context, m map[string]string, delay time.Duration) error {
+	if delay == 0 {
+		return pubsub.Send(ctx, false, m)
+	}
+	buf, err := json.Marshal(retry{ID: uuid.New().String(), Message: m})
+	if err != nil {
+		return err
+	}
+	_, err = store.ZAdd(retriesKey, time.Now().Add(delay).UnixNano()/int64(time.Millisecond), string(buf))
+	return err
+}
+
+// publishDeadLetter publishes a dead letter to its topic and records it in the store
+func publishDeadLetter(d deadLetter) error {
+	buf, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	_, err = pubsub.Publish(d.Topic, buf)
+	if err == pubsub.ErrUnknownTopic {
+		if err = pubsub.CreateTopic(d.Topic, ""); err == nil || err == pubsub.ErrTopicAlreadyExists {
+			_, err = pubsub.Publish(d.Topic, buf)
+		}
+	}
+	if err != nil {
+		logger.Error("failed to publish dead letter to topic %s: %v", d.Topic, err)
+		return err
+	}
+	if _, err := store.Set(deadLetterKey(d.ID), string(buf)); err != nil {
+		return err
+	}
+	logger.Warning("asynchronous invoke of %s failed after %v attempt(s), recorded as dead letter %s", d.Path, d.Attempts, d.ID)
+	return nil
+}
+
+// processRetries resends the failed asynchronous invocations due before now
+func processRetries(ctx context.Context, now time.Time) {
+	due := now.UnixNano() / int64(time.Millisecond)
+	for {
+		values, err := store.ZPopByScore(retriesKey, due, 100)
+		if err != nil {
+			logger.Error("failed to fetch retries: %v", err)
+			return
+		}
+		for i, value := range values {
+			var r retry
+			if err := json.Unmarshal([]byte(value), &r); err != nil {
+				logger.Error("dropping malformed retry %s: %v", value, err)
+				continue
+			}
+			attempt, _ := strconv.Atoi(r.Message["attempt"])
+			logger.Debug("retrying asynchronous invoke of %s (attempt %v)", r.Message["path"], attempt+1)
+			if err := pubsub.Send(ctx, false, r.Message); err != nil {
+				if err != ctx.Err() {
+					logger.Error("failed to retry asynchronous invoke of %s: %v", r.Message["path"], err)
+				}
+				for _, value := range values[i:] { // put back for the next round
+					store.ZAdd(retriesKey, due, value)
+				}
+				return
+			}
+		}
+		if len(values) < 100 {
+			return
+		}
+	}
+}
+
+// getDeadLetter returns a recorded dead letter
+func getDeadLetter(id string) (*deadLetter, error) {
+	s, err := store.Get(deadLetterKey(id))
+	if err != nil {
+		return nil, err
+	}
+	var d deadLetter
+	if err := json.Unmarshal([]byte(s), &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// listDeadLetters returns all recorded dead letters, oldest first
+func listDeadLetters() ([]deadLetter, error) {
+	keys, err := store.Keys(deadLetterKey("*"))
+	if err != nil {
+		return nil, err
+	}
+	found := make([]deadLetter, 0, len(keys))
+	for _, key := range keys {
+		d, err := getDeadLetter(strings.TrimPrefix(key, deadLetterKey("")))
+		if err == store.ErrNil { // deleted concurrently
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, *d)
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].Time.Before(found[j].Time) })
+	return found, nil
+}
+
+// redriveDeadLetter resends a dead letter to its target and forgets it
+func redriveDeadLetter(ctx context.Context, id string) error {
+	d, err := getDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	m := map[string]string{
+		"command":         "tell",
+		"path":            d.Path,
+		"payload":         d.Payload,
+		"deadLetterTopic": d.Topic,
+	}
+	if d.Source != "" {
+		m["source"] = d.Source
+	}
+	if d.Actor != nil {
+		m["protocol"] = "actor"
+		m["type"] = d.Actor.Type
+		m["id"] = d.Actor.ID
+	} else {
+		m["protocol"] = "service"
+		m["service"] = d.Service
+		m["method"] = d.Method
+		m["header"] = d.Header
+	}
+	if err := pubsub.Send(ctx, false, m); err != nil {
+		return err
+	}
+	_, err = store.Del(deadLetterKey(id))
+	return err
+}
+
+// format dead letters as JSON or as human-readable text
+func formatDeadLetters(found []deadLetter, format string) (string, error) {
+	if format == "json" || format == "application/json" {
+		m, err := json.MarshalIndent(found, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		return string(m), nil
+	}
+	var str strings.Builder
+	for _, d := range found {
+		target := d.Service
+		if d.Actor != nil {
+			target = fmt.Sprintf("%v[%v]", d.Actor.Type, d.Actor.ID)
+		}
+		fmt.Fprintf(&str, "%v %v: %v%v, %v attempt(s), status %v, %v\n", d.ID, d.Time.Format(time.RFC3339), target, d.Path, d.Attempts, d.Status, d.Error)
+	}
+	return str.String(), nil
+}
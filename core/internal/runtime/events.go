@@ -20,10 +20,13 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 
+	"github.com/IBM/kar.git/core/internal/config"
 	"github.com/IBM/kar.git/core/internal/pubsub"
+	"github.com/IBM/kar.git/core/internal/tracing"
 	"github.com/IBM/kar.git/core/pkg/logger"
 )
 
@@ -43,9 +46,11 @@ type source struct {
 	// The expected MIME type of events delivered by this subscription
 	ContentType string `json:"contenttype,omitempty"`
 	// Use the oldest available offset if no offset was previously committed
-	OffsetOldest bool               `json:"oldestoffset"`
-	cancel       context.CancelFunc // not serialized
-	closed       <-chan struct{}    // not serialized
+	OffsetOldest bool `json:"oldestoffset"`
+	// The topic receiving events that cannot be delivered (defaults to the application dead-letter topic)
+	DeadLetterTopic string             `json:"deadLetterTopic,omitempty"`
+	cancel          context.CancelFunc // not serialized
+	closed          <-chan struct{}    // not serialized
 }
 
 // EventSubscribeOptions documents the request body for subscribing an actor to a topic
@@ -59,6 +64,10 @@ type EventSubscribeOptions struct {
 	Path string `json:"path"`
 	// The name of the topic being subscribed to
 	Topic string `json:"topic"`
+	// The optional name of the topic receiving the events that cannot be delivered to the actor.
+	// If not provided, the application dead-letter topic is used if any.
+	// Example: myTopic-dlq
+	DeadLetterTopic string `json:"deadLetterTopic,omitempty"`
 }
 
 // topicCreateOptions documents the request body for creating a topic
@@ -144,14 +153,15 @@ func (c sources) parse(actor Actor, id, key, payload string) (binding, map[strin
 
 func (c sources) load(actor Actor, id, key string, m map[string]string) (binding, error) {
 	return source{
-		Actor:        actor,
-		ID:           id,
-		key:          key,
-		Path:         m["path"],
-		Topic:        m["topic"],
-		Group:        m["group"],
-		ContentType:  m["contentType"],
-		OffsetOldest: m["offsetOldest"] == "true",
+		Actor:           actor,
+		ID:              id,
+		key:             key,
+		Path:            m["path"],
+		Topic:           m["topic"],
+		Group:           m["group"],
+		ContentType:     m["contentType"],
+		OffsetOldest:    m["offsetOldest"] == "true",
+		DeadLetterTopic: m["deadLetterTopic"],
 	}, nil
 }
 
@@ -175,9 +185,44 @@ func subscribe(ctx context.Context, s source) (<-chan struct{}, int, error) {
 			}
 			arg = string(buf)
 		}
-		err := TellActor(ctx, s.Actor, s.Path, "["+arg+"]", false)
+		m := map[string]string{
+			"protocol": "actor",
+			"type":     s.Actor.Type,
+			"id":       s.Actor.ID,
+			"command":  "tell",
+			"path":     s.Path,
+			"payload":  "[" + arg + "]",
+			"source":   s.Topic,
+		}
+		if s.DeadLetterTopic != "" {
+			m["deadLetterTopic"] = s.DeadLetterTopic
+		}
+		tctx := ctx
+		if jsonType { // continue trace of cloud event if any
+			var ce struct {
+				Traceparent string `json:"traceparent"`
+				Tracestate  string `json:"tracestate"`
+			}
+			if json.Unmarshal(msg.Value, &ce) == nil {
+				tctx = tracing.Extract(ctx, ce.Traceparent, ce.Tracestate)
+			}
+		}
+		tctx, span := tracing.Start(tctx, "event "+s.Topic, tracing.KindConsumer)
+		span.SetAttribute("kar.actor.type", s.Actor.Type)
+		span.SetAttribute("kar.actor.id", s.Actor.ID)
+		span.SetAttribute("kar.path", s.Path)
+		span.SetAttribute("kar.subscription", s.ID)
+		err := pubsub.Send(tctx, false, m)
+		span.SetError(err)
+		span.End()
 		if err != nil {
 			logger.Error("failed to post event from topic %s: %v", s.Topic, err)
+			if ctx.Err() == nil && deadLetterTopic(m) != "" {
+				m["attempt"] = strconv.Itoa(config.DeadLetterAttempts - 1) // skip retries
+				if failedTell(ctx, m, 0, err.Error()) == nil {
+					msg.Mark()
+				}
+			}
 		} else {
 			msg.Mark()
 		}
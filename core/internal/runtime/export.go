@@ -0,0 +1,252 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+/*
+ * This file contains the export and import of application state.
+ *
+ * An export is a sequence of JSON objects, one per line.
+ * The first line is an exportHeader, the following lines are exportRecords.
+ */
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/pubsub"
+	"github.com/IBM/kar.git/core/internal/store"
+)
+
+const (
+	exportFormat  = "kar-export"
+	exportVersion = 1 // increment on incompatible changes of the format
+)
+
+// exportHeader is the first line of an export
+type exportHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	App     string    `json:"app"`
+	Time    time.Time `json:"time"`
+}
+
+// exportRecord is an actor state, placement, or binding
+type exportRecord struct {
+	// One of state, placement, reminders, subscriptions
+	Kind string `json:"kind"`
+	// The actor type
+	Type string `json:"type"`
+	// The actor instance id
+	ID string `json:"id"`
+	// The binding id for reminders and subscriptions
+	BindingID string `json:"bindingId,omitempty"`
+	// The partition for reminders and subscriptions
+	Partition string `json:"partition,omitempty"`
+	// The sidecar for placements
+	Sidecar string `json:"sidecar,omitempty"`
+	// The stored fields for states and bindings
+	Data map[string]string `json:"data,omitempty"`
+}
+
+// selected returns true if the actor type is selected by the filter (all types if empty)
+func selected(filter []string, actorType string) bool {
+	if len(filter) == 0 {
+		return true
+	}
+	for _, t := range filter {
+		if t == actorType {
+			return true
+		}
+	}
+	return false
+}
+
+// exportApp writes the actor state, placements, and bindings of the application
+// for the selected actor types (all types if empty) and returns the number of records
+func exportApp(w io.Writer, types []string) (int, error) {
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(exportHeader{Format: exportFormat, Version: exportVersion, App: config.AppName, Time: time.Now().UTC()}); err != nil {
+		return 0, err
+	}
+	count := 0
+
+	// actor state
+	keys, err := store.Keys(stateKey("*", "*"))
+	if err != nil {
+		return count, err
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		parts := strings.SplitN(key, config.Separator, 4)
+		if len(parts) != 4 || !selected(types, parts[2]) {
+			continue
+		}
+		data, err := store.HGetAll(key)
+		if err != nil {
+			return count, err
+		}
+		if len(data) == 0 { // state no longer exists
+			continue
+		}
+		if err := enc.Encode(exportRecord{Kind: "state", Type: parts[2], ID: parts[3], Data: data}); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	// placements
+	instances, err := pubsub.GetAllActorInstances("")
+	if err != nil {
+		return count, err
+	}
+	actorTypes := make([]string, 0, len(instances))
+	for t := range instances {
+		if selected(types, t) {
+			actorTypes = append(actorTypes, t)
+		}
+	}
+	sort.Strings(actorTypes)
+	for _, t := range actorTypes {
+		placements, err := pubsub.GetPlacements(t)
+		if err != nil {
+			return count, err
+		}
+		ids := make([]string, 0, len(placements))
+		for id := range placements {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			if err := enc.Encode(exportRecord{Kind: "placement", Type: t, ID: id, Sidecar: placements[id]}); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+
+	// reminders and subscriptions
+	for _, kind := range []string{"reminders", "subscriptions"} {
+		keys, err := store.Keys(bindingKey(kind, Actor{Type: "*", ID: "*"}, "*", "*"))
+		if err != nil {
+			return count, err
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			_, actor, partition, id := keyBinding(key)
+			if !selected(types, actor.Type) {
+				continue
+			}
+			data, err := store.HGetAll(key)
+			if err != nil {
+				return count, err
+			}
+			if len(data) == 0 { // binding no longer exists
+				continue
+			}
+			if err := enc.Encode(exportRecord{Kind: kind, Type: actor.Type, ID: actor.ID, BindingID: id, Partition: partition, Data: data}); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// importApp reads an export and stores the records for the selected actor types (all types if empty)
+// existing actor state and bindings with the same keys are replaced, existing placements are preserved
+// bindings are remapped to the given number of partitions if not zero
+// returns the number of imported records
+func importApp(r io.Reader, types []string, partitions int) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header exportHeader
+	if err := dec.Decode(&header); err != nil {
+		return 0, fmt.Errorf("invalid export header: %v", err)
+	}
+	if header.Format != exportFormat {
+		return 0, fmt.Errorf("invalid export format %q", header.Format)
+	}
+	if header.Version < 1 || header.Version > exportVersion {
+		return 0, fmt.Errorf("unsupported export version %v", header.Version)
+	}
+	count := 0
+	for line := 2; ; line++ {
+		var rec exportRecord
+		if err := dec.Decode(&rec); err == io.EOF {
+			return count, nil
+		} else if err != nil {
+			return count, fmt.Errorf("invalid record at line %v: %v", line, err)
+		}
+		if rec.Type == "" || rec.ID == "" {
+			return count, fmt.Errorf("invalid record at line %v: missing actor type or id", line)
+		}
+		if !selected(types, rec.Type) {
+			continue
+		}
+		actor := Actor{Type: rec.Type, ID: rec.ID}
+		switch rec.Kind {
+		case "state":
+			key := stateKey(actor.Type, actor.ID)
+			if _, err := store.Del(key); err != nil {
+				return count, err
+			}
+			if _, err := store.HSetMultiple(key, rec.Data); err != nil {
+				return count, err
+			}
+
+		case "placement":
+			if _, err := pubsub.CompareAndSetSidecar(actor.Type, actor.ID, "", rec.Sidecar); err != nil {
+				return count, err
+			}
+
+		case "reminders", "subscriptions":
+			if rec.BindingID == "" {
+				return count, fmt.Errorf("invalid record at line %v: missing binding id", line)
+			}
+			partition := rec.Partition
+			if partitions > 0 {
+				p, err := strconv.Atoi(partition)
+				if err != nil || p < 0 {
+					return count, fmt.Errorf("invalid record at line %v: invalid partition %q", line, partition)
+				}
+				partition = strconv.Itoa(p % partitions)
+			}
+			keys, err := store.Keys(bindingKey(rec.Kind, actor, "*", rec.BindingID))
+			if err != nil {
+				return count, err
+			}
+			for _, key := range keys {
+				if _, err := store.Del(key); err != nil {
+					return count, err
+				}
+			}
+			if _, err := store.HSetMultiple(bindingKey(rec.Kind, actor, partition, rec.BindingID), rec.Data); err != nil {
+				return count, err
+			}
+
+		default:
+			return count, fmt.Errorf("invalid record at line %v: unknown kind %q", line, rec.Kind)
+		}
+		count++
+	}
+}
@@ -36,7 +36,7 @@ import (
 )
 
 var (
-	url    = fmt.Sprintf("http://127.0.0.1:%d", config.AppPort)
+	url    string
 	client http.Client
 )
 
@@ -44,7 +44,9 @@ func fakeDialTLS(network, addr string, cfg *tls.Config) (net.Conn, error) {
 	return net.Dial(network, addr)
 }
 
-func init() {
+// initClient initializes the http client and bulkhead of the application process from the configuration
+func initClient() {
+	url = fmt.Sprintf("http://127.0.0.1:%d", config.AppPort)
 	var transport http.RoundTripper
 	if config.H2C {
 		transport = &http2.Transport{AllowHTTP: true, DialTLS: fakeDialTLS}
@@ -57,6 +59,7 @@ func init() {
 	if config.RequestRetryLimit >= 0 {
 		client.Timeout = config.RequestRetryLimit
 	}
+	inFlight = newSlots(config.AppMaxInFlight)
 }
 
 // ReadAll converts the body of a request to a string
@@ -72,6 +75,23 @@ func invoke(ctx context.Context, method string, msg map[string]string) (*Reply,
 		return nil, ctx.Err()
 	default:
 	}
+	invokeStart := time.Now()
+
+	key := breakerKey(msg["path"])
+	path := msg["path"]
+	if !allow(key) {
+		invokeRejections.Inc("breaker")
+		invokeRequests.Inc(method, "rejected")
+		invokeDuration.Since(invokeStart, method)
+		return &Reply{StatusCode: http.StatusServiceUnavailable, Payload: fmt.Sprintf("circuit breaker for %s is open", key), ContentType: "text/plain", rejected: true}, nil
+	}
+	isNested := nested(msg)
+	if !acquireSlot(ctx, path, isNested) {
+		invokeRequests.Inc(method, "error")
+		invokeDuration.Since(invokeStart, method)
+		return nil, ctx.Err()
+	}
+	defer releaseSlot(path, isNested)
 
 	req, err := http.NewRequestWithContext(ctx, method, url+msg["path"], strings.NewReader(msg["payload"]))
 
@@ -97,7 +117,27 @@ func invoke(ctx context.Context, method string, msg map[string]string) (*Reply,
 			req.Header.Set("Accept", msg["accept"])
 		}
 	}
+	if msg["traceparent"] != "" { // replace trace context of original request if any
+		req.Header.Set("traceparent", msg["traceparent"])
+		req.Header.Del("tracestate")
+		if msg["tracestate"] != "" {
+			req.Header.Set("tracestate", msg["tracestate"])
+		}
+	}
+	if msg["chain"] != "" { // pass call chain to actor method
+		req.Header.Set(callChainHeader, msg["chain"])
+	}
 	var reply *Reply
+	tripped := false
+	// failed records a failed attempt and stops retrying if the circuit breaker opens
+	failed := func(err error) error {
+		record(key, true)
+		if isOpen(key) {
+			tripped = true
+			return backoff.Permanent(err)
+		}
+		return err
+	}
 	b := backoff.NewExponentialBackOff()
 	if config.RequestRetryLimit >= 0 {
 		b.MaxElapsedTime = config.RequestRetryLimit
@@ -122,7 +162,7 @@ func invoke(ctx context.Context, method string, msg map[string]string) (*Reply,
 			if err == ctx.Err() {
 				return backoff.Permanent(err)
 			}
-			return err
+			return failed(err)
 		}
 		buf, err := ioutil.ReadAll(res.Body) // TODO size limit?
 		if err != nil {
@@ -134,19 +174,31 @@ func invoke(ctx context.Context, method string, msg map[string]string) (*Reply,
 			if err == ctx.Err() {
 				return backoff.Permanent(err)
 			}
-			return err
+			return failed(err)
 		}
 		res.Body.Close()
 		if length, err := strconv.Atoi(res.Header.Get("Content-Length")); err == nil && len(buf) != length {
 			logger.Warning("failed to invoke %s: unexpected content length (%d != %d)", msg["path"], length, len(buf))
-			return errors.New("unexpected content length")
+			return failed(errors.New("unexpected content length"))
 		}
 		reply = &Reply{StatusCode: res.StatusCode, Payload: string(buf), ContentType: res.Header.Get("Content-Type")}
 		return nil
 	}, backoff.WithContext(b, ctx))
+	if tripped {
+		invokeRejections.Inc("breaker")
+		reply, err = &Reply{StatusCode: http.StatusServiceUnavailable, Payload: fmt.Sprintf("circuit breaker for %s is open", key), ContentType: "text/plain"}, nil
+	} else if reply != nil {
+		record(key, failure(reply, nil))
+	}
 	if ctx.Err() != nil {
 		CloseIdleConnections() // don't keep connection alive once ctx is cancelled
 	}
+	code := "error"
+	if reply != nil {
+		code = strconv.Itoa(reply.StatusCode)
+	}
+	invokeRequests.Inc(method, code)
+	invokeDuration.Since(invokeStart, method)
 	return reply, err
 }
 
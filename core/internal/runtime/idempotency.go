@@ -0,0 +1,181 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+/*
+ * This file contains the support for idempotency keys.
+ *
+ * The replies to calls and tells with an idempotency key are recorded per actor
+ * instance or service for a retention window. A call or tell with a recorded
+ * key returns the recorded reply instead of invoking the application again.
+ *
+ * A reply is a versioned hash in the store. An invocation first claims the key
+ * atomically by marking the hash pending with the id of its sidecar, then records
+ * its reply if final or releases the claim so that the invocation can be retried.
+ * A duplicate call awaits the reply of the invocation in progress, a duplicate
+ * tell is dropped. Claims of sidecars that have left the application are ignored.
+ */
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/pubsub"
+	"github.com/IBM/kar.git/core/internal/store"
+	"github.com/IBM/kar.git/core/pkg/logger"
+)
+
+const replyVersionField = "version"
+
+var errReplyPending = errors.New("idempotency key claimed by an invocation in progress")
+
+// context key for idempotency keys
+type idempotencyKeyType struct{}
+
+// withIdempotencyKey returns a context carrying an idempotency key if not empty
+func withIdempotencyKey(ctx context.Context, key string) context.Context {
+	if key == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, idempotencyKeyType{}, key)
+}
+
+// setIdempotencyKey records the idempotency key of the context if any in a message
+func setIdempotencyKey(ctx context.Context, msg map[string]string) {
+	if key, ok := ctx.Value(idempotencyKeyType{}).(string); ok {
+		msg["idempotencyKey"] = key
+	}
+}
+
+// store key for the reply recorded for the target and idempotency key of a message
+// each reply is a separate key so that it expires on its own
+func idempotencyKey(msg map[string]string) string {
+	if msg["protocol"] == "actor" {
+		return "idempotency" + config.Separator + "actor" + config.Separator + msg["type"] + config.Separator + msg["id"] + config.Separator + msg["idempotencyKey"]
+	}
+	return "idempotency" + config.Separator + "service" + config.Separator + msg["service"] + config.Separator + msg["idempotencyKey"]
+}
+
+// live returns true if a sidecar is still a member of the application
+func live(sidecar string) bool {
+	if sidecar == config.ID {
+		return true
+	}
+	for _, s := range pubsub.Sidecars() {
+		if s == sidecar {
+			return true
+		}
+	}
+	return false
+}
+
+// claimReply returns the reply recorded for the idempotency key of a message if any,
+// otherwise claims the key and returns the version of the claim
+// returns errReplyPending if the key is claimed by an invocation in progress
+// messages without idempotency key and store failures return neither reply nor claim
+func claimReply(msg map[string]string) (*Reply, string, error) {
+	if msg["idempotencyKey"] == "" {
+		return nil, "", nil
+	}
+	key := idempotencyKey(msg)
+	for {
+		m, err := store.HMGet(key, []string{replyVersionField, "status", "owner", "reply"})
+		if err != nil {
+			msgLogger(msg).Error("failed to get reply for idempotency key %s: %v", msg["idempotencyKey"], err)
+			return nil, "", nil
+		}
+		switch m[1] {
+		case "done":
+			var reply Reply
+			if err := json.Unmarshal([]byte(m[3]), &reply); err != nil {
+				msgLogger(msg).Error("failed to decode reply for idempotency key %s: %v", msg["idempotencyKey"], err)
+				return nil, "", nil
+			}
+			logger.Debug("returning recorded reply for idempotency key %s", msg["idempotencyKey"])
+			return &reply, "", nil
+		case "pending":
+			if live(m[2]) {
+				return nil, "", errReplyPending
+			}
+		}
+		expected := m[0]
+		if expected == "" {
+			expected = "0"
+		}
+		result, err := store.HUpdate(key, replyVersionField, store.HashUpdate{
+			Expected: []string{expected},
+			Updates:  map[string]string{"status": "pending", "owner": config.ID},
+			TTL:      config.IdempotencyRetention})
+		if err == store.ErrConflict { // claimed or recorded concurrently
+			continue
+		}
+		if err != nil {
+			msgLogger(msg).Error("failed to claim idempotency key %s: %v", msg["idempotencyKey"], err)
+			return nil, "", nil
+		}
+		return nil, result.Version, nil
+	}
+}
+
+// awaitClaim claims the idempotency key of a call message like claimReply
+// but awaits the completion of the invocation in progress if any
+func awaitClaim(ctx context.Context, msg map[string]string) (*Reply, string, error) {
+	delay := promisePollInterval
+	for {
+		reply, version, err := claimReply(msg)
+		if err != errReplyPending {
+			return reply, version, err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// recordReply records the reply for a claimed idempotency key
+// the claim is released instead if the reply is nil, a server error, or a timeout
+// so that the invocation can be retried
+func recordReply(msg map[string]string, version string, reply *Reply) {
+	if version == "" {
+		return
+	}
+	update := store.HashUpdate{Expected: []string{version}, Clear: true}
+	if reply != nil && reply.StatusCode < http.StatusInternalServerError && reply.StatusCode != http.StatusRequestTimeout {
+		b, err := json.Marshal(reply)
+		if err != nil {
+			msgLogger(msg).Error("failed to encode reply for idempotency key %s: %v", msg["idempotencyKey"], err)
+		} else {
+			update = store.HashUpdate{
+				Expected: []string{version},
+				Removals: []string{"owner"},
+				Updates:  map[string]string{"status": "done", "reply": string(b)},
+				TTL:      config.IdempotencyRetention}
+		}
+	}
+	if _, err := store.HUpdate(idempotencyKey(msg), replyVersionField, update); err != nil {
+		msgLogger(msg).Error("failed to record reply for idempotency key %s: %v", msg["idempotencyKey"], err)
+	}
+}
@@ -0,0 +1,75 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+)
+
+func TestIdempotentReplies(t *testing.T) {
+	dialStore(t)
+	retention0, id0 := config.IdempotencyRetention, config.ID
+	t.Cleanup(func() { config.IdempotencyRetention, config.ID = retention0, id0 })
+	config.IdempotencyRetention, config.ID = time.Minute, "s1"
+	msg := map[string]string{"protocol": "actor", "type": "A", "id": "a", "command": "tell", "idempotencyKey": "k"}
+
+	reply, version, err := claimReply(msg)
+	if reply != nil || version == "" || err != nil {
+		t.Fatalf("claimReply = %v, %v, %v", reply, version, err)
+	}
+	if _, _, err := claimReply(msg); err != errReplyPending {
+		t.Fatalf("claimReply while pending = %v, want %v", err, errReplyPending)
+	}
+	recordReply(msg, version, &Reply{StatusCode: 500}) // not final
+	reply, version, err = claimReply(msg)
+	if reply != nil || version == "" || err != nil {
+		t.Fatalf("claimReply after release = %v, %v, %v", reply, version, err)
+	}
+	recordReply(msg, version, &Reply{StatusCode: 200, Payload: "ok"})
+	if reply, _, err := claimReply(msg); err != nil || reply == nil || reply.Payload != "ok" {
+		t.Fatalf("claimReply after completion = %v, %v", reply, err)
+	}
+
+	other := map[string]string{"protocol": "actor", "type": "A", "id": "a", "command": "tell", "idempotencyKey": "k2"}
+	if _, _, err := claimReply(other); err != nil {
+		t.Fatal(err)
+	}
+	config.ID = "s2" // claim of a sidecar that has left
+	if reply, version, err := claimReply(other); reply != nil || version == "" || err != nil {
+		t.Errorf("claimReply of abandoned claim = %v, %v, %v", reply, version, err)
+	}
+}
+
+func TestTellMessage(t *testing.T) {
+	msg := map[string]string{
+		"protocol":       "actor",
+		"type":           "A",
+		"id":             "a",
+		"command":        "tell",
+		"path":           actorRuntimeRoutePrefix + "A/a/s/m",
+		"idempotencyKey": "k",
+		"priority":       "3",
+		"chain":          `[{"type":"B","id":"b","session":"t"},{"type":"A","id":"a","session":"s"}]`,
+	}
+	m := tellMessage(msg)
+	if m["path"] != "/m" || m["idempotencyKey"] != "k" || m["priority"] != "3" || m["chain"] != `[{"type":"B","id":"b","session":"t"}]` {
+		t.Errorf("tellMessage = %v", m)
+	}
+}
@@ -0,0 +1,157 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/pubsub"
+	"github.com/IBM/kar.git/core/internal/store"
+	"github.com/IBM/kar.git/core/pkg/logger"
+)
+
+// store key for the last activity times of the instances of an actor type
+// the key holds a hash from instance ids to unix times in milliseconds
+func idleKey(t string) string {
+	return "main" + config.Separator + "idle" + config.Separator + t
+}
+
+// touchActor records the last activity time of an actor if its type has an idle ttl
+func touchActor(actor Actor, t time.Time) {
+	if config.ActorIdleTTL[actor.Type] <= 0 {
+		return
+	}
+	if _, err := store.HSet(idleKey(actor.Type), actor.ID, strconv.FormatInt(t.UnixNano()/int64(time.Millisecond), 10)); err != nil {
+		logger.Error("failed to record activity of actor %v: %v", actor, err)
+	}
+}
+
+// lastActivity returns the recorded last activity time of an actor in unix milliseconds or 0 if none
+func lastActivity(actor Actor) (int64, error) {
+	s, err := store.HGet(idleKey(actor.Type), actor.ID)
+	if err == store.ErrNil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// idle returns true if an actor acquired by a delete command has been idle since before the cutoff time
+// idle records the current time as the last activity time of the actor if the actor is active
+func idle(actor Actor, fresh bool, cutoff string) bool {
+	if !fresh { // actor is resident
+		touchActor(actor, time.Now())
+		return false
+	}
+	last, err := lastActivity(actor)
+	if err != nil {
+		logger.Error("failed to get last activity of actor %v: %v", actor, err)
+		return false
+	}
+	c, _ := strconv.ParseInt(cutoff, 10, 64)
+	return last < c
+}
+
+// deleteActorBindings deletes all the reminders and subscriptions of an actor
+func deleteActorBindings(actor Actor) {
+	for _, kind := range []string{"reminders", "subscriptions"} {
+		deleteBindings(kind, actor, "")
+		// bindings not loaded in memory
+		keys, err := store.Keys(bindingKey(kind, actor, "*", "*"))
+		if err != nil {
+			logger.Error("failed to list %s of actor %v: %v", kind, actor, err)
+			continue
+		}
+		for _, key := range keys {
+			store.Del(key)
+		}
+	}
+}
+
+// CollectIdleActors runs periodically and deletes the actors idle for longer than the ttl of their type
+func CollectIdleActors(ctx context.Context) {
+	if len(config.ActorIdleTTL) == 0 {
+		return
+	}
+	ticker := time.NewTicker(config.ActorIdleSweepInterval)
+	for {
+		select {
+		case now := <-ticker.C:
+			for t, ttl := range config.ActorIdleTTL {
+				if err := collectIdleActors(ctx, t, now.Add(-ttl)); err != nil && err != ctx.Err() {
+					logger.Error("failed to collect idle actors of type %s: %v", t, err)
+				}
+			}
+		case <-ctx.Done():
+			ticker.Stop()
+			return
+		}
+	}
+}
+
+// collectIdleActors requests the deletion of the instances of actor type t idle since before the deadline
+// a sidecar handles the instances placed on this sidecar, the first live sidecar also handles
+// the instances placed on dead sidecars or not placed
+func collectIdleActors(ctx context.Context, t string, deadline time.Time) error {
+	records, err := store.HGetAll(idleKey(t))
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	placements, err := pubsub.GetPlacements(t)
+	if err != nil {
+		return err
+	}
+	sidecars := pubsub.Sidecars()
+	sort.Strings(sidecars)
+	live := map[string]bool{}
+	for _, sidecar := range sidecars {
+		live[sidecar] = true
+	}
+	first := len(sidecars) > 0 && sidecars[0] == config.ID
+
+	cutoff := deadline.UnixNano() / int64(time.Millisecond)
+	for id, s := range records {
+		last, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || last >= cutoff {
+			continue
+		}
+		sidecar := placements[id]
+		if sidecar != config.ID && !(first && !live[sidecar]) {
+			continue
+		}
+		logger.Debug("deleting actor {%s %s} idle since %v", t, id, time.Unix(0, last*int64(time.Millisecond)))
+		err = pubsub.Send(ctx, false, map[string]string{
+			"protocol":   "actor",
+			"type":       t,
+			"id":         id,
+			"command":    "delete",
+			"idleBefore": strconv.FormatInt(cutoff, 10)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/metrics"
+)
+
+var (
+	invokeRequests       = metrics.NewCounterVec("kar_invoke_requests_total", "Requests from the sidecar to the application process by method and status code.", "method", "code")
+	invokeDuration       = metrics.NewHistogramVec("kar_invoke_duration_seconds", "Latency of requests from the sidecar to the application process including retries.", metrics.DefaultBuckets, "method")
+	actorAcquireWait     = metrics.NewHistogramVec("kar_actor_acquire_wait_seconds", "Time spent waiting to acquire an actor instance.", metrics.DefaultBuckets)
+	actorAcquireTimeouts = metrics.NewCounterVec("kar_actor_acquire_timeouts_total", "Number of actor acquisitions that timed out.")
+	actorDeadlocks       = metrics.NewCounterVec("kar_actor_deadlocks_total", "Number of actor calls rejected because the call chain already holds the actor.")
+	invokeRejections     = metrics.NewCounterVec("kar_invoke_rejections_total", "Requests to the application process rejected by circuit breakers.", "reason")
+	reminderLateness     = metrics.NewHistogramVec("kar_reminder_lateness_seconds", "Delay between the target time and the actual fire time of reminders.", []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 3600})
+)
+
+func init() {
+	metrics.NewGaugeVecFunc("kar_actors_resident", "Number of actor instances resident in this sidecar by actor type.", "type", func() map[string]float64 {
+		counts := map[string]float64{}
+		for _, t := range config.ActorTypes {
+			counts[t] = 0
+		}
+		for t, ids := range getMyActiveActors("") {
+			counts[t] = float64(len(ids))
+		}
+		return counts
+	})
+	metrics.NewGaugeFunc("kar_reminders_queued", "Number of reminders queued in this sidecar.", func() float64 {
+		arMutex.Lock()
+		defer arMutex.Unlock()
+		return float64(activeReminders.Len())
+	})
+	metrics.NewGaugeFunc("kar_invoke_inflight", "Number of requests from the sidecar to the application process in progress.", func() float64 {
+		return float64(getBulkhead().InFlight)
+	})
+	metrics.NewGaugeVecFunc("kar_breaker_open", "Whether the circuit breaker of an actor type or path prefix is open (1) or half-open (0.5).", "key", func() map[string]float64 {
+		states := map[string]float64{}
+		for k, b := range getBreakers() {
+			switch b.State {
+			case breakerOpen:
+				states[k] = 1
+			case breakerHalfOpen:
+				states[k] = 0.5
+			}
+		}
+		return states
+	})
+	metrics.NewGaugeVecFunc("kar_messages_inflight", "Number of calls and tells in progress in this sidecar by actor type or service.", "type", func() map[string]float64 {
+		return messageCounts(admitted)
+	})
+	metrics.NewGaugeVecFunc("kar_messages_waiting", "Number of calls and tells awaiting admission in this sidecar by actor type or service.", "type", func() map[string]float64 {
+		return messageCounts(waiting)
+	})
+	metrics.NewGaugeFunc("kar_requests_pending", "Number of calls awaiting a response in this sidecar.", func() float64 {
+		n := 0
+		requests.Range(func(_, _ interface{}) bool {
+			n++
+			return true
+		})
+		return float64(n)
+	})
+}
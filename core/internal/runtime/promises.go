@@ -0,0 +1,262 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+/*
+ * This file contains the persistence of the results of promise calls.
+ *
+ * A promise is a hash in the store with a version field and a status field.
+ * The status is pending until the promise is completed with the result of the call.
+ * The version is drawn from the shared version counter of the store, so a promise
+ * is completed by checking its current version to detect concurrent completions.
+ * The promise also records the callee so that a cancellation can be sent to the sidecar
+ * processing the call.
+ */
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/pubsub"
+	"github.com/IBM/kar.git/core/internal/store"
+	"github.com/IBM/kar.git/core/pkg/logger"
+)
+
+const (
+	promiseVersionField = "version"
+
+	// initial interval between polls of a promise created by another sidecar
+	promisePollInterval = 100 * time.Millisecond
+)
+
+// cancelled requests received by this sidecar -> time of cancellation
+var cancelled = sync.Map{}
+
+// store key for a promise
+func promiseKey(request string) string {
+	return "promise" + config.Separator + request
+}
+
+// createPromise records a pending promise for a call message in the store
+// returns the version of the promise
+func createPromise(request string, msg map[string]string) (string, error) {
+	updates := map[string]string{"status": "pending"}
+	if msg["protocol"] == "actor" {
+		updates["type"] = msg["type"]
+		updates["id"] = msg["id"]
+	}
+	result, err := store.HUpdate(promiseKey(request), promiseVersionField, store.HashUpdate{
+		Expected: []string{"0"},
+		Updates:  updates,
+		TTL:      config.PromiseTTL})
+	return result.Version, err
+}
+
+// routePromise records the sidecar the call message of a pending promise was sent to
+// the promise is left unchanged if completed concurrently
+func routePromise(request, version, sidecar string) {
+	_, err := store.HUpdate(promiseKey(request), promiseVersionField, store.HashUpdate{
+		Expected: []string{version},
+		Updates:  map[string]string{"sidecar": sidecar},
+		TTL:      config.PromiseTTL})
+	if err != nil && err != store.ErrConflict {
+		logger.Error("failed to record sidecar of promise %s: %v", request, err)
+	}
+}
+
+// calleeSidecar returns the sidecar processing the call of a promise if known
+func calleeSidecar(request string) (string, error) {
+	m, err := store.HMGet(promiseKey(request), []string{"type", "id", "sidecar"})
+	if err != nil {
+		return "", err
+	}
+	if m[0] != "" && config.StatelessWorkers[m[0]] == 0 { // placed actor, may have moved
+		if sidecar, err := pubsub.GetSidecar(m[0], m[1]); err == nil && sidecar != "" {
+			return sidecar, nil
+		}
+	}
+	return m[2], nil
+}
+
+// completePromise records the result of a pending promise in the store
+// returns false if the promise was already completed or errUnknownRequest if there is no such promise
+func completePromise(request string, reply *Reply) (bool, error) {
+	current, err := store.HMGet(promiseKey(request), []string{promiseVersionField, "status"})
+	if err != nil {
+		return false, err
+	}
+	if current[0] == "" {
+		return false, errUnknownRequest
+	}
+	if current[1] != "pending" {
+		return false, nil
+	}
+	result, err := store.HUpdate(promiseKey(request), promiseVersionField, store.HashUpdate{
+		Expected: []string{current[0]},
+		Updates: map[string]string{
+			"status":       "done",
+			"statusCode":   strconv.Itoa(reply.StatusCode),
+			"content-type": reply.ContentType,
+			"payload":      reply.Payload},
+		TTL: config.PromiseTTL})
+	if err == store.ErrConflict { // completed or collected concurrently
+		if result.Version == "0" {
+			return false, errUnknownRequest
+		}
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// loadPromise returns the result of a promise if completed and whether the promise exists
+func loadPromise(request string) (*Reply, bool, error) {
+	m, err := store.HGetAll(promiseKey(request))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(m) == 0 {
+		return nil, false, nil
+	}
+	if m["status"] != "done" {
+		return nil, true, nil
+	}
+	statusCode, _ := strconv.Atoi(m["statusCode"])
+	return &Reply{StatusCode: statusCode, ContentType: m["content-type"], Payload: m["payload"]}, true, nil
+}
+
+// consumePromise forgets a promise once awaited
+func consumePromise(request string) {
+	requests.Delete(request)
+	promises.Delete(request)
+	if _, err := store.Del(promiseKey(request)); err != nil {
+		logger.Error("failed to delete promise %s: %v", request, err)
+	}
+}
+
+// collectPromises forgets the promises of this sidecar and the cancelled requests
+// created before the cutoff time
+// the store forgets them on its own when their time to live expires
+func collectPromises(cutoff time.Time) {
+	promises.Range(func(request, created interface{}) bool {
+		if created.(time.Time).Before(cutoff) {
+			requests.Delete(request)
+			promises.Delete(request)
+		}
+		return true
+	})
+	cancelled.Range(func(request, at interface{}) bool {
+		if at.(time.Time).Before(cutoff) {
+			cancelled.Delete(request)
+		}
+		return true
+	})
+}
+
+// cancelRequest records the cancellation of a request received by this sidecar
+// and aborts the request if in progress
+func cancelRequest(request string) {
+	cancelled.Store(request, time.Now())
+	if c, ok := inflight.Load(request); ok {
+		c.(context.CancelFunc)()
+	}
+}
+
+// isCancelled returns true if a call message has been cancelled
+func isCancelled(msg map[string]string) bool {
+	if msg["command"] != "call" || msg["request"] == "" {
+		return false
+	}
+	_, ok := cancelled.Load(msg["request"])
+	return ok
+}
+
+// AwaitPromise awaits the response to an actor or service call
+// the promise may have been created by any sidecar of the application
+func AwaitPromise(ctx context.Context, request string) (*Reply, error) {
+	var ch chan *Reply // nil unless the promise was created by this sidecar
+	if c, ok := requests.Load(request); ok {
+		ch = c.(chan *Reply)
+	}
+	delay := promisePollInterval
+	for {
+		select {
+		case r := <-ch:
+			consumePromise(request)
+			return r, nil
+		default:
+		}
+		reply, found, err := loadPromise(request)
+		if err != nil {
+			return nil, err
+		}
+		if reply != nil {
+			consumePromise(request)
+			return reply, nil
+		}
+		if !found && ch == nil {
+			return nil, errUnknownRequest
+		}
+		select {
+		case r := <-ch:
+			consumePromise(request)
+			return r, nil
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if delay < time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// CancelPromise cancels an actor or service call and completes its promise with a cancellation status
+func CancelPromise(ctx context.Context, request string) error {
+	reply := &Reply{StatusCode: statusCancelled, Payload: "Request Cancelled", ContentType: "text/plain"}
+	ok, err := completePromise(request, reply)
+	if err != nil || !ok { // unknown or already completed
+		return err
+	}
+	if ch, ok := requests.Load(request); ok {
+		select {
+		case ch.(chan *Reply) <- reply:
+		default: // answer already received
+		}
+	}
+	sidecars := pubsub.Sidecars()
+	sidecar, err := calleeSidecar(request)
+	if err != nil {
+		return err
+	}
+	if sidecar != "" {
+		sidecars = []string{sidecar}
+	} // else the callee is not known yet, ask every sidecar to abort the request
+	for _, sidecar := range sidecars {
+		err := pubsub.Send(ctx, false, map[string]string{
+			"protocol": "sidecar",
+			"sidecar":  sidecar,
+			"command":  "cancel",
+			"request":  request})
+		if err != nil && err != pubsub.ErrUnknownSidecar {
+			return err
+		}
+	}
+	return nil
+}
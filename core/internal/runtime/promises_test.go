@@ -0,0 +1,105 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/store"
+)
+
+func dialStore(t *testing.T) {
+	store0, app0 := config.Store, config.AppName
+	config.Store, config.AppName = config.StoreMemory, "test"
+	if err := store.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.Close()
+		config.Store, config.AppName = store0, app0
+	})
+}
+
+func TestPromises(t *testing.T) {
+	dialStore(t)
+	ttl0 := config.PromiseTTL
+	t.Cleanup(func() { config.PromiseTTL = ttl0 })
+	config.PromiseTTL = time.Minute
+
+	for _, request := range []string{"r1", "r2"} {
+		version, err := createPromise(request, map[string]string{"protocol": "service", "service": "svc"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		routePromise(request, version, "s1")
+		if sidecar, err := calleeSidecar(request); err != nil || sidecar != "s1" {
+			t.Fatalf("calleeSidecar(%s) = %v, %v", request, sidecar, err)
+		}
+		if reply, found, err := loadPromise(request); err != nil || !found || reply != nil {
+			t.Fatalf("loadPromise(%s) before completion = %v, %v, %v", request, reply, found, err)
+		}
+		ok, err := completePromise(request, &Reply{StatusCode: 200, ContentType: "text/plain", Payload: request})
+		if err != nil || !ok {
+			t.Fatalf("completePromise(%s) = %v, %v", request, ok, err)
+		}
+		if ok, err := completePromise(request, &Reply{StatusCode: statusCancelled}); err != nil || ok {
+			t.Errorf("completePromise(%s) twice = %v, %v", request, ok, err)
+		}
+		if reply, found, err := loadPromise(request); err != nil || !found || reply == nil || reply.Payload != request {
+			t.Errorf("loadPromise(%s) = %v, %v, %v", request, reply, found, err)
+		}
+	}
+	if _, err := completePromise("r3", &Reply{StatusCode: 200}); err != errUnknownRequest {
+		t.Errorf("completePromise of unknown promise = %v, want %v", err, errUnknownRequest)
+	}
+}
+
+func TestPromiseTTL(t *testing.T) {
+	dialStore(t)
+	ttl0 := config.PromiseTTL
+	t.Cleanup(func() { config.PromiseTTL = ttl0 })
+	config.PromiseTTL = time.Millisecond
+
+	if _, err := createPromise("r", map[string]string{"protocol": "service", "service": "svc"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, found, err := loadPromise("r"); err != nil || found {
+		t.Errorf("loadPromise after ttl = %v, %v", found, err)
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	msg := map[string]string{"protocol": "actor", "type": "A", "id": "a", "command": "call", "request": "c1"}
+	if isCancelled(msg) {
+		t.Fatal("request cancelled before cancellation")
+	}
+	cancelRequest("c1")
+	if !isCancelled(msg) {
+		t.Fatal("request not cancelled after cancellation")
+	}
+	collectPromises(time.Now().Add(-time.Minute))
+	if !isCancelled(msg) {
+		t.Fatal("recent cancellation collected")
+	}
+	collectPromises(time.Now().Add(time.Minute))
+	if isCancelled(msg) {
+		t.Error("old cancellation not collected")
+	}
+}
@@ -0,0 +1,139 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"context"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/pubsub"
+	"github.com/IBM/kar.git/core/pkg/logger"
+)
+
+// Rebalance moves idle actor placements from this sidecar to under-loaded sidecars on rebalance
+func Rebalance(ctx context.Context) {
+	for {
+		_, rebalance := pubsub.Partitions()
+		if config.ActorRebalancePolicy == "even" {
+			budget := config.ActorRebalanceLimit
+			for _, t := range config.ActorTypes {
+				if budget <= 0 || ctx.Err() != nil {
+					break
+				}
+				moved, err := rebalanceEven(ctx, t, budget)
+				if err != nil {
+					if err != ctx.Err() {
+						logger.Error("failed to rebalance actor type %s: %v", t, err)
+					}
+					break
+				}
+				budget -= moved
+			}
+		}
+		select {
+		case <-rebalance:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// rebalanceEven moves at most limit idle placements of actor type t from this sidecar
+// so that no sidecar hosting the type has more than its fair share of the placements
+// returns the number of moved placements
+func rebalanceEven(ctx context.Context, t string, limit int) (int, error) {
+	sidecars := pubsub.ActorHosts(t)
+	if len(sidecars) < 2 {
+		return 0, nil
+	}
+	placements, err := pubsub.GetPlacements(t)
+	if err != nil {
+		return 0, err
+	}
+
+	load := map[string]int{}
+	for _, sidecar := range sidecars {
+		load[sidecar] = 0
+	}
+	total := 0
+	candidates := []string{}
+	resident := map[string]struct{}{}
+	for _, id := range getMyActiveActors(t)[t] {
+		resident[id] = struct{}{}
+	}
+	for id, sidecar := range placements {
+		if _, ok := load[sidecar]; !ok { // ignore placements on dead sidecars
+			continue
+		}
+		load[sidecar]++
+		total++
+		if _, ok := resident[id]; sidecar == config.ID && !ok {
+			candidates = append(candidates, id)
+		}
+	}
+
+	fair := (total + len(sidecars) - 1) / len(sidecars) // ceil(total / #sidecars)
+	moved := 0
+	for _, id := range candidates {
+		if load[config.ID] <= fair || moved >= limit {
+			break
+		}
+		target := config.ID // least loaded sidecar
+		for _, sidecar := range sidecars {
+			if load[sidecar] < load[target] {
+				target = sidecar
+			}
+		}
+		if load[target]+1 > fair {
+			break
+		}
+		ok, err := moveIdleActor(ctx, Actor{Type: t, ID: id}, target)
+		if err != nil {
+			return moved, err
+		}
+		if ok {
+			load[config.ID]--
+			load[target]++
+			moved++
+		}
+	}
+	if moved > 0 {
+		logger.Info("rebalanced %v instances of actor type %s", moved, t)
+	}
+	return moved, nil
+}
+
+// moveIdleActor moves the placement and bindings of a non-resident actor to the target sidecar
+// returns false if the actor is resident or no longer placed on this sidecar
+func moveIdleActor(ctx context.Context, actor Actor, target string) (bool, error) {
+	e, fresh, err := actor.acquire(ctx, "exclusive", 0, nil)
+	if err == errActorHasMoved {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !fresh { // actor was activated concurrently
+		e.release("exclusive", true)
+		return false, nil
+	}
+	if err := e.migrate(target); err != nil {
+		return false, err
+	}
+	logger.Debug("moved idle actor %v to sidecar %s", actor, target)
+	return true, migrateBindings(ctx, actor, target)
+}
@@ -20,11 +20,15 @@ import (
 	"container/heap"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/IBM/kar.git/core/internal/config"
 	"github.com/IBM/kar.git/core/internal/store"
+	"github.com/IBM/kar.git/core/internal/tracing"
 	"github.com/IBM/kar.git/core/pkg/logger"
 )
 
@@ -40,15 +44,28 @@ func init() {
 
 // Reminder describes a time-triggered asynchronous invocation of a Path on an Actor
 type Reminder struct {
-	Actor       Actor
-	ID          string        `json:"id"`
-	key         string        // Implementation detail, do not serialize
-	Path        string        `json:"path"`
-	TargetTime  time.Time     `json:"targetTime"`
-	Period      time.Duration `json:"period,omitempty"` // 0 for one-shot reminders
-	EncodedData string        `json:"encodedData,omitempty"`
+	Actor         Actor
+	ID            string        `json:"id"`
+	key           string        // Implementation detail, do not serialize
+	Path          string        `json:"path"`
+	TargetTime    time.Time     `json:"targetTime"`
+	Period        time.Duration `json:"period,omitempty"`        // 0 for one-shot reminders
+	Cron          string        `json:"cron,omitempty"`          // empty unless scheduled with a cron expression
+	MisfirePolicy string        `json:"misfirePolicy,omitempty"` // empty for the default fireOnce policy
+	MaxCount      int           `json:"maxCount,omitempty"`      // 0 for unbounded repetition
+	EndTime       *time.Time    `json:"endTime,omitempty"`       // nil for unbounded repetition
+	FireCount     int           `json:"fireCount,omitempty"`     // number of times the reminder has fired
+	EncodedData   string        `json:"encodedData,omitempty"`
+	schedule      *cronSchedule // parsed Cron expression, do not serialize
 }
 
+// misfire policies
+const (
+	misfireFireOnce = "fireOnce" // fire once for all the missed occurrences
+	misfireFireAll  = "fireAll"  // fire once for each missed occurrence
+	misfireSkip     = "skip"     // do not fire missed occurrences
+)
+
 func (r Reminder) k() string {
 	return r.key
 }
@@ -65,6 +82,27 @@ type scheduleReminderPayload struct {
 	// to compute a new TargetTime for the next invocation of the reminder.
 	// Example: 30s
 	Period string `json:"period,omitempty"`
+	// The optional cron parameter is a cron expression used to create a periodic reminder.
+	// It has 5 fields (minute hour day-of-month month day-of-week) or 6 fields (with a leading second field)
+	// and may be prefixed with CRON_TZ= and an IANA time zone name (the default is UTC).
+	// The next TargetTime is computed from the expression in the specified time zone.
+	// If no targetTime is provided, the reminder first fires at the next activation time.
+	// The cron and period parameters are mutually exclusive.
+	// Example: CRON_TZ=America/New_York 0 9 * * MON-FRI
+	Cron string `json:"cron,omitempty"`
+	// The optional misfirePolicy parameter specifies how to handle occurrences of a periodic reminder
+	// missed by more than the acceptable delay, for instance because no sidecar was running.
+	// fireOnce (the default) fires once then resumes the schedule from the current time,
+	// fireAll fires once for each missed occurrence, skip drops the missed occurrences.
+	// A late one-shot reminder always fires once.
+	// Example: fireAll
+	MisfirePolicy string `json:"misfirePolicy,omitempty"`
+	// The optional maxCount parameter is the maximum number of times the reminder will fire.
+	// Example: 10
+	MaxCount int `json:"maxCount,omitempty"`
+	// The optional endTime parameter is the time after which a periodic reminder is deleted,
+	// specified as a string in an ISO-8601 compliant format.
+	EndTime *time.Time `json:"endTime,omitempty"`
 	// An optional parameter containing an arbitrary JSON value that will be provided as the
 	// payload when the `path` is invoked on the actor instance.
 	// Example: { msg: "Hello Friend!" }
@@ -82,15 +120,31 @@ func persistReminder(r Reminder) map[string]string {
 	if r.Period > 0 {
 		rMap["period"] = r.Period.String()
 	}
+	if r.Cron != "" {
+		rMap["cron"] = r.Cron
+	}
+	if r.MisfirePolicy != "" {
+		rMap["misfirePolicy"] = r.MisfirePolicy
+	}
+	if r.MaxCount > 0 {
+		rMap["maxCount"] = strconv.Itoa(r.MaxCount)
+	}
+	if r.EndTime != nil {
+		es, _ := r.EndTime.MarshalText()
+		rMap["endTime"] = string(es)
+	}
+	if r.FireCount > 0 {
+		rMap["fireCount"] = strconv.Itoa(r.FireCount)
+	}
 	if r.EncodedData != "" {
 		rMap["encodedData"] = r.EncodedData
 	}
 	return rMap
 }
 
-func persistTargetTime(key string, targetTime time.Time) {
+func persistTargetTime(key string, targetTime time.Time, fireCount int) {
 	ts, _ := targetTime.MarshalText()
-	store.HSet(key, "targetTime", string(ts))
+	store.HSet2(key, "targetTime", string(ts), "fireCount", strconv.Itoa(fireCount))
 }
 
 func (rq *reminderQueue) load(actor Actor, id, key string, rMap map[string]string) (binding, error) {
@@ -106,13 +160,46 @@ func (rq *reminderQueue) load(actor Actor, id, key string, rMap map[string]strin
 			return nil, err
 		}
 	}
+	var schedule *cronSchedule
+	if cs, present := rMap["cron"]; present {
+		schedule, err = parseCron(cs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var maxCount, fireCount int
+	if ms, present := rMap["maxCount"]; present {
+		maxCount, err = strconv.Atoi(ms)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if fs, present := rMap["fireCount"]; present {
+		fireCount, err = strconv.Atoi(fs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var endTime *time.Time
+	if es, present := rMap["endTime"]; present {
+		endTime = &time.Time{}
+		if err = endTime.UnmarshalText([]byte(es)); err != nil {
+			return nil, err
+		}
+	}
 	r := Reminder{Actor: Actor{Type: rMap["actorType"], ID: rMap["actorId"]},
-		ID:          rMap["id"],
-		key:         key,
-		Path:        rMap["path"],
-		TargetTime:  targetTime,
-		Period:      period,
-		EncodedData: rMap["encodedData"],
+		ID:            rMap["id"],
+		key:           key,
+		Path:          rMap["path"],
+		TargetTime:    targetTime,
+		Period:        period,
+		Cron:          rMap["cron"],
+		MisfirePolicy: rMap["misfirePolicy"],
+		MaxCount:      maxCount,
+		EndTime:       endTime,
+		FireCount:     fireCount,
+		EncodedData:   rMap["encodedData"],
+		schedule:      schedule,
 	}
 	return r, nil
 }
@@ -130,6 +217,34 @@ func (rq *reminderQueue) parse(actor Actor, id, key, payload string) (binding, m
 		}
 		r.Period = period
 	}
+	if data.Cron != "" {
+		if data.Period != "" {
+			return nil, nil, errors.New("cron and period are mutually exclusive")
+		}
+		schedule, err := parseCron(data.Cron)
+		if err != nil {
+			return nil, nil, err
+		}
+		r.Cron = data.Cron
+		r.schedule = schedule
+		if r.TargetTime.IsZero() {
+			r.TargetTime = schedule.next(time.Now())
+			if r.TargetTime.IsZero() {
+				return nil, nil, fmt.Errorf("cron expression %q has no activation time", data.Cron)
+			}
+		}
+	}
+	switch data.MisfirePolicy {
+	case "", misfireFireOnce, misfireFireAll, misfireSkip:
+		r.MisfirePolicy = data.MisfirePolicy
+	default:
+		return nil, nil, fmt.Errorf("invalid misfire policy %q", data.MisfirePolicy)
+	}
+	if data.MaxCount < 0 {
+		return nil, nil, fmt.Errorf("invalid max count %d", data.MaxCount)
+	}
+	r.MaxCount = data.MaxCount
+	r.EndTime = data.EndTime
 	if data.Data != nil {
 		buf, err := json.Marshal(data.Data)
 		if err != nil {
@@ -157,35 +272,109 @@ func migrateReminders(ctx context.Context, actor Actor) {
 }
 */
 
+// nextTargetTime returns the next target time of a periodic reminder that was due at r.TargetTime
+// or the zero time if the reminder should not fire again
+//
+// With the fireAll misfire policy, the next target time is computed from the
+// previous target time so that every missed occurrence is eventually fired,
+// one occurrence per reminder per processing round.
+// Otherwise it is computed from the current fire time.
+func nextTargetTime(r Reminder, fireTime time.Time) time.Time {
+	after := fireTime
+	if r.MisfirePolicy == misfireFireAll || r.TargetTime.After(after) {
+		after = r.TargetTime
+	}
+	var next time.Time
+	if r.Period > 0 {
+		next = after.Add(r.Period)
+	} else if r.schedule != nil {
+		next = r.schedule.next(after)
+	}
+	if r.EndTime != nil && next.After(*r.EndTime) {
+		return time.Time{}
+	}
+	return next
+}
+
+// skipped returns true if the occurrence of a reminder due at r.TargetTime is missed and dropped at fireTime
+// only periodic reminders drop missed occurrences, late one-shot reminders fire once
+func skipped(r Reminder, fireTime time.Time) bool {
+	periodic := r.Period > 0 || r.schedule != nil
+	return periodic && r.MisfirePolicy == misfireSkip && fireTime.After(r.TargetTime.Add(config.ActorReminderAcceptableDelay))
+}
+
 // processReminders causes all reminders with a targetTime before fireTime to be scheduled for execution.
+// A reminder fires at most once per call, missed occurrences are caught up in later rounds.
 func processReminders(ctx context.Context, fireTime time.Time) {
 	arMutex.Lock()
 
+	deferred := []Reminder{} // reminders with missed occurrences left for the next round
+
 	for {
 		r, valid := activeReminders.nextReminderBefore(fireTime)
 		if !valid {
 			break
 		}
+
+		if r.EndTime != nil && r.TargetTime.After(*r.EndTime) {
+			logger.Debug("ProcessReminders: deleting %v to %v[%v]%v (endTime %v)", r.ID, r.Actor.Type, r.Actor.ID, r.Path, *r.EndTime)
+			store.Del(r.key)
+			continue
+		}
+
+		if skipped(r, fireTime) {
+			logger.Info("ProcessReminders: skipping %v to %v[%v]%v missed by %v", r.ID, r.Actor.Type, r.Actor.ID, r.Path, fireTime.Sub(r.TargetTime))
+			if next := nextTargetTime(r, fireTime); !next.IsZero() {
+				r.TargetTime = next
+				activeReminders.add(ctx, r)
+				persistTargetTime(r.key, r.TargetTime, r.FireCount)
+			} else {
+				store.Del(r.key)
+			}
+			continue
+		}
+
 		if fireTime.After(r.TargetTime.Add(config.ActorReminderAcceptableDelay)) {
 			logger.Warning("ProcessReminders: LATE by %v in firing %v to %v[%v]%v", fireTime.Sub(r.TargetTime), r.ID, r.Actor.Type, r.Actor.ID, r.Path)
 		}
 
+		if !r.TargetTime.IsZero() {
+			reminderLateness.Observe(fireTime.Sub(r.TargetTime).Seconds())
+		}
 		logger.Debug("ProcessReminders: firing %v to %v[%v]%v (targetTime %v)", r.ID, r.Actor.Type, r.Actor.ID, r.Path, r.TargetTime)
-		if err := TellActor(ctx, r.Actor, r.Path, r.EncodedData, false); err != nil {
+		tctx, span := tracing.Start(ctx, "reminder "+r.ID, tracing.KindProducer)
+		span.SetAttribute("kar.actor.type", r.Actor.Type)
+		span.SetAttribute("kar.actor.id", r.Actor.ID)
+		span.SetAttribute("kar.path", r.Path)
+		err := TellActor(tctx, r.Actor, r.Path, r.EncodedData, false)
+		span.SetError(err)
+		span.End()
+		if err != nil {
 			logger.Debug("ProcessReminders: firing %v raised error %v", r, err)
 			logger.Debug("ProcessReminders: ending this round; putting reminder back in queue to retry in next round")
 			activeReminders.add(ctx, r)
 			break
 		}
+		r.FireCount++
 
-		if r.Period > 0 {
-			r.TargetTime = fireTime.Add(r.Period)
-			activeReminders.add(ctx, r)
-			persistTargetTime(r.key, r.TargetTime)
+		if r.MaxCount > 0 && r.FireCount >= r.MaxCount {
+			store.Del(r.key)
+		} else if next := nextTargetTime(r, fireTime); !next.IsZero() {
+			r.TargetTime = next
+			if next.Before(fireTime) {
+				deferred = append(deferred, r)
+			} else {
+				activeReminders.add(ctx, r)
+			}
+			persistTargetTime(r.key, r.TargetTime, r.FireCount)
 		} else {
 			store.Del(r.key)
 		}
 	}
 
+	for _, r := range deferred {
+		activeReminders.add(ctx, r)
+	}
+
 	arMutex.Unlock()
 }
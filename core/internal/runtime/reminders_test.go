@@ -0,0 +1,55 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package runtime
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+)
+
+func TestSkipped(t *testing.T) {
+	delay0 := config.ActorReminderAcceptableDelay
+	t.Cleanup(func() { config.ActorReminderAcceptableDelay = delay0 })
+	config.ActorReminderAcceptableDelay = 3 * time.Second
+
+	schedule, err := parseCron("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	late := target.Add(time.Minute)
+	onTime := target.Add(time.Second)
+	for _, tc := range []struct {
+		name     string
+		r        Reminder
+		fireTime time.Time
+		want     bool
+	}{
+		{"late one-shot", Reminder{TargetTime: target, MisfirePolicy: misfireSkip}, late, false},
+		{"late periodic", Reminder{TargetTime: target, Period: time.Second, MisfirePolicy: misfireSkip}, late, true},
+		{"late cron", Reminder{TargetTime: target, schedule: schedule, MisfirePolicy: misfireSkip}, late, true},
+		{"on time periodic", Reminder{TargetTime: target, Period: time.Second, MisfirePolicy: misfireSkip}, onTime, false},
+		{"late periodic fireOnce", Reminder{TargetTime: target, Period: time.Second}, late, false},
+		{"late periodic fireAll", Reminder{TargetTime: target, Period: time.Second, MisfirePolicy: misfireFireAll}, late, false},
+	} {
+		if got := skipped(tc.r, tc.fireTime); got != tc.want {
+			t.Errorf("%s: skipped = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -106,6 +106,111 @@ func invokeServiceEndpoint(ctx context.Context, args []string) (exitCode int) {
 	return
 }
 
+// migrateActor migrates an actor instance to a sidecar
+func migrateActor(ctx context.Context, args []string) (exitCode int) {
+	actor := Actor{Type: args[0], ID: args[1]}
+	reply, err := Migrate(ctx, actor, args[2])
+	if err != nil {
+		logger.Error("error migrating the actor: %v", err)
+		exitCode = 1
+		return
+	}
+	if reply.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "[STDERR] HTTP status: %v\n", reply.StatusCode)
+		fmt.Fprintf(os.Stderr, "[STDERR] %v\n", reply.Payload)
+		exitCode = 1
+	} else {
+		fmt.Printf("Actor %v[%v] migrated to sidecar %v\n", actor.Type, actor.ID, args[2])
+	}
+	return
+}
+
+// deadLetters lists, inspects, or re-drives dead letters
+func deadLetters(ctx context.Context, args []string) (exitCode int) {
+	if len(args) == 0 || args[0] == "list" {
+		found, err := listDeadLetters()
+		var str string
+		if err == nil {
+			str, err = formatDeadLetters(found, config.GetOutputStyle)
+		}
+		if err != nil {
+			logger.Error("error listing dead letters: %v", err)
+			exitCode = 1
+			return
+		}
+		if config.GetOutputStyle != "json" {
+			str = fmt.Sprintf("Listing %v dead letter(s):\n", len(found)) + str
+		}
+		fmt.Print(str)
+		return
+	}
+	if args[0] == "inspect" {
+		d, err := getDeadLetter(args[1])
+		var bytes []byte
+		if err == nil {
+			bytes, err = json.MarshalIndent(d, "", "  ")
+		}
+		if err != nil {
+			logger.Error("error getting dead letter %v: %v", args[1], err)
+			exitCode = 1
+			return
+		}
+		fmt.Println(string(bytes))
+		return
+	}
+	for _, id := range args[1:] {
+		if err := redriveDeadLetter(ctx, id); err != nil {
+			logger.Error("error re-driving dead letter %v: %v", id, err)
+			exitCode = 1
+		} else {
+			fmt.Printf("Dead letter %v re-driven\n", id)
+		}
+	}
+	return
+}
+
+// exportState exports the application state to the file given as argument or to standard output
+func exportState(ctx context.Context, args []string) (exitCode int) {
+	w := os.Stdout
+	if len(args) > 0 {
+		f, err := os.Create(args[0])
+		if err != nil {
+			logger.Error("error creating export file: %v", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	count, err := exportApp(w, config.ExportActorTypes)
+	if err != nil {
+		logger.Error("error exporting application state: %v", err)
+		return 1
+	}
+	logger.Info("exported %v records", count)
+	return
+}
+
+// importState imports the application state from the file given as argument or from standard input
+func importState(ctx context.Context, args []string) (exitCode int) {
+	r := os.Stdin
+	if len(args) > 0 {
+		f, err := os.Open(args[0])
+		if err != nil {
+			logger.Error("error opening export file: %v", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	count, err := importApp(r, config.ExportActorTypes, config.ImportPartitions)
+	if err != nil {
+		logger.Error("error importing application state after %v records: %v", count, err)
+		return 1
+	}
+	logger.Info("imported %v records", count)
+	return
+}
+
 func getInformation(ctx context.Context, args []string) (exitCode int) {
 	option := strings.ToLower(config.GetSystemComponent)
 	var str string
@@ -149,6 +254,23 @@ func getInformation(ctx context.Context, args []string) (exitCode int) {
 				str = prefix + str
 			}
 		}
+	case "mailbox", "mailboxes":
+		var mailboxes map[string]map[string]int
+		if mailboxes, err = getAllMailboxes(ctx, config.GetActorType); err == nil {
+			str, err = formatMailboxMap(mailboxes, config.GetOutputStyle)
+			if err == nil && config.GetOutputStyle != "json" {
+				str = "Listing mailbox lengths of memory-resident actor instances:\n" + str
+			}
+		}
+	case "reminder", "reminders", "subscription", "subscriptions":
+		kind := strings.TrimSuffix(option, "s") + "s"
+		var found []binding
+		if found, err = listBindings(kind, config.GetActorType, config.GetActorInstanceID); err == nil {
+			str, err = formatBindings(found, config.GetOutputStyle)
+			if err == nil && config.GetOutputStyle != "json" {
+				str = fmt.Sprintf("Listing %v %v:\n", len(found), kind) + str
+			}
+		}
 	default:
 		logger.Error("invalid argument <%v> to call Inform", option)
 		exitCode = 1
@@ -160,6 +160,7 @@ func dummy3() {}
  *******************************************************************/
 
 // swagger:parameters idActorCall
+// swagger:parameters idActorMigrate
 // swagger:parameters idActorReminderGet
 // swagger:parameters idActorReminderGetAll
 // swagger:parameters idActorReminderSchedule
@@ -202,6 +203,27 @@ type actorTypeOnlyParam struct {
 	ActorType string `json:"actorType"`
 }
 
+// swagger:parameters idSystemDeadLetterGet
+// swagger:parameters idSystemDeadLetterRedrive
+type deadLetterParam struct {
+	// The dead letter id
+	// in:path
+	DeadLetterID string `json:"deadLetterId"`
+}
+
+// swagger:parameters idSystemReminders
+// swagger:parameters idSystemSubscriptions
+type bindingFilterParam struct {
+	// Restrict the result to an actor type
+	// in:query
+	// required:false
+	ActorType string `json:"actorType"`
+	// Restrict the result to an actor instance id
+	// in:query
+	// required:false
+	ActorID string `json:"actorId"`
+}
+
 // swagger:parameters idActorStateDelete
 // swagger:parameters idActorStateExists
 // swagger:parameters idActorStateGet
@@ -257,6 +279,88 @@ type asyncParam struct {
 	Pragma string `json:"Pragma"`
 }
 
+// swagger:parameters idActorCall
+// swagger:parameters idServiceDelete
+// swagger:parameters idServiceGet
+// swagger:parameters idServiceHead
+// swagger:parameters idServiceOptions
+// swagger:parameters idServicePatch
+// swagger:parameters idServicePost
+// swagger:parameters idServicePut
+type deadlineParam struct {
+	// Optionally specify how long to wait for the result of the call as a duration or a number of seconds.
+	// The callee skips the call if the deadline has passed.
+	// in:header
+	// required:false
+	// Example: 5s
+	RequestTimeout string `json:"Request-Timeout"`
+	// Optionally specify the deadline of the call as an RFC 3339 timestamp.
+	// in:header
+	// required:false
+	// Example: 2021-06-01T12:00:00Z
+	RequestDeadline string `json:"Request-Deadline"`
+}
+
+// swagger:parameters idActorCall
+// swagger:parameters idServiceDelete
+// swagger:parameters idServiceGet
+// swagger:parameters idServiceHead
+// swagger:parameters idServiceOptions
+// swagger:parameters idServicePatch
+// swagger:parameters idServicePost
+// swagger:parameters idServicePut
+type idempotencyParam struct {
+	// Optionally specify a key identifying the request.
+	// The reply to a request with the same key for the same actor instance or service
+	// is returned without invoking the target again during the retention window.
+	// Server errors are not retained.
+	// in:header
+	// required:false
+	IdempotencyKey string `json:"Idempotency-Key"`
+}
+
+// swagger:parameters idActorStateDelete
+// swagger:parameters idActorStateDeleteAll
+// swagger:parameters idActorStateSet
+// swagger:parameters idActorStateSubkeyDelete
+// swagger:parameters idActorStateSubkeySet
+// swagger:parameters idActorStateSubmapOps
+// swagger:parameters idActorStateUpdate
+type ifMatchParam struct {
+	// Optionally specify the entity tags of the actor state versions the update applies to.
+	// in:header
+	// required:false
+	// Example: "42"
+	IfMatch string `json:"If-Match"`
+}
+
+// swagger:parameters idActorStateSet
+// swagger:parameters idActorStateSubkeySet
+// swagger:parameters idActorStateUpdate
+type ttlParam struct {
+	// Optionally specify the time to live of the updated state as a duration, for instance 24h or 30d.
+	// in:query
+	// required:false
+	TTL string `json:"ttl"`
+}
+
+// swagger:parameters idAwaitCancel
+type requestParam struct {
+	// The request id of the promise
+	// in:path
+	// swagger:strfmt uuid
+	Request string `json:"request"`
+}
+
+// swagger:parameters idAwait
+type awaitTimeoutParam struct {
+	// Optionally specify how long to wait for the response as a duration.
+	// in:query
+	// required:false
+	// Example: 30s
+	Timeout string `json:"timeout"`
+}
+
 // swagger:parameters idEventPublish
 type topicParam struct {
 	// The topic name
@@ -296,6 +400,28 @@ type sessionParam struct {
 	Session string `json:"session"`
 }
 
+// swagger:parameters idActorCall
+type priorityParam struct {
+	// Optionally specify the priority of the actor method invocation.
+	// Invocations waiting for the actor instance are dispatched
+	// by decreasing priority then in arrival order.
+	// in:query
+	// required:false
+	// default: 0
+	Priority int `json:"priority"`
+}
+
+// swagger:parameters idActorCall
+// swagger:parameters idImplActorPost
+type callChainParam struct {
+	// The actor invocations of the call chain as a JSON array.
+	// The sidecar passes the call chain to actor methods, which should
+	// include it in the actor calls they make.
+	// in:header
+	// required:false
+	CallChain string `json:"Kar-Call-Chain"`
+}
+
 // swagger:parameters idImplActorPost
 type sessionPathParam struct {
 	// The session to use for the actor method invocation.
@@ -364,6 +490,13 @@ type awaitParameter struct {
 	Body string
 }
 
+// swagger:parameters idActorMigrate
+type migrateParameter struct {
+	// The id of the target sidecar
+	// in:body
+	Body string
+}
+
 // swagger:parameters idServiceOptions
 // swagger:parameters idServicePatch
 // swagger:parameters idServicePost
@@ -539,6 +672,27 @@ type response200StateSetMultipleResult struct {
 	NumberCreated int
 }
 
+// swagger:response response200DeadLettersResult
+type response200DeadLettersResult struct {
+	// An array containing all dead letters
+	Body []deadLetter
+}
+
+// swagger:response response200DeadLetterResult
+type response200DeadLetterResult struct {
+	// The dead letter
+	Body deadLetter
+}
+
+// swagger:response response200BreakersResult
+type response200BreakersResult struct {
+	// The circuit breakers by actor type or path prefix and the bulkhead
+	Body struct {
+		Breakers map[string]breaker `json:"breakers"`
+		Bulkhead bulkhead           `json:"bulkhead"`
+	}
+}
+
 // swagger:response response200SystemInfoResult
 type response200SystemInfoResult struct {
 	// Returns information about a system component
@@ -578,6 +732,40 @@ type error404 struct {
 	Body string `json:"body"`
 }
 
+// Response indicating that the response did not arrive in time
+// swagger:response response408
+type error408 struct {
+	// A message describing the error
+	// Example: Request Timeout
+	Body string `json:"body"`
+}
+
+// Response indicating that the call would wait on an actor held by its own call chain
+// swagger:response response409
+type error409 struct {
+	// A message describing the cycle
+	// Example: deadlock detected, aborting command call with path /m in session 5f0e: A[a] -> B[b] -> A[a]
+	Body string `json:"body"`
+}
+
+// Response indicating that the actor state does not match the If-Match header
+// swagger:response response412
+type error412 struct {
+	// The entity tag of the current actor state
+	ETag string `json:"ETag"`
+	// A message describing the error
+	// Example: Precondition Failed
+	Body string `json:"body"`
+}
+
+// Response indicating that the call has been cancelled
+// swagger:response response499
+type error499 struct {
+	// A message describing the error
+	// Example: Request Cancelled
+	Body string `json:"body"`
+}
+
 // A message describing the error
 // swagger:response response500
 type error500 struct {
@@ -594,6 +782,14 @@ type error503 struct {
 	Body string `json:"body"`
 }
 
+// Response indicating that the deadline of a call has passed
+// swagger:response response504
+type error504 struct {
+	// A message describing the error
+	// Example: Gateway Timeout
+	Body string `json:"body"`
+}
+
 // An error response returned by the invoked endpoint
 // swagger:response responseGenericEndpointError
 type responseGenericEndpointError struct {
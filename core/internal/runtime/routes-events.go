@@ -26,7 +26,6 @@ import (
 	"io/ioutil"
 	"net/http"
 
-	"github.com/Shopify/sarama"
 	"github.com/IBM/kar.git/core/internal/pubsub"
 	"github.com/julienschmidt/httprouter"
 )
@@ -215,7 +214,7 @@ func routeImplCreateTopic(w http.ResponseWriter, r *http.Request, ps httprouter.
 	params := ReadAll(r)
 	err := pubsub.CreateTopic(topic, params)
 	if err != nil {
-		if e, ok := err.(*sarama.TopicError); ok && e.Err == sarama.ErrTopicAlreadyExists {
+		if err == pubsub.ErrTopicAlreadyExists {
 			w.WriteHeader(http.StatusOK)
 			fmt.Fprint(w, "Already existed")
 		} else {
@@ -22,18 +22,56 @@ package runtime
  */
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/textproto"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/IBM/kar.git/core/internal/pubsub"
+	"github.com/IBM/kar.git/core/internal/tracing"
 	"github.com/IBM/kar.git/core/pkg/logger"
 	"github.com/julienschmidt/httprouter"
 )
 
+// requestContext returns a context carrying the trace context, idempotency key, priority, and call chain of a request
+func requestContext(r *http.Request) context.Context {
+	ctx := withIdempotencyKey(tracing.Extract(ctx, r.Header.Get("traceparent"), r.Header.Get("tracestate")), r.Header.Get("Idempotency-Key"))
+	return withCallChain(withPriority(ctx, r.URL.Query().Get("priority")), r.Header.Get(callChainHeader))
+}
+
+// requestDeadline returns the deadline of a call specified by the Request-Timeout or Request-Deadline header if any
+// Request-Timeout is a duration or a number of seconds, Request-Deadline is an RFC 3339 timestamp
+func requestDeadline(r *http.Request) (time.Time, bool, error) {
+	if s := r.Header.Get("Request-Timeout"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			f, ferr := strconv.ParseFloat(s, 64)
+			if ferr != nil {
+				return time.Time{}, false, fmt.Errorf("invalid Request-Timeout %q: %v", s, err)
+			}
+			d = time.Duration(f * float64(time.Second))
+		}
+		if d <= 0 {
+			return time.Time{}, false, fmt.Errorf("invalid Request-Timeout %q", s)
+		}
+		return time.Now().Add(d), true, nil
+	}
+	if s := r.Header.Get("Request-Deadline"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid Request-Deadline %q: %v", s, err)
+		}
+		return t, true, nil
+	}
+	return time.Time{}, false, nil
+}
+
 func tellHelper(w http.ResponseWriter, r *http.Request, ps httprouter.Params, direct bool) {
+	ctx := requestContext(r)
 	var err error
 	if ps.ByName("service") != "" {
 		var m []byte
@@ -58,6 +96,15 @@ func tellHelper(w http.ResponseWriter, r *http.Request, ps httprouter.Params, di
 }
 
 func callPromise(w http.ResponseWriter, r *http.Request, ps httprouter.Params, direct bool) {
+	ctx := requestContext(r)
+	if deadline, ok, err := requestDeadline(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
 	var request string
 	var err error
 	if ps.ByName("service") != "" {
@@ -71,7 +118,9 @@ func callPromise(w http.ResponseWriter, r *http.Request, ps httprouter.Params, d
 		request, err = CallPromiseActor(ctx, Actor{Type: ps.ByName("type"), ID: ps.ByName("id")}, ps.ByName("path"), ReadAll(r), direct)
 	}
 	if err != nil {
-		if err == ctx.Err() {
+		if err == context.DeadlineExceeded {
+			http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+		} else if err == ctx.Err() {
 			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 		} else {
 			http.Error(w, fmt.Sprintf("failed to send message: %v", err), http.StatusInternalServerError)
@@ -90,7 +139,8 @@ func callPromise(w http.ResponseWriter, r *http.Request, ps httprouter.Params, d
 // ### Await the response to an actor or service call
 //
 // Await blocks until the response to an asynchronous call is received and
-// returns this response.
+// returns this response. Any sidecar of the application can await a promise
+// until the promise is awaited or its time to live expires.
 //
 //     Consumes:
 //     - text/plain
@@ -99,14 +149,34 @@ func callPromise(w http.ResponseWriter, r *http.Request, ps httprouter.Params, d
 //     Schemes: http
 //     Responses:
 //       200: response200CallResult
+//       400: response400
+//       404: response404
+//       408: response408
+//       499: response499
 //       500: response500
 //       503: response503
 //       default: responseGenericEndpointError
 //
 func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	reply, err := AwaitPromise(ctx, ReadAll(r))
+	awaitCtx := ctx
+	if s := r.URL.Query().Get("timeout"); s != "" {
+		timeout, err := time.ParseDuration(s)
+		if err != nil || timeout <= 0 {
+			http.Error(w, fmt.Sprintf("invalid timeout %q", s), http.StatusBadRequest)
+			return
+		}
+		var cancel context.CancelFunc
+		awaitCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	request := ReadAll(r)
+	reply, err := AwaitPromise(awaitCtx, request)
 	if err != nil {
-		if err == ctx.Err() {
+		if err == errUnknownRequest {
+			http.Error(w, fmt.Sprintf("unexpected request %s", request), http.StatusNotFound)
+		} else if err == context.DeadlineExceeded && ctx.Err() == nil {
+			http.Error(w, fmt.Sprintf("timeout awaiting request %s", request), http.StatusRequestTimeout)
+		} else if err == awaitCtx.Err() {
 			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 		} else {
 			http.Error(w, fmt.Sprintf("failed to await promise: %v", err), http.StatusInternalServerError)
@@ -118,6 +188,40 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 	}
 }
 
+// swagger:route DELETE /v1/await/{request} callbacks idAwaitCancel
+//
+// await
+//
+// ### Cancel an actor or service call
+//
+// Cancel completes the promise of an asynchronous call with status 499
+// and aborts the invocation of the target endpoint if in progress.
+// Cancelling a completed call has no effect.
+//
+//     Produces:
+//     - text/plain
+//     Schemes: http
+//     Responses:
+//       200: response200
+//       404: response404
+//       500: response500
+//       503: response503
+//
+func routeImplCancelPromise(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := CancelPromise(ctx, ps.ByName("request"))
+	if err != nil {
+		if err == errUnknownRequest {
+			http.Error(w, fmt.Sprintf("unexpected request %s", ps.ByName("request")), http.StatusNotFound)
+		} else if err == ctx.Err() {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, fmt.Sprintf("failed to cancel promise: %v", err), http.StatusInternalServerError)
+		}
+	} else {
+		fmt.Fprint(w, "OK")
+	}
+}
+
 // swagger:route POST /v1/service/{service}/call/{path} services idServicePost
 //
 // call
@@ -133,9 +237,11 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 //     Responses:
 //       200: response200CallResult
 //       202: response202
+//       400: response400
 //       404: response404
 //       500: response500
 //       503: response503
+//       504: response504
 //       default: responseGenericEndpointError
 //
 
@@ -153,9 +259,11 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 //     Responses:
 //       200: response200CallResult
 //       202: response202
+//       400: response400
 //       404: response404
 //       500: response500
 //       503: response503
+//       504: response504
 //       default: responseGenericEndpointError
 //
 
@@ -173,9 +281,11 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 //     Responses:
 //       200: response200CallResult
 //       202: response202
+//       400: response400
 //       404: response404
 //       500: response500
 //       503: response503
+//       504: response504
 //       default: responseGenericEndpointError
 //
 
@@ -194,9 +304,11 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 //     Responses:
 //       201: response201
 //       204: response204
+//       400: response400
 //       404: response404
 //       500: response500
 //       503: response503
+//       504: response504
 //       default: responseGenericEndpointError
 //
 
@@ -215,9 +327,11 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 //     Responses:
 //       200: response200CallResult
 //       202: response202
+//       400: response400
 //       404: response404
 //       500: response500
 //       503: response503
+//       504: response504
 //       default: responseGenericEndpointError
 //
 
@@ -235,9 +349,11 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 //     Responses:
 //       200: response200CallResult
 //       202: response202
+//       400: response400
 //       404: response404
 //       500: response500
 //       503: response503
+//       504: response504
 //       default: responseGenericEndpointError
 //
 
@@ -256,9 +372,11 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 //     Responses:
 //       200: response200CallResult
 //       202: response202
+//       400: response400
 //       404: response404
 //       500: response500
 //       503: response503
+//       504: response504
 //       default: responseGenericEndpointError
 //
 
@@ -275,6 +393,10 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 // The result of the call is the result of invoking the target actor method
 // unless the `async` or `promise` pragma header is specified.  If the actor
 // method returns `void` or `undefined`, then a 204 - No Content reponse is returned.
+// Calls waiting for the actor instance are dispatched in order of `priority`
+// then arrival. A call made from an actor method should carry the `Kar-Call-Chain`
+// header received by the method; a call that would wait on an actor held
+// by its own call chain fails with a 409 describing the cycle.
 //
 //     Consumes:
 //     - application/kar+json
@@ -285,11 +407,20 @@ func routeImplAwaitPromise(w http.ResponseWriter, r *http.Request, ps httprouter
 //       200: response200CallActorResult
 //       202: response202
 //       204: response204ActorNoContentResult
+//       400: response400
 //       404: response404
+//       409: response409
 //       500: response500
 //       503: response503
+//       504: response504
 //
 func routeImplCall(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if priority := r.URL.Query().Get("priority"); priority != "" && ps.ByName("service") == "" {
+		if _, err := strconv.Atoi(priority); err != nil {
+			http.Error(w, fmt.Sprintf("invalid priority %q", priority), http.StatusBadRequest)
+			return
+		}
+	}
 	direct := false
 	for _, pragma := range r.Header[textproto.CanonicalMIMEHeaderKey("Pragma")] {
 		if strings.ToLower(pragma) == "http" {
@@ -306,6 +437,15 @@ func routeImplCall(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 			return
 		}
 	}
+	ctx := requestContext(r)
+	if deadline, ok, err := requestDeadline(r); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
 	var reply *Reply
 	var err error
 	if ps.ByName("service") != "" {
@@ -320,7 +460,9 @@ func routeImplCall(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		reply, err = CallActor(ctx, Actor{Type: ps.ByName("type"), ID: ps.ByName("id")}, ps.ByName("path"), ReadAll(r), session, direct)
 	}
 	if err != nil {
-		if err == ctx.Err() {
+		if err == context.DeadlineExceeded {
+			http.Error(w, "Gateway Timeout", http.StatusGatewayTimeout)
+		} else if err == ctx.Err() {
 			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 		} else if err == pubsub.ErrRouteToActorTimeout {
 			http.Error(w, fmt.Sprintf("timeout waiting for Actor type %v to be defined", ps.ByName("type")), http.StatusRequestTimeout)
@@ -368,3 +510,42 @@ func routeImplDelActor(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		fmt.Fprint(w, "OK")
 	}
 }
+
+// swagger:route POST /v1/actor/{actorType}/{actorId}/migrate actors idActorMigrate
+//
+// migrate
+//
+// ### Migrate an actor instance to another sidecar
+//
+// The actor instance indicated by `actorType` and `actorId` will be deactivated
+// and its placement will be updated to the sidecar whose id is provided as the request body.
+// The reminders and subscriptions of the actor instance are moved to the new sidecar.
+// The operation will not return until the migration is complete.
+// Instances of stateless worker actor types are not placed and cannot be migrated.
+//
+//     Consumes:
+//     - text/plain
+//     Produces:
+//     - text/plain
+//     Schemes: http
+//     Responses:
+//       200: response200
+//       400: response400
+//       404: response404
+//       500: response500
+//       503: response503
+//
+func routeImplMigrate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	reply, err := Migrate(ctx, Actor{Type: ps.ByName("type"), ID: ps.ByName("id")}, strings.TrimSpace(ReadAll(r)))
+	if err != nil {
+		if err == ctx.Err() {
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		} else {
+			http.Error(w, fmt.Sprintf("failed to send message: %v", err), http.StatusInternalServerError)
+		}
+	} else {
+		w.Header().Add("Content-Type", reply.ContentType)
+		w.WriteHeader(reply.StatusCode)
+		fmt.Fprint(w, reply.Payload)
+	}
+}
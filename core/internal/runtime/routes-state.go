@@ -26,6 +26,7 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/IBM/kar.git/core/internal/config"
 	"github.com/IBM/kar.git/core/internal/store"
@@ -48,6 +49,91 @@ func nestedEntryKeyPrefix(key string) string {
 	return key + config.Separator
 }
 
+// stateVersionField is the hash field holding the version of an actor's state
+// entry keys always contain a separator, hence cannot collide with this field
+const stateVersionField = "version"
+
+// stateVersion returns the current version of an actor's state
+func stateVersion(stateKey string) (string, error) {
+	version, err := store.HGet(stateKey, stateVersionField)
+	if err == store.ErrNil {
+		return "0", nil
+	}
+	return version, err
+}
+
+// setETag sets the ETag header of the response to the given state version
+func setETag(w http.ResponseWriter, version string) {
+	w.Header().Set("ETag", `"`+version+`"`)
+}
+
+// getETag reads the version of an actor's state and sets the ETag header
+// the version must be read before the state so a concurrent update cannot be missed
+func getETag(w http.ResponseWriter, stateKey string) error {
+	version, err := stateVersion(stateKey)
+	if err != nil {
+		return err
+	}
+	setETag(w, version)
+	return nil
+}
+
+// ifMatch returns the state versions accepted by the If-Match header of the request
+// an empty list accepts any version, ok is false if no version is acceptable
+func ifMatch(r *http.Request) (versions []string, ok bool) {
+	header := strings.Join(r.Header.Values("If-Match"), ",")
+	if strings.TrimSpace(header) == "" {
+		return nil, true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return nil, true
+		}
+		if len(tag) >= 2 && tag[0] == '"' && tag[len(tag)-1] == '"' { // weak tags never match
+			versions = append(versions, tag[1:len(tag)-1])
+		}
+	}
+	return versions, len(versions) > 0
+}
+
+// ttl returns the time to live specified by the ttl query parameter of the request if any
+// ttl reports an invalid parameter to the client and returns false
+func ttl(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	s := r.URL.Query().Get("ttl")
+	if s == "" {
+		return 0, true
+	}
+	d, err := config.ParseDuration(s)
+	if err != nil || d <= 0 {
+		http.Error(w, fmt.Sprintf("Invalid ttl %v", s), http.StatusBadRequest)
+		return 0, false
+	}
+	return d, true
+}
+
+// updateState atomically applies an update to an actor's state if the If-Match header of the request matches
+// updateState reports errors to the client and returns false if the update was not applied
+func updateState(w http.ResponseWriter, r *http.Request, stateKey string, update store.HashUpdate) (store.HashUpdateResult, bool) {
+	expected, ok := ifMatch(r)
+	if !ok {
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return store.HashUpdateResult{}, false
+	}
+	update.Expected = expected
+	result, err := store.HUpdate(stateKey, stateVersionField, update)
+	if err == store.ErrConflict {
+		setETag(w, result.Version)
+		http.Error(w, "Precondition Failed", http.StatusPreconditionFailed)
+		return result, false
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("state update failed: %v", err), http.StatusInternalServerError)
+		return result, false
+	}
+	setETag(w, result.Version)
+	return result, true
+}
+
 // swagger:route HEAD /v1/actor/{actorType}/{actorId}/state/{key} state idActorStateExists
 //
 // state/key
@@ -57,6 +143,8 @@ func nestedEntryKeyPrefix(key string) string {
 // Check to see if the state of the actor instance indicated by `actorType` and `actorId`
 // contains an entry for `key`.
 //
+// The response includes the entity tag of the actor state in the `ETag` header.
+//
 //     Consumes:
 //     - application/json
 //     Schemes: http
@@ -75,6 +163,8 @@ func nestedEntryKeyPrefix(key string) string {
 // Check to see if the state of the actor instance indicated by `actorType` and `actorId`
 // contains an entry for `key`/`subkey`.
 //
+// The response includes the entity tag of the actor state in the `ETag` header.
+//
 //     Consumes:
 //     - application/json
 //     Schemes: http
@@ -91,7 +181,10 @@ func routeImplContainsKey(w http.ResponseWriter, r *http.Request, ps httprouter.
 		mangledEntryKey = flatEntryKey(ps.ByName("key"))
 	}
 
-	if reply, err := store.HExists(stateKey(ps.ByName("type"), ps.ByName("id")), mangledEntryKey); err != nil {
+	stateKey := stateKey(ps.ByName("type"), ps.ByName("id"))
+	if err := getETag(w, stateKey); err != nil {
+		http.Error(w, fmt.Sprintf("HGET failed: %v", err), http.StatusInternalServerError)
+	} else if reply, err := store.HExists(stateKey, mangledEntryKey); err != nil {
 		http.Error(w, fmt.Sprintf("HExists failed: %v", err), http.StatusInternalServerError)
 	} else {
 		if reply == 0 {
@@ -112,6 +205,13 @@ func routeImplContainsKey(w http.ResponseWriter, r *http.Request, ps httprouter.
 // will be updated by setting `key` to contain the JSON request body.
 // The operation will not return until the state has been updated.
 //
+// If the `ttl` query parameter is specified, for instance `24h` or `30d`, the entry
+// is deleted by the store after this time unless it is updated again.
+//
+// If the `If-Match` header is specified, the update is only performed if the
+// entity tag of the actor state matches, otherwise a `412` response is returned.
+// The response includes the entity tag of the resulting actor state in the `ETag` header.
+//
 //     Consumes:
 //     - application/json
 //     Produces:
@@ -120,6 +220,8 @@ func routeImplContainsKey(w http.ResponseWriter, r *http.Request, ps httprouter.
 //     Responses:
 //       201: response201
 //       204: response204
+//       400: response400
+//       412: response412
 //       500: response500
 //
 
@@ -134,6 +236,13 @@ func routeImplContainsKey(w http.ResponseWriter, r *http.Request, ps httprouter.
 // The operation will not return until the state has been updated.
 // The result of the operation is `1` if a new entry was created and `0` if an existing entry was updated.
 //
+// If the `ttl` query parameter is specified, for instance `24h` or `30d`, the entry
+// is deleted by the store after this time unless it is updated again.
+//
+// If the `If-Match` header is specified, the update is only performed if the
+// entity tag of the actor state matches, otherwise a `412` response is returned.
+// The response includes the entity tag of the resulting actor state in the `ETag` header.
+//
 //     Consumes:
 //     - application/json
 //     Produces:
@@ -142,6 +251,8 @@ func routeImplContainsKey(w http.ResponseWriter, r *http.Request, ps httprouter.
 //     Responses:
 //       201: response201
 //       204: response204
+//       400: response400
+//       412: response412
 //       500: response500
 //
 func routeImplSet(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -152,9 +263,17 @@ func routeImplSet(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		mangledEntryKey = flatEntryKey(ps.ByName("key"))
 	}
 
-	if reply, err := store.HSet(stateKey(ps.ByName("type"), ps.ByName("id")), mangledEntryKey, ReadAll(r)); err != nil {
-		http.Error(w, fmt.Sprintf("HSET failed: %v", err), http.StatusInternalServerError)
-	} else if reply == 1 {
+	ttl, ok := ttl(w, r)
+	if !ok {
+		return
+	}
+	stateKey := stateKey(ps.ByName("type"), ps.ByName("id"))
+	update := store.HashUpdate{Updates: map[string]string{mangledEntryKey: ReadAll(r)}, FieldTTL: ttl}
+	reply, ok := updateState(w, r, stateKey, update)
+	if !ok {
+		return
+	}
+	if reply.Added == 1 {
 		if subkey := ps.ByName("subkey"); subkey != "" {
 			w.Header().Set("Location", fmt.Sprintf("/kar/v1/actor/%v/%v/state/%v/%v", ps.ByName("type"), ps.ByName("id"), ps.ByName("key"), subkey))
 		} else {
@@ -179,6 +298,8 @@ func routeImplSet(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 // unless the boolean query parameter `nilOnAbsent` is set to `true`,
 // in which case a `200` reponse with a `nil` response body will be returned.
 //
+// The response includes the entity tag of the actor state in the `ETag` header.
+//
 //     Produces:
 //     - application/json
 //     Schemes: http
@@ -200,6 +321,8 @@ func routeImplSet(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 // unless the boolean query parameter `nilOnAbsent` is set to `true`,
 // in which case a `200` reponse with a `nil` response body will be returned.
 //
+// The response includes the entity tag of the actor state in the `ETag` header.
+//
 //     Produces:
 //     - application/json
 //     Schemes: http
@@ -216,7 +339,10 @@ func routeImplGet(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		mangledEntryKey = flatEntryKey(ps.ByName("key"))
 	}
 
-	if reply, err := store.HGet(stateKey(ps.ByName("type"), ps.ByName("id")), mangledEntryKey); err == store.ErrNil {
+	stateKey := stateKey(ps.ByName("type"), ps.ByName("id"))
+	if err := getETag(w, stateKey); err != nil {
+		http.Error(w, fmt.Sprintf("HGET failed: %v", err), http.StatusInternalServerError)
+	} else if reply, err := store.HGet(stateKey, mangledEntryKey); err == store.ErrNil {
 		if noa := r.FormValue("nilOnAbsent"); noa == "true" {
 			fmt.Fprint(w, reply)
 		} else {
@@ -250,6 +376,10 @@ func routeImplGet(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 // <li>size: return the number of entries the key actor map</li>
 // </ul>
 //
+// If the `If-Match` header is specified, the update is only performed if the
+// entity tag of the actor state matches, otherwise a `412` response is returned.
+// The response includes the entity tag of the resulting actor state in the `ETag` header.
+//
 //     Consumes:
 //     - application/json
 //     Produces:
@@ -258,6 +388,7 @@ func routeImplGet(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 //     Responses:
 //       200: response200StateSubmapOps
 //       404: response404
+//       412: response412
 //       500: response500
 //
 func routeImplSubmapOps(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -270,6 +401,13 @@ func routeImplSubmapOps(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 	stateKey := stateKey(ps.ByName("type"), ps.ByName("id"))
 	mapName := ps.ByName("key")
 
+	if op.Op != "clear" {
+		if err := getETag(w, stateKey); err != nil {
+			http.Error(w, fmt.Sprintf("submapOps: HGET failed %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	var response interface{}
 	switch op.Op {
 	case "clear":
@@ -282,12 +420,11 @@ func routeImplSubmapOps(w http.ResponseWriter, r *http.Request, ps httprouter.Pa
 			http.Error(w, fmt.Sprintf("submapOps:clear: subMapScan failed: %v", err), http.StatusInternalServerError)
 			return
 		}
-		numCleared, err := store.HDelMultiple(stateKey, mapKeys)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("submapOps: HDEL failed  %v", err), http.StatusInternalServerError)
+		reply, ok := updateState(w, r, stateKey, store.HashUpdate{Removals: mapKeys})
+		if !ok {
 			return
 		}
-		response = numCleared
+		response = reply.Removed
 
 	case "get":
 		m := map[string]interface{}{}
@@ -383,11 +520,16 @@ func subMapScan(stateKey string, mapName string, op func(key string, value strin
 // The result of the operation is `1` if an entry was actually removed and
 // `0` if there was no entry for `key`.
 //
+// If the `If-Match` header is specified, the update is only performed if the
+// entity tag of the actor state matches, otherwise a `412` response is returned.
+// The response includes the entity tag of the resulting actor state in the `ETag` header.
+//
 //     Schemes: http
 //     Produces:
 //     - text/plain
 //     Responses:
 //       200: response200StateDeleteResult
+//       412: response412
 //       500: response500
 //
 
@@ -403,11 +545,16 @@ func subMapScan(stateKey string, mapName string, op func(key string, value strin
 // The result of the operation is `1` if an entry was actually removed and
 // `0` if there was no entry for `key`.
 //
+// If the `If-Match` header is specified, the update is only performed if the
+// entity tag of the actor state matches, otherwise a `412` response is returned.
+// The response includes the entity tag of the resulting actor state in the `ETag` header.
+//
 //     Schemes: http
 //     Produces:
 //     - text/plain
 //     Responses:
 //       200: response200StateDeleteResult
+//       412: response412
 //       500: response500
 //
 func routeImplDel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -417,10 +564,9 @@ func routeImplDel(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 	} else {
 		mangledEntryKey = flatEntryKey(ps.ByName("key"))
 	}
-	if reply, err := store.HDel(stateKey(ps.ByName("type"), ps.ByName("id")), mangledEntryKey); err != nil {
-		http.Error(w, fmt.Sprintf("HDEL failed: %v", err), http.StatusInternalServerError)
-	} else {
-		fmt.Fprint(w, reply)
+	update := store.HashUpdate{Removals: []string{mangledEntryKey}}
+	if reply, ok := updateState(w, r, stateKey(ps.ByName("type"), ps.ByName("id")), update); ok {
+		fmt.Fprint(w, reply.Removed)
 	}
 }
 
@@ -433,6 +579,8 @@ func routeImplDel(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 // The state of the actor instance indicated by `actorType` and `actorId`
 // will be returned as the response body.
 //
+// The response includes the entity tag of the actor state in the `ETag` header.
+//
 //     Produces:
 //     - application/json
 //     Schemes: http
@@ -441,10 +589,11 @@ func routeImplDel(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 //       500: response500
 //
 func routeImplGetAll(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	state, err := actorGetAllState(ps.ByName("type"), ps.ByName("id"))
+	state, version, err := actorGetAllStateVersion(ps.ByName("type"), ps.ByName("id"))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("actorGetAllState failed: %v", err), http.StatusInternalServerError)
 	} else {
+		setETag(w, version)
 		b, _ := json.Marshal(state)
 		w.Header().Add("Content-Type", "application/json")
 		fmt.Fprint(w, string(b))
@@ -452,14 +601,25 @@ func routeImplGetAll(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 }
 
 func actorGetAllState(actorType string, actorID string) (map[string]interface{}, error) {
+	m, _, err := actorGetAllStateVersion(actorType, actorID)
+	return m, err
+}
+
+// actorGetAllStateVersion returns the state of an actor and its version
+func actorGetAllStateVersion(actorType string, actorID string) (map[string]interface{}, string, error) {
 	reply, err := store.HGetAll(stateKey(actorType, actorID))
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	version := "0"
 	// reply has type map[string]string
 	// we unmarshal the values then marshal the map
 	m := map[string]interface{}{}
 	for i, s := range reply {
+		if i == stateVersionField {
+			version = s
+			continue
+		}
 		var v interface{}
 		json.Unmarshal([]byte(s), &v)
 		splitKeys := strings.SplitN(i, config.Separator, 2)
@@ -474,7 +634,7 @@ func actorGetAllState(actorType string, actorID string) (map[string]interface{},
 			(m[key].(map[string]interface{}))[subkey] = v
 		}
 	}
-	return m, nil
+	return m, version, nil
 }
 
 // swagger:route POST /v1/actor/{actorType}/{actorId}/state state idActorStateUpdate
@@ -486,10 +646,17 @@ func actorGetAllState(actorType string, actorID string) (map[string]interface{},
 // The state updates contained in the request body will be performed on the
 // actor instance indicated by `actorType` and `actorId`.
 // All removal operations will be performed first, then all update
-// operations will be performed.
+// operations will be performed, as a single atomic update.
 // The result of the operation will contain the number of state elements
 // removed and updated.
 //
+// If the `ttl` query parameter is specified, for instance `24h` or `30d`, the whole
+// state of the actor is deleted by the store after this time unless a ttl is set again.
+//
+// If the `If-Match` header is specified, the update is only performed if the
+// entity tag of the actor state matches, otherwise a `412` response is returned.
+// The response includes the entity tag of the resulting actor state in the `ETag` header.
+//
 //     Consumes:
 //     - application/json
 //     Produces:
@@ -498,6 +665,8 @@ func actorGetAllState(actorType string, actorID string) (map[string]interface{},
 //     Responses:
 //       200: response200StateUpdate
 //       404: response404
+//       400: response400
+//       412: response412
 //       500: response500
 //
 func routeImplStateUpdate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -543,25 +712,19 @@ func routeImplStateUpdate(w http.ResponseWriter, r *http.Request, ps httprouter.
 		}
 	}
 
-	// Third, apply the removals and then the updates.
-	numCleared := 0
-	numAdded := 0
-	if len(toClear) > 0 {
-		numCleared, err = store.HDelMultiple(stateKey, toClear)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("StateUpate: HDEL failed  %v", err), http.StatusInternalServerError)
-			return
-		}
+	ttl, ok := ttl(w, r)
+	if !ok {
+		return
 	}
-	if len(toUpdate) > 0 {
-		numAdded, err = store.HSetMultiple(stateKey, toUpdate)
-		if err != nil {
-			http.Error(w, fmt.Sprintf("StateUpate: HSET failed  %v", err), http.StatusInternalServerError)
-			return
-		}
+
+	// Third, atomically apply the removals and then the updates,
+	// and set the time to live of the whole state if requested.
+	reply, ok := updateState(w, r, stateKey, store.HashUpdate{Removals: toClear, Updates: toUpdate, TTL: ttl})
+	if !ok {
+		return
 	}
 
-	response = response200StateUpdateOp{Removed: numCleared, Added: numAdded}
+	response = response200StateUpdateOp{Removed: reply.Removed, Added: reply.Added}
 	buf, err := json.Marshal(response)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("StateUpdate: error marshalling response %v", err), http.StatusInternalServerError)
@@ -581,18 +744,24 @@ func routeImplStateUpdate(w http.ResponseWriter, r *http.Request, ps httprouter.
 // The state of the actor instance indicated by `actorType` and `actorId`
 // will be deleted.
 //
+// If the `If-Match` header is specified, the update is only performed if the
+// entity tag of the actor state matches, otherwise a `412` response is returned.
+// The response includes the entity tag of the resulting actor state in the `ETag` header.
+//
 //     Schemes: http
 //     Responses:
 //       200: response200StateDeleteResult
 //       404: response404
+//       412: response412
 //       500: response500
 //
 func routeImplDelAll(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	if reply, err := store.Del(stateKey(ps.ByName("type"), ps.ByName("id"))); err == store.ErrNil {
-		http.Error(w, "Not Found", http.StatusNotFound)
-	} else if err != nil {
-		http.Error(w, fmt.Sprintf("DEL failed: %v", err), http.StatusInternalServerError)
-	} else {
-		fmt.Fprint(w, reply)
+	// the deleted state has version 0 and later versions never reuse earlier ones
+	if reply, ok := updateState(w, r, stateKey(ps.ByName("type"), ps.ByName("id")), store.HashUpdate{Clear: true}); ok {
+		if reply.Removed > 0 {
+			fmt.Fprint(w, 1)
+		} else {
+			fmt.Fprint(w, 0)
+		}
 	}
 }
@@ -22,11 +22,14 @@ package runtime
  */
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 
+	"github.com/IBM/kar.git/core/internal/metrics"
 	"github.com/IBM/kar.git/core/internal/pubsub"
+	"github.com/IBM/kar.git/core/internal/store"
 	"github.com/IBM/kar.git/core/pkg/logger"
 	"github.com/julienschmidt/httprouter"
 )
@@ -65,6 +68,25 @@ func routeImplHealth(w http.ResponseWriter, r *http.Request, ps httprouter.Param
 	fmt.Fprint(w, "OK")
 }
 
+// swagger:route GET /v1/system/metrics system idSystemMetrics
+//
+// metrics
+//
+// ### Metrics endpoint
+//
+// Returns the metrics of the KAR runtime process in the Prometheus text format.
+//
+//     Schemes: http
+//     Produces:
+//     - text/plain
+//     Responses:
+//       200: response200
+//
+func routeImplMetrics(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Write(w)
+}
+
 // post handles a direct http request from a peer sidecar
 // TODO swagger
 func routeImplPost(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
@@ -109,6 +131,12 @@ func routeImplGetInformation(w http.ResponseWriter, r *http.Request, ps httprout
 		}
 	case "sidecar_actors":
 		data, err = formatActorInstanceMap(getMyActiveActors(""), format)
+	case "mailboxes":
+		if mailboxes, err := getAllMailboxes(ctx, ""); err == nil {
+			data, err = formatMailboxMap(mailboxes, format)
+		}
+	case "sidecar_mailboxes":
+		data, err = formatMailboxMap(getMyMailboxes(""), format)
 	default:
 		http.Error(w, fmt.Sprintf("Invalid information query: %v", component), http.StatusBadRequest)
 	}
@@ -119,3 +147,233 @@ func routeImplGetInformation(w http.ResponseWriter, r *http.Request, ps httprout
 		fmt.Fprint(w, data)
 	}
 }
+
+// swagger:route GET /v1/system/reminders system idSystemReminders
+//
+// reminders
+//
+// ### List reminders
+//
+// Returns the reminders scheduled across all the actors of the application,
+// optionally restricted to an actor type and instance.
+//
+//     Schemes: http
+//     Produces:
+//     - application/json
+//     Responses:
+//       200: response200ReminderGetAllResult
+//       500: response500
+//
+func routeImplGetReminders(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	routeImplListBindings(w, r, "reminders")
+}
+
+// swagger:route GET /v1/system/subscriptions system idSystemSubscriptions
+//
+// subscriptions
+//
+// ### List subscriptions
+//
+// Returns the subscriptions of all the actors of the application,
+// optionally restricted to an actor type and instance.
+//
+//     Schemes: http
+//     Produces:
+//     - application/json
+//     Responses:
+//       200: response200SubscriptionGetAllResult
+//       500: response500
+//
+func routeImplGetSubscriptions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	routeImplListBindings(w, r, "subscriptions")
+}
+
+func routeImplListBindings(w http.ResponseWriter, r *http.Request, kind string) {
+	found, err := listBindings(kind, r.FormValue("actorType"), r.FormValue("actorId"))
+	var data string
+	if err == nil {
+		data, err = formatBindings(found, "application/json")
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list %v: %v", kind, err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprint(w, data)
+}
+
+// swagger:route GET /v1/system/deadletters system idSystemDeadLetters
+//
+// deadletters
+//
+// ### List dead letters
+//
+// Returns the asynchronous invocations and events that could not be delivered
+// after the configured number of attempts, oldest first.
+//
+//     Schemes: http
+//     Produces:
+//     - application/json
+//     Responses:
+//       200: response200DeadLettersResult
+//       500: response500
+//
+func routeImplGetDeadLetters(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	found, err := listDeadLetters()
+	var data string
+	if err == nil {
+		data, err = formatDeadLetters(found, "application/json")
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to list dead letters: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprint(w, data)
+}
+
+// swagger:route GET /v1/system/deadletters/{deadLetterId} system idSystemDeadLetterGet
+//
+// deadletter
+//
+// ### Inspect a dead letter
+//
+// Returns the dead letter with the given id.
+//
+//     Schemes: http
+//     Produces:
+//     - application/json
+//     Responses:
+//       200: response200DeadLetterResult
+//       404: response404
+//       500: response500
+//
+func routeImplGetDeadLetter(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	d, err := getDeadLetter(ps.ByName("deadLetterId"))
+	if err == store.ErrNil {
+		http.Error(w, fmt.Sprintf("Dead letter %v not found", ps.ByName("deadLetterId")), http.StatusNotFound)
+		return
+	}
+	var buf []byte
+	if err == nil {
+		buf, err = json.Marshal(d)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get dead letter: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(buf)
+}
+
+// swagger:route POST /v1/system/deadletters/{deadLetterId}/redrive system idSystemDeadLetterRedrive
+//
+// redrive
+//
+// ### Re-drive a dead letter
+//
+// Sends the dead letter with the given id to its original target again
+// and removes it from the list of dead letters.
+// The dead letter is not removed from the dead-letter topic.
+//
+//     Schemes: http
+//     Responses:
+//       200: response200
+//       404: response404
+//       500: response500
+//
+func routeImplRedriveDeadLetter(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	err := redriveDeadLetter(ctx, ps.ByName("deadLetterId"))
+	if err == store.ErrNil {
+		http.Error(w, fmt.Sprintf("Dead letter %v not found", ps.ByName("deadLetterId")), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to re-drive dead letter: %v", err), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprint(w, "OK")
+}
+
+// swagger:route GET /v1/system/breakers system idSystemBreakers
+//
+// breakers
+//
+// ### Get the state of the circuit breakers and bulkhead
+//
+// Returns the circuit breakers of this sidecar that are open, half-open, or have
+// recent failures, keyed by actor type or path prefix, and the number of
+// in-flight requests to the application process with its limit (0 if unlimited).
+//
+//     Schemes: http
+//     Produces:
+//     - application/json
+//     Responses:
+//       200: response200BreakersResult
+//       500: response500
+//
+func routeImplGetBreakers(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	data, err := json.Marshal(struct {
+		Breakers map[string]breaker `json:"breakers"`
+		Bulkhead bulkhead           `json:"bulkhead"`
+	}{Breakers: getBreakers(), Bulkhead: getBulkhead()})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to marshal breakers: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	fmt.Fprint(w, string(data))
+}
+
+// swagger:route GET /v1/system/loglevel system idSystemLogLevelGet
+//
+// loglevel
+//
+// ### Get the log levels
+//
+// Returns the default log level of the KAR runtime process
+// followed by the log levels of individual subsystems if any,
+// for instance `error,pubsub=debug`.
+//
+//     Schemes: http
+//     Produces:
+//     - text/plain
+//     Responses:
+//       200: response200
+//
+func routeImplGetLogLevel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, logger.Verbosity())
+}
+
+// swagger:route PUT /v1/system/loglevel system idSystemLogLevelSet
+//
+// loglevel
+//
+// ### Set the log levels
+//
+// Updates the log levels of the KAR runtime process without restarting it.
+// The request body is a comma-separated list of an optional default level
+// and SUBSYSTEM=LEVEL pairs, for instance `info,pubsub=debug`.
+// Use SUBSYSTEM=default to revert a subsystem to the default level.
+// Returns the resulting log levels.
+//
+//     Schemes: http
+//     Consumes:
+//     - text/plain
+//     Produces:
+//     - text/plain
+//     Responses:
+//       200: response200
+//       400: response400
+//
+func routeImplSetLogLevel(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := logger.SetVerbosity(string(body)); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid log level: %v", err), http.StatusBadRequest)
+		return
+	}
+	logger.Info("log levels set to %s", logger.Verbosity())
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprint(w, logger.Verbosity())
+}
@@ -30,6 +30,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -40,6 +41,7 @@ import (
 	"github.com/IBM/kar.git/core/internal/config"
 	"github.com/IBM/kar.git/core/internal/pubsub"
 	"github.com/IBM/kar.git/core/internal/store"
+	"github.com/IBM/kar.git/core/internal/tracing"
 	"github.com/IBM/kar.git/core/pkg/logger"
 	"github.com/julienschmidt/httprouter"
 )
@@ -65,6 +67,7 @@ func server(listener net.Listener) http.Server {
 
 	// callbacks
 	router.POST(base+"/await", routeImplAwaitPromise)
+	router.DELETE(base+"/await/:request", routeImplCancelPromise)
 
 	// actor invocation
 	router.POST(base+"/actor/:type/:id/call/*path", routeImplCall)
@@ -97,12 +100,22 @@ func server(listener net.Listener) http.Server {
 	router.POST(base+"/actor/:type/:id/state", routeImplStateUpdate)
 	router.DELETE(base+"/actor/:type/:id/state", routeImplDelAll)
 	router.DELETE(base+"/actor/:type/:id", routeImplDelActor)
+	router.POST(base+"/actor/:type/:id/migrate", routeImplMigrate)
 
 	// kar system methods
 	router.GET(base+"/system/health", routeImplHealth)
+	router.GET(base+"/system/metrics", routeImplMetrics)
+	router.GET(base+"/system/breakers", routeImplGetBreakers)
+	router.GET(base+"/system/loglevel", routeImplGetLogLevel)
+	router.PUT(base+"/system/loglevel", routeImplSetLogLevel)
 	router.POST(base+"/system/shutdown", routeImplShutdown)
 	router.POST(base+"/system/post", routeImplPost)
 	router.GET(base+"/system/information/:component", routeImplGetInformation)
+	router.GET(base+"/system/reminders", routeImplGetReminders)
+	router.GET(base+"/system/subscriptions", routeImplGetSubscriptions)
+	router.GET(base+"/system/deadletters", routeImplGetDeadLetters)
+	router.GET(base+"/system/deadletters/:deadLetterId", routeImplGetDeadLetter)
+	router.POST(base+"/system/deadletters/:deadLetterId/redrive", routeImplRedriveDeadLetter)
 
 	// events
 	router.POST(base+"/event/:topic/publish", routeImplPublish)
@@ -112,21 +125,33 @@ func server(listener net.Listener) http.Server {
 	return http.Server{Handler: h2c.NewHandler(router, &http2.Server{MaxConcurrentStreams: 262144})}
 }
 
-// process incoming message asynchronously
+// process incoming message asynchronously once admitted for processing
 // one goroutine, incr and decr WaitGroup
 func process(m pubsub.Message) {
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		Process(ctx, cancel, m)
-	}()
+	admit(ctx, m.Value, m.Partition(), func(done func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer done()
+			Process(ctx, cancel, m)
+		}()
+	})
 }
 
 // Main is the main entrypoint for the KAR runtime
 func Main() {
 	logger.Warning("starting...")
-	logger.Info("redis: %v:%v", config.RedisHost, config.RedisPort)
-	logger.Info("kafka: %v", strings.Join(config.KafkaBrokers, ","))
+	initClient()
+	if config.Store == config.StoreRedis {
+		logger.Info("redis: %v:%v", config.RedisHost, config.RedisPort)
+	} else {
+		logger.Info("store: %v", config.Store)
+	}
+	if config.Transport == config.TransportKafka {
+		logger.Info("kafka: %v", strings.Join(config.KafkaBrokers, ","))
+	} else {
+		logger.Info("transport: %v", config.Transport)
+	}
 	exitCode := 0
 	defer func() { os.Exit(exitCode) }()
 
@@ -156,7 +181,7 @@ func Main() {
 	}
 
 	if err = store.Dial(); err != nil {
-		logger.Fatal("failed to connect to Redis: %v", err)
+		logger.Fatal("failed to connect to %v: %v", config.Store, err)
 	}
 	defer store.Close()
 
@@ -166,16 +191,29 @@ func Main() {
 	if config.CmdName == config.GetCmd && config.GetSystemComponent == "actors" && !config.GetResidentOnly {
 		requiresPubSub = false
 	}
+	if config.CmdName == config.DeadLettersCmd && flag.Arg(0) != "redrive" {
+		requiresPubSub = false
+	}
+	if config.CmdName == config.ExportCmd || config.CmdName == config.ImportCmd {
+		requiresPubSub = false
+	}
+	if config.CmdName == config.PurgeCmd && (selectivePurge() || config.PurgeDryRun) {
+		requiresPubSub = false
+	}
 
 	if requiresPubSub {
 		if err = pubsub.Dial(); err != nil {
-			logger.Fatal("failed to connect to Kafka: %v", err)
+			logger.Fatal("failed to connect to %v: %v", config.Transport, err)
 		}
 		defer pubsub.Close()
 	}
 
 	if config.CmdName == config.PurgeCmd {
-		purge("*")
+		if selectivePurge() {
+			purgeSelected()
+		} else {
+			purge("*")
+		}
 		return
 	} else if config.CmdName == config.DrainCmd {
 		purge("pubsub" + config.Separator + "*")
@@ -187,7 +225,23 @@ func Main() {
 		// one goroutine, defer close(closed)
 		closed, err = pubsub.Join(ctx, process, listener.Addr().(*net.TCPAddr).Port)
 		if err != nil {
-			logger.Fatal("failed to join Kafka consumer group for application: %v", err)
+			logger.Fatal("failed to join application: %v", err)
+		}
+		if config.CmdName == config.RunCmd && config.TraceExporter != config.TraceExporterNone {
+			endpoint, path := config.TraceEndpoint, ""
+			if config.TraceExporter == config.TraceExporterFile {
+				endpoint, path = "", config.TraceFile
+			}
+			resource := map[string]string{"service.name": config.AppName, "kar.service": config.ServiceName, "kar.sidecar": config.ID}
+			if err := tracing.Init(endpoint, path, resource); err != nil {
+				logger.Fatal("failed to initialize %v span exporter: %v", config.TraceExporter, err)
+			}
+			defer tracing.Close()
+		}
+		if config.CmdName == config.RunCmd && config.DeadLetterTopic != "" {
+			if err := pubsub.CreateTopic(config.DeadLetterTopic, ""); err != nil && err != pubsub.ErrTopicAlreadyExists {
+				logger.Error("failed to create dead-letter topic %v: %v", config.DeadLetterTopic, err)
+			}
 		}
 	}
 
@@ -202,6 +256,18 @@ func Main() {
 	} else if config.CmdName == config.GetCmd {
 		exitCode = getInformation(ctx9, args)
 		cancel()
+	} else if config.CmdName == config.MigrateCmd {
+		exitCode = migrateActor(ctx9, args)
+		cancel()
+	} else if config.CmdName == config.DeadLettersCmd {
+		exitCode = deadLetters(ctx9, args)
+		cancel()
+	} else if config.CmdName == config.ExportCmd {
+		exitCode = exportState(ctx9, args)
+		cancel()
+	} else if config.CmdName == config.ImportCmd {
+		exitCode = importState(ctx9, args)
+		cancel()
 	} else {
 		// start server and background tasks
 		srv := server(listener)
@@ -253,6 +319,18 @@ func Main() {
 			ValidateActorConfig(ctx)
 		}()
 
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			Rebalance(ctx)
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			CollectIdleActors(ctx)
+		}()
+
 		if len(args) > 0 {
 			exitCode = Run(ctx9, args, append(os.Environ(), runtimePort, appPort, requestTimeout))
 			cancel()
@@ -272,6 +350,10 @@ func Main() {
 }
 
 func purge(pattern string) {
+	if config.PurgeDryRun {
+		listKeys(pattern)
+		return
+	}
 	if err := pubsub.Purge(); err != nil {
 		logger.Error("failed to delete Kafka topic: %v", err)
 	}
@@ -281,3 +363,82 @@ func purge(pattern string) {
 		logger.Info("%v deleted keys", count)
 	}
 }
+
+// selectivePurge returns true if the purge options select a subset of the application state
+func selectivePurge() bool {
+	return config.PurgeActorType != "" || config.PurgeReminders || config.PurgeSubscriptions || config.PurgeOffsets
+}
+
+// purgePatterns returns the store key patterns selected by the purge options
+func purgePatterns() []string {
+	actor := Actor{Type: config.PurgeActorType, ID: config.PurgeActorID}
+	if actor.Type == "" {
+		actor.Type = "*"
+	}
+	if actor.ID == "" {
+		actor.ID = "*"
+	}
+	patterns := []string{}
+	if config.PurgeReminders {
+		patterns = append(patterns, bindingKey("reminders", actor, "*", "*"))
+	}
+	if config.PurgeSubscriptions {
+		patterns = append(patterns, bindingKey("subscriptions", actor, "*", "*"))
+	}
+	if config.PurgeOffsets {
+		patterns = append(patterns, pubsub.OffsetsKeyPattern())
+	}
+	if len(patterns) == 0 { // everything about an actor type or instance
+		patterns = append(patterns,
+			stateKey(actor.Type, actor.ID),
+			pubsub.PlacementKeyPattern(actor.Type, actor.ID),
+			bindingKey("reminders", actor, "*", "*"),
+			bindingKey("subscriptions", actor, "*", "*"))
+		if config.PurgeActorID == "" {
+			patterns = append(patterns, idleKey(actor.Type))
+		}
+	}
+	return patterns
+}
+
+// purgeSelected deletes the keys selected by the purge options but not the application topic
+func purgeSelected() {
+	patterns := purgePatterns()
+	if config.PurgeDryRun {
+		listKeys(patterns...)
+		return
+	}
+	count := 0
+	for _, pattern := range patterns {
+		n, err := store.Purge(pattern)
+		count += n
+		if err != nil {
+			logger.Error("failed to delete Redis keys: %v", err)
+			return
+		}
+	}
+	if config.PurgeActorID != "" && !config.PurgeReminders && !config.PurgeSubscriptions {
+		// forget the last activity time of the instance
+		if _, err := store.HDel(idleKey(config.PurgeActorType), config.PurgeActorID); err != nil {
+			logger.Error("failed to delete idle record: %v", err)
+		}
+	}
+	logger.Info("%v deleted keys", count)
+}
+
+// listKeys prints the keys matching the patterns to standard output
+func listKeys(patterns ...string) {
+	keys := []string{}
+	for _, pattern := range patterns {
+		reply, err := store.Keys(pattern)
+		if err != nil {
+			logger.Error("failed to list Redis keys: %v", err)
+			return
+		}
+		keys = append(keys, reply...)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+}
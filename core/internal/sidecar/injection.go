@@ -38,13 +38,14 @@ var (
 )
 
 const (
-	actorAnnotation       = "kar.ibm.com/actors"
-	appNameAnnotation     = "kar.ibm.com/app"
-	serviceNameAnnotation = "kar.ibm.com/service"
-	appPortAnnotation     = "kar.ibm.com/appPort"
-	runtimePortAnnotation = "kar.ibm.com/runtimePort"
-	verboseAnnotation     = "kar.ibm.com/verbose"
-	extraArgsAnnotation   = "kar.ibm.com/extraArgs"
+	actorAnnotation            = "kar.ibm.com/actors"
+	statelessWorkersAnnotation = "kar.ibm.com/statelessWorkers"
+	appNameAnnotation          = "kar.ibm.com/app"
+	serviceNameAnnotation      = "kar.ibm.com/service"
+	appPortAnnotation          = "kar.ibm.com/appPort"
+	runtimePortAnnotation      = "kar.ibm.com/runtimePort"
+	verboseAnnotation          = "kar.ibm.com/verbose"
+	extraArgsAnnotation        = "kar.ibm.com/extraArgs"
 
 	extraArgsSeparator = ","
 
@@ -236,6 +237,10 @@ func processAnnotations(pod corev1.Pod) ([]string, []corev1.EnvVar, string) {
 		cmd = append(cmd, "-actors", actors)
 	}
 
+	if workers, ok := annotations[statelessWorkersAnnotation]; ok {
+		cmd = append(cmd, "-stateless_workers", workers)
+	}
+
 	var appPort = defaultAppPort
 	if p, ok := annotations[appPortAnnotation]; ok {
 		appPort = p
@@ -0,0 +1,787 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/kar.git/core/pkg/logger"
+)
+
+// errWrongType indicates an operation against a key holding the wrong kind of value
+var errWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+// memoryBackend is an embedded Backend for single-sidecar deployments
+//
+// All the data is kept in memory. If a path is specified, the data is loaded
+// from this file when dialing, saved to this file periodically, and saved
+// when closing. Changes since the last save are lost if the process crashes.
+type memoryBackend struct {
+	path     string
+	interval time.Duration // interval between periodic saves, 0 to only save when closing
+	lock     sync.Mutex
+	data     memoryData
+	done     chan struct{} // closed to stop periodic saves
+	saver    sync.WaitGroup
+}
+
+// memoryData is the content of the embedded store (and its on-disk format)
+type memoryData struct {
+	Strings      map[string]string            `json:"strings"`
+	Hashes       map[string]map[string]string `json:"hashes"`
+	ZSets        map[string]map[string]int64  `json:"zsets"`
+	Expires      map[string]int64             `json:"expires"`      // key -> expiry time in unix ms
+	FieldExpires map[string]map[string]int64  `json:"fieldExpires"` // hash -> field -> expiry time in unix ms
+}
+
+func newMemoryBackend(path string, interval time.Duration) *memoryBackend {
+	return &memoryBackend{path: path, interval: interval, done: make(chan struct{}), data: memoryData{
+		Strings:      map[string]string{},
+		Hashes:       map[string]map[string]string{},
+		ZSets:        map[string]map[string]int64{},
+		Expires:      map[string]int64{},
+		FieldExpires: map[string]map[string]int64{},
+	}}
+}
+
+// expire deletes the key or the fields of the hash whose expiry time has passed
+func (m *memoryBackend) expire(key string) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	if t, ok := m.data.Expires[key]; ok && t <= now {
+		m.del(key)
+		return
+	}
+	if fields, ok := m.data.FieldExpires[key]; ok {
+		h := m.data.Hashes[key]
+		for f, t := range fields {
+			if t <= now {
+				delete(h, f)
+				delete(fields, f)
+			}
+		}
+		if len(fields) == 0 {
+			delete(m.data.FieldExpires, key)
+		}
+		if h != nil && len(h) == 0 {
+			m.del(key)
+		}
+	}
+}
+
+// persistField removes the expiry time of a field of a hash
+func (m *memoryBackend) persistField(hash, field string) {
+	if fields, ok := m.data.FieldExpires[hash]; ok {
+		delete(fields, field)
+		if len(fields) == 0 {
+			delete(m.data.FieldExpires, hash)
+		}
+	}
+}
+
+// exists returns true if the key holds a value of any kind
+func (m *memoryBackend) exists(key string) bool {
+	m.expire(key)
+	return m.present(key)
+}
+
+// present returns true if the key holds a value of any kind, ignoring expiry times
+func (m *memoryBackend) present(key string) bool {
+	_, s := m.data.Strings[key]
+	_, h := m.data.Hashes[key]
+	_, z := m.data.ZSets[key]
+	return s || h || z
+}
+
+// del deletes the key and returns 1 if the key existed
+func (m *memoryBackend) del(key string) int {
+	if !m.present(key) {
+		return 0
+	}
+	delete(m.data.Strings, key)
+	delete(m.data.Hashes, key)
+	delete(m.data.ZSets, key)
+	delete(m.data.Expires, key)
+	delete(m.data.FieldExpires, key)
+	return 1
+}
+
+// hash returns the hash for key, creating it if requested
+func (m *memoryBackend) hash(key string, create bool) (map[string]string, error) {
+	m.expire(key)
+	if h, ok := m.data.Hashes[key]; ok {
+		return h, nil
+	}
+	if m.exists(key) {
+		return nil, errWrongType
+	}
+	if !create {
+		return nil, nil
+	}
+	h := map[string]string{}
+	m.data.Hashes[key] = h
+	return h, nil
+}
+
+// zset returns the sorted set for key, creating it if requested
+func (m *memoryBackend) zset(key string, create bool) (map[string]int64, error) {
+	m.expire(key)
+	if z, ok := m.data.ZSets[key]; ok {
+		return z, nil
+	}
+	if m.exists(key) {
+		return nil, errWrongType
+	}
+	if !create {
+		return nil, nil
+	}
+	z := map[string]int64{}
+	m.data.ZSets[key] = z
+	return z, nil
+}
+
+// keys returns all the keys matching the pattern
+func (m *memoryBackend) keys(pattern string) []string {
+	for _, k := range m.expirable() {
+		m.expire(k)
+	}
+	keys := []string{}
+	for k := range m.data.Strings {
+		if matchPattern(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range m.data.Hashes {
+		if matchPattern(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	for k := range m.data.ZSets {
+		if matchPattern(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// expirable returns the keys with an expiry time
+func (m *memoryBackend) expirable() []string {
+	keys := make([]string, 0, len(m.data.Expires)+len(m.data.FieldExpires))
+	for k := range m.data.Expires {
+		keys = append(keys, k)
+	}
+	for k := range m.data.FieldExpires {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Keys
+
+func (m *memoryBackend) Set(key, value string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.del(key)
+	m.data.Strings[key] = value
+	return "OK", nil
+}
+
+func (m *memoryBackend) SetWithTTL(key, value string, ttl time.Duration) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.del(key)
+	m.data.Strings[key] = value
+	m.data.Expires[key] = time.Now().Add(ttl).UnixNano() / int64(time.Millisecond)
+	return "OK", nil
+}
+
+func (m *memoryBackend) Get(key string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire(key)
+	if v, ok := m.data.Strings[key]; ok {
+		return v, nil
+	}
+	if m.exists(key) {
+		return "", errWrongType
+	}
+	return "", ErrNil
+}
+
+func (m *memoryBackend) Del(key string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire(key)
+	return m.del(key), nil
+}
+
+func (m *memoryBackend) CompareAndSet(key string, expected, value *string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expire(key)
+	current, ok := m.data.Strings[key]
+	if !ok && m.exists(key) {
+		return 0, errWrongType
+	}
+	if expected == nil && ok || expected != nil && (!ok || current != *expected) {
+		return 0, nil
+	}
+	if value == nil {
+		m.del(key)
+	} else {
+		m.data.Strings[key] = *value
+		delete(m.data.Expires, key)
+	}
+	return 1, nil
+}
+
+func (m *memoryBackend) Keys(pattern string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.keys(pattern), nil
+}
+
+func (m *memoryBackend) Purge(pattern string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	count := 0
+	for _, k := range m.keys(pattern) {
+		count += m.del(k)
+	}
+	return count, nil
+}
+
+func (m *memoryBackend) Rename(key, newKey string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.exists(key) {
+		return 0, nil
+	}
+	if key == newKey {
+		return 1, nil
+	}
+	m.del(newKey)
+	if v, ok := m.data.Strings[key]; ok {
+		m.data.Strings[newKey] = v
+	}
+	if h, ok := m.data.Hashes[key]; ok {
+		m.data.Hashes[newKey] = h
+	}
+	if z, ok := m.data.ZSets[key]; ok {
+		m.data.ZSets[newKey] = z
+	}
+	if t, ok := m.data.Expires[key]; ok {
+		m.data.Expires[newKey] = t
+	}
+	if f, ok := m.data.FieldExpires[key]; ok {
+		m.data.FieldExpires[newKey] = f
+	}
+	delete(m.data.Strings, key)
+	delete(m.data.Hashes, key)
+	delete(m.data.ZSets, key)
+	delete(m.data.Expires, key)
+	delete(m.data.FieldExpires, key)
+	return 1, nil
+}
+
+func (m *memoryBackend) Expire(key string, ttl time.Duration) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.exists(key) {
+		return 0, nil
+	}
+	m.data.Expires[key] = time.Now().Add(ttl).UnixNano() / int64(time.Millisecond)
+	return 1, nil
+}
+
+// Hashes
+
+func (m *memoryBackend) HSetMultiple(hash string, keyValuePairs map[string]string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, err := m.hash(hash, true)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for k, v := range keyValuePairs {
+		if _, ok := h[k]; !ok {
+			count++
+		}
+		h[k] = v
+		m.persistField(hash, k)
+	}
+	return count, nil
+}
+
+func (m *memoryBackend) HGet(hash, key string) (string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, err := m.hash(hash, false)
+	if err != nil {
+		return "", err
+	}
+	if v, ok := h[key]; ok {
+		return v, nil
+	}
+	return "", ErrNil
+}
+
+func (m *memoryBackend) HDelMultiple(hash string, keys []string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, err := m.hash(hash, false)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, k := range keys {
+		if _, ok := h[k]; ok {
+			delete(h, k)
+			m.persistField(hash, k)
+			count++
+		}
+	}
+	if h != nil && len(h) == 0 {
+		m.del(hash)
+	}
+	return count, nil
+}
+
+func (m *memoryBackend) HMGet(hash string, keys []string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, err := m.hash(hash, false)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = h[k]
+	}
+	return values, nil
+}
+
+// HScan returns all matching fields at once
+func (m *memoryBackend) HScan(hash string, cursor int, match string) (int, []string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, err := m.hash(hash, false)
+	if err != nil {
+		return 0, nil, err
+	}
+	data := []string{}
+	for k, v := range h {
+		if match == "" || matchPattern(match, k) {
+			data = append(data, k, v)
+		}
+	}
+	return 0, data, nil
+}
+
+func (m *memoryBackend) HGetAll(hash string) (map[string]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, err := m.hash(hash, false)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string, len(h))
+	for k, v := range h {
+		result[k] = v
+	}
+	return result, nil
+}
+
+func (m *memoryBackend) HExists(hash string, key string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, err := m.hash(hash, false)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := h[key]; ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m *memoryBackend) HKeys(hash string) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, err := m.hash(hash, false)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (m *memoryBackend) HUpdate(hash, versionField, counter string, update HashUpdate) (HashUpdateResult, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	h, err := m.hash(hash, false)
+	if err != nil {
+		return HashUpdateResult{}, err
+	}
+	version, ok := h[versionField]
+	if !ok {
+		version = "0"
+	}
+	if len(update.Expected) > 0 {
+		match := false
+		for _, v := range update.Expected {
+			if v == version {
+				match = true
+			}
+		}
+		if !match {
+			return HashUpdateResult{Version: version}, ErrConflict
+		}
+	}
+	result := HashUpdateResult{Version: version}
+	if h == nil {
+		if len(update.Updates) == 0 {
+			return result, nil
+		}
+		h, _ = m.hash(hash, true)
+	}
+	if update.Clear {
+		for k := range h {
+			if k != versionField {
+				result.Removed++
+			}
+		}
+		m.del(hash)
+		h, _ = m.hash(hash, true)
+	}
+	for _, k := range update.Removals {
+		if _, ok := h[k]; ok && k != versionField {
+			delete(h, k)
+			m.persistField(hash, k)
+			result.Removed++
+		}
+	}
+	now := time.Now()
+	for k, v := range update.Updates {
+		if _, ok := h[k]; !ok {
+			result.Added++
+		}
+		h[k] = v
+		if update.FieldTTL > 0 {
+			if m.data.FieldExpires[hash] == nil {
+				m.data.FieldExpires[hash] = map[string]int64{}
+			}
+			m.data.FieldExpires[hash][k] = now.Add(update.FieldTTL).UnixNano() / int64(time.Millisecond)
+		} else {
+			m.persistField(hash, k)
+		}
+	}
+	if result.Removed > 0 || len(update.Updates) > 0 {
+		if _, ok := h[versionField]; len(h) == 0 || (len(h) == 1 && ok) {
+			m.del(hash)
+			result.Version = "0"
+		} else {
+			n, _ := strconv.ParseInt(m.data.Strings[counter], 10, 64)
+			result.Version = strconv.FormatInt(n+1, 10)
+			m.data.Strings[counter] = result.Version
+			h[versionField] = result.Version
+		}
+	}
+	if update.TTL > 0 && m.present(hash) {
+		m.data.Expires[hash] = now.Add(update.TTL).UnixNano() / int64(time.Millisecond)
+	}
+	return result, nil
+}
+
+// Sorted sets
+
+func (m *memoryBackend) ZAdd(key string, score int64, value string) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	z, err := m.zset(key, true)
+	if err != nil {
+		return 0, err
+	}
+	_, ok := z[value]
+	z[value] = score
+	if ok {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (m *memoryBackend) ZRange(key string, start, stop int) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	z, err := m.zset(key, false)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(z))
+	for v := range z {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if z[values[i]] != z[values[j]] {
+			return z[values[i]] < z[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	n := len(values)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop {
+		return []string{}, nil
+	}
+	return values[start : stop+1], nil
+}
+
+func (m *memoryBackend) ZRemRangeByScore(key string, min, max int64) (int, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	z, err := m.zset(key, false)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for v, score := range z {
+		if score >= min && score <= max {
+			delete(z, v)
+			count++
+		}
+	}
+	if z != nil && len(z) == 0 {
+		m.del(key)
+	}
+	return count, nil
+}
+
+func (m *memoryBackend) ZPopByScore(key string, max int64, count int) ([]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	z, err := m.zset(key, false)
+	if err != nil {
+		return nil, err
+	}
+	values := []string{}
+	for v, score := range z {
+		if score <= max {
+			values = append(values, v)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if z[values[i]] != z[values[j]] {
+			return z[values[i]] < z[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	if len(values) > count {
+		values = values[:count]
+	}
+	for _, v := range values {
+		delete(z, v)
+	}
+	if z != nil && len(z) == 0 {
+		m.del(key)
+	}
+	return values, nil
+}
+
+// Dial loads the store from disk and starts periodic saves if a path was specified
+func (m *memoryBackend) Dial() error {
+	if m.path == "" {
+		return nil
+	}
+	if err := m.load(); err != nil {
+		return err
+	}
+	if m.interval > 0 {
+		m.saver.Add(1)
+		go func() {
+			defer m.saver.Done()
+			ticker := time.NewTicker(m.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					m.save() // errors are logged, retry on next tick
+				case <-m.done:
+					return
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// load loads the store from disk if the file exists
+func (m *memoryBackend) load() error {
+	buf, err := ioutil.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err := json.Unmarshal(buf, &m.data); err != nil {
+		logger.Error("failed to load store from %s: %v", m.path, err)
+		return err
+	}
+	if m.data.Strings == nil {
+		m.data.Strings = map[string]string{}
+	}
+	if m.data.Hashes == nil {
+		m.data.Hashes = map[string]map[string]string{}
+	}
+	if m.data.ZSets == nil {
+		m.data.ZSets = map[string]map[string]int64{}
+	}
+	if m.data.Expires == nil {
+		m.data.Expires = map[string]int64{}
+	}
+	if m.data.FieldExpires == nil {
+		m.data.FieldExpires = map[string]map[string]int64{}
+	}
+	return nil
+}
+
+// Close stops periodic saves and saves the store to disk if a path was specified
+func (m *memoryBackend) Close() error {
+	if m.path == "" {
+		return nil
+	}
+	close(m.done)
+	m.saver.Wait()
+	return m.save()
+}
+
+// save atomically replaces the file with the content of the store
+func (m *memoryBackend) save() error {
+	m.lock.Lock()
+	buf, err := json.Marshal(m.data)
+	m.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		logger.Error("failed to save store to %s: %v", m.path, err)
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// matchPattern reports whether s matches the glob-style pattern (same syntax as Redis)
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			matched := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					pattern = pattern[1:]
+					if pattern[0] == s[0] {
+						matched = true
+					}
+					pattern = pattern[1:]
+				} else if len(pattern) >= 3 && pattern[1] == '-' && pattern[2] != ']' {
+					lo, hi := pattern[0], pattern[2]
+					if lo > hi {
+						lo, hi = hi, lo
+					}
+					if s[0] >= lo && s[0] <= hi {
+						matched = true
+					}
+					pattern = pattern[3:]
+				} else {
+					if pattern[0] == s[0] {
+						matched = true
+					}
+					pattern = pattern[1:]
+				}
+			}
+			if len(pattern) > 0 { // skip closing bracket
+				pattern = pattern[1:]
+			}
+			if matched == not {
+				return false
+			}
+			s = s[1:]
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
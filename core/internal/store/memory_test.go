@@ -0,0 +1,370 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package store
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"abc", "abc", true},
+		{"abc", "abd", false},
+		{"abc", "ab", false},
+		{"*", "", true},
+		{"*", "anything", true},
+		{"a*", "a", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbbd", false},
+		{"a**c", "ac", true},
+		{"*_*_*", "kar_app_x", true},
+		{"*_*_*", "kar_app", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[\\]]llo", "h]llo", true},
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"[abc]", "", false},
+		{"?", "", false},
+	}
+	for _, tt := range tests {
+		if got := matchPattern(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestMemoryStrings(t *testing.T) {
+	m := newMemoryBackend("", 0)
+	if _, err := m.Get("k"); err != ErrNil {
+		t.Fatalf("Get of missing key returned %v, want ErrNil", err)
+	}
+	m.Set("k", "v")
+	if v, err := m.Get("k"); err != nil || v != "v" {
+		t.Fatalf("Get = %q, %v, want v", v, err)
+	}
+	old, new := "v", "w"
+	if n, _ := m.CompareAndSet("k", &new, &old); n != 0 {
+		t.Errorf("CompareAndSet with wrong expected value succeeded")
+	}
+	if n, _ := m.CompareAndSet("k", nil, &new); n != 0 {
+		t.Errorf("CompareAndSet creating an existing key succeeded")
+	}
+	if n, _ := m.CompareAndSet("k", &old, &new); n != 1 {
+		t.Errorf("CompareAndSet with expected value failed")
+	}
+	if n, _ := m.CompareAndSet("k", &new, nil); n != 1 {
+		t.Errorf("CompareAndSet deleting the key failed")
+	}
+	if n, _ := m.Del("k"); n != 0 {
+		t.Errorf("Del of deleted key returned %v, want 0", n)
+	}
+	m.HSetMultiple("h", map[string]string{"f": "v"})
+	if _, err := m.Get("h"); err != errWrongType {
+		t.Errorf("Get of a hash returned %v, want errWrongType", err)
+	}
+}
+
+func TestMemoryKeysAndPurge(t *testing.T) {
+	m := newMemoryBackend("", 0)
+	m.Set("a_1", "x")
+	m.HSetMultiple("a_2", map[string]string{"f": "v"})
+	m.ZAdd("b_1", 1, "x")
+	keys, _ := m.Keys("a_*")
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"a_1", "a_2"}) {
+		t.Errorf("Keys = %v", keys)
+	}
+	if n, _ := m.Purge("a_*"); n != 2 {
+		t.Errorf("Purge = %v, want 2", n)
+	}
+	if keys, _ := m.Keys("*"); !reflect.DeepEqual(keys, []string{"b_1"}) {
+		t.Errorf("Keys after purge = %v", keys)
+	}
+}
+
+func TestMemoryExpire(t *testing.T) {
+	m := newMemoryBackend("", 0)
+	if n, _ := m.Expire("k", time.Millisecond); n != 0 {
+		t.Errorf("Expire of missing key returned %v, want 0", n)
+	}
+	m.Set("k", "v")
+	if n, _ := m.Expire("k", time.Millisecond); n != 1 {
+		t.Errorf("Expire returned %v, want 1", n)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := m.Get("k"); err != ErrNil {
+		t.Errorf("Get of expired key returned %v, want ErrNil", err)
+	}
+	m.SetWithTTL("d", "v", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if n, _ := m.Del("d"); n != 0 {
+		t.Errorf("Del of expired key returned %v, want 0", n)
+	}
+	m.Set("k", "v") // set clears expiry
+	if v, err := m.Get("k"); err != nil || v != "v" {
+		t.Errorf("Get = %q, %v, want v", v, err)
+	}
+}
+
+func TestMemoryHashes(t *testing.T) {
+	m := newMemoryBackend("", 0)
+	if n, _ := m.HSetMultiple("h", map[string]string{"a": "1", "b": "2"}); n != 2 {
+		t.Errorf("HSetMultiple added %v fields, want 2", n)
+	}
+	if n, _ := m.HSetMultiple("h", map[string]string{"a": "3", "c": "4"}); n != 1 {
+		t.Errorf("HSetMultiple added %v fields, want 1", n)
+	}
+	if all, _ := m.HGetAll("h"); !reflect.DeepEqual(all, map[string]string{"a": "3", "b": "2", "c": "4"}) {
+		t.Errorf("HGetAll = %v", all)
+	}
+	if n, _ := m.HDelMultiple("h", []string{"a", "b", "c", "d"}); n != 3 {
+		t.Errorf("HDelMultiple removed %v fields, want 3", n)
+	}
+	if n, _ := m.Del("h"); n != 0 {
+		t.Errorf("empty hash was not deleted")
+	}
+}
+
+func TestMemoryZSets(t *testing.T) {
+	m := newMemoryBackend("", 0)
+	m.ZAdd("z", 3, "c")
+	m.ZAdd("z", 1, "a")
+	m.ZAdd("z", 2, "b")
+	if r, _ := m.ZRange("z", 0, -1); !reflect.DeepEqual(r, []string{"a", "b", "c"}) {
+		t.Errorf("ZRange = %v", r)
+	}
+	if n, _ := m.ZRemRangeByScore("z", 1, 2); n != 2 {
+		t.Errorf("ZRemRangeByScore removed %v elements, want 2", n)
+	}
+	if r, _ := m.ZRange("z", 0, -1); !reflect.DeepEqual(r, []string{"c"}) {
+		t.Errorf("ZRange after removal = %v", r)
+	}
+	m.ZAdd("z", 5, "e")
+	m.ZAdd("z", 4, "d")
+	if r, _ := m.ZPopByScore("z", 3, 10); !reflect.DeepEqual(r, []string{"c"}) {
+		t.Errorf("ZPopByScore = %v, want [c]", r)
+	}
+	if r, _ := m.ZPopByScore("z", 10, 1); !reflect.DeepEqual(r, []string{"d"}) {
+		t.Errorf("ZPopByScore with count = %v, want [d]", r)
+	}
+	if r, _ := m.ZPopByScore("z", 10, 10); !reflect.DeepEqual(r, []string{"e"}) {
+		t.Errorf("ZPopByScore = %v, want [e]", r)
+	}
+	if n, _ := m.Del("z"); n != 0 {
+		t.Errorf("empty sorted set was not deleted")
+	}
+}
+
+func TestMemoryPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	m := newMemoryBackend(path, 10*time.Millisecond)
+	if err := m.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	m.Set("k", "v")
+	m.HSetMultiple("h", map[string]string{"f": "v"})
+	time.Sleep(50 * time.Millisecond)
+
+	// periodic save without close
+	crashed := newMemoryBackend(path, 0)
+	if err := crashed.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := crashed.Get("k"); err != nil || v != "v" {
+		t.Errorf("Get after periodic save = %q, %v, want v", v, err)
+	}
+
+	m.Set("k", "w")
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := newMemoryBackend(path, 0)
+	if err := reopened.Dial(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := reopened.Get("k"); err != nil || v != "w" {
+		t.Errorf("Get after close = %q, %v, want w", v, err)
+	}
+	if v, err := reopened.HGet("h", "f"); err != nil || v != "v" {
+		t.Errorf("HGet after close = %q, %v, want v", v, err)
+	}
+}
+
+func TestMemoryRename(t *testing.T) {
+	m := newMemoryBackend("", 0)
+	if n, _ := m.Rename("a", "b"); n != 0 {
+		t.Errorf("Rename of missing key returned %v, want 0", n)
+	}
+	m.HSetMultiple("a", map[string]string{"f": "1"})
+	m.Set("b", "stale")
+	if n, _ := m.Rename("a", "b"); n != 1 {
+		t.Errorf("Rename returned %v, want 1", n)
+	}
+	if keys, _ := m.Keys("*"); !reflect.DeepEqual(keys, []string{"b"}) {
+		t.Errorf("Keys after rename = %v", keys)
+	}
+	if v, err := m.HGet("b", "f"); err != nil || v != "1" {
+		t.Errorf("HGet after rename = %q, %v, want 1", v, err)
+	}
+	if n, _ := m.Rename("b", "b"); n != 1 {
+		t.Errorf("Rename to itself returned %v, want 1", n)
+	}
+	if v, err := m.HGet("b", "f"); err != nil || v != "1" {
+		t.Errorf("HGet after rename to itself = %q, %v, want 1", v, err)
+	}
+}
+
+func TestMemoryHUpdate(t *testing.T) {
+	m := newMemoryBackend("", 0)
+	update := func(h string, u HashUpdate) HashUpdateResult {
+		t.Helper()
+		r, err := m.HUpdate(h, "v", "counter", u)
+		if err != nil {
+			t.Fatalf("HUpdate(%+v): %v", u, err)
+		}
+		return r
+	}
+	if r := update("h", HashUpdate{Removals: []string{"a"}}); r.Version != "0" || r.Removed != 0 {
+		t.Errorf("no-op update on missing hash = %+v", r)
+	}
+	if n, _ := m.Del("h"); n != 0 {
+		t.Errorf("no-op update created the hash")
+	}
+	r := update("h", HashUpdate{Updates: map[string]string{"a": "1", "b": "2"}})
+	if r.Version != "1" || r.Added != 2 {
+		t.Errorf("first update = %+v, want version 1 and 2 added", r)
+	}
+	if r := update("g", HashUpdate{Updates: map[string]string{"a": "1"}}); r.Version != "2" {
+		t.Errorf("versions are not drawn from the shared counter: %+v", r)
+	}
+	if r := update("h", HashUpdate{Expected: []string{"1"}, Removals: []string{"a", "v"}, Updates: map[string]string{"b": "3"}}); r.Version != "3" || r.Removed != 1 || r.Added != 0 {
+		t.Errorf("update = %+v, want version 3, 1 removed, 0 added", r)
+	}
+	if r, err := m.HUpdate("h", "v", "counter", HashUpdate{Expected: []string{"1", "2"}, Updates: map[string]string{"c": "4"}}); err != ErrConflict || r.Version != "3" {
+		t.Errorf("conflicting update = %+v, %v, want ErrConflict and version 3", r, err)
+	}
+	if r := update("h", HashUpdate{Removals: []string{"missing"}}); r.Version != "3" {
+		t.Errorf("unmodified hash changed version: %+v", r)
+	}
+	if all, _ := m.HGetAll("h"); !reflect.DeepEqual(all, map[string]string{"b": "3", "v": "3"}) {
+		t.Errorf("HGetAll = %v", all)
+	}
+
+	// removing the last field deletes the hash
+	if r := update("h", HashUpdate{Removals: []string{"b"}}); r.Version != "0" || r.Removed != 1 {
+		t.Errorf("removal of last field = %+v, want version 0", r)
+	}
+	if n, _ := m.Del("h"); n != 0 {
+		t.Errorf("hash with only a version field was not deleted")
+	}
+
+	// a recreated hash does not reuse a version
+	if r := update("h", HashUpdate{Expected: []string{"0"}, Updates: map[string]string{"a": "1"}}); r.Version != "4" {
+		t.Errorf("recreated hash has version %v, want 4", r.Version)
+	}
+	if r := update("h", HashUpdate{Clear: true}); r.Version != "0" || r.Removed != 1 {
+		t.Errorf("clear = %+v, want version 0 and 1 removed", r)
+	}
+	if n, _ := m.Del("h"); n != 0 {
+		t.Errorf("cleared hash was not deleted")
+	}
+	if r := update("h", HashUpdate{Clear: true, Updates: map[string]string{"x": "1"}}); r.Version != "5" || r.Added != 1 {
+		t.Errorf("clear and update = %+v, want version 5 and 1 added", r)
+	}
+}
+
+func TestMemoryHUpdateTTL(t *testing.T) {
+	m := newMemoryBackend("", 0)
+	if _, err := m.HUpdate("h", "v", "counter", HashUpdate{Updates: map[string]string{"a": "1"}, FieldTTL: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.HUpdate("h", "v", "counter", HashUpdate{Updates: map[string]string{"b": "2", "c": "3"}, FieldTTL: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.HUpdate("h", "v", "counter", HashUpdate{Updates: map[string]string{"c": "4"}}); err != nil { // removes the ttl of c
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if all, _ := m.HGetAll("h"); !reflect.DeepEqual(all, map[string]string{"c": "4", "v": "3"}) {
+		t.Errorf("HGetAll after field expiry = %v", all)
+	}
+
+	if _, err := m.HUpdate("g", "v", "counter", HashUpdate{Updates: map[string]string{"a": "1"}, TTL: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.HUpdate("g", "v", "counter", HashUpdate{Updates: map[string]string{"b": "2"}}); err != nil { // keeps the ttl of g
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if keys, _ := m.Keys("g"); len(keys) != 0 {
+		t.Errorf("hash with ttl did not expire")
+	}
+	if r, _ := m.HUpdate("g", "v", "counter", HashUpdate{Removals: []string{"a"}, TTL: time.Millisecond}); r.Version != "0" {
+		t.Errorf("ttl on missing hash = %+v", r)
+	}
+	if n, _ := m.Del("g"); n != 0 {
+		t.Errorf("ttl created a missing hash")
+	}
+}
+
+func TestMemoryFieldTTLWrites(t *testing.T) {
+	m := newMemoryBackend("", 0)
+	ttl := HashUpdate{Updates: map[string]string{"a": "1", "b": "2", "c": "3"}, FieldTTL: time.Millisecond}
+	if _, err := m.HUpdate("h", "v", "counter", ttl); err != nil {
+		t.Fatal(err)
+	}
+	m.HSetMultiple("h", map[string]string{"a": "4"}) // removes the ttl of a
+	m.HDelMultiple("h", []string{"b"})
+	m.HSetMultiple("h", map[string]string{"b": "5"}) // b has no ttl after delete
+	if _, err := m.HUpdate("g", "v", "counter", ttl); err != nil {
+		t.Fatal(err)
+	}
+	m.Del("g")
+	m.HSetMultiple("g", map[string]string{"a": "6"}) // a has no ttl after delete
+	time.Sleep(5 * time.Millisecond)
+	if all, _ := m.HGetAll("h"); !reflect.DeepEqual(all, map[string]string{"a": "4", "b": "5", "v": "1"}) {
+		t.Errorf("HGetAll(h) after field expiry = %v", all)
+	}
+	if all, _ := m.HGetAll("g"); !reflect.DeepEqual(all, map[string]string{"a": "6"}) {
+		t.Errorf("HGetAll(g) after field expiry = %v", all)
+	}
+
+	if _, err := m.HUpdate("f", "v", "counter", HashUpdate{Updates: map[string]string{"a": "1"}, FieldTTL: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if all, _ := m.HGetAll("f"); !reflect.DeepEqual(all, map[string]string{"v": "3"}) { // expiry keeps the version
+		t.Errorf("HGetAll(f) after field expiry = %v", all)
+	}
+}
@@ -0,0 +1,533 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package store
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/kar.git/core/internal/config"
+	"github.com/IBM/kar.git/core/internal/metrics"
+	"github.com/IBM/kar.git/core/pkg/logger"
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	operations        = metrics.NewCounterVec("kar_store_operations_total", "Store commands by command and result.", "command", "result")
+	operationDuration = metrics.NewHistogramVec("kar_store_operation_duration_seconds", "Latency of store commands.", metrics.DefaultBuckets, "command")
+)
+
+// redisBackend is the Backend implementation for Redis
+//
+// Redis before 7.4 cannot expire hash fields, so the expiry times of fields
+// are recorded in a sorted set of [hash, field] JSON arrays and expired fields
+// are deleted periodically by the sidecars. Commands setting, deleting, or
+// renaming hash fields remove or move their expiry times.
+type redisBackend struct {
+	pool     *redis.Pool   // connection pool
+	expiries string        // sorted set of field expiry times
+	done     chan struct{} // closed to stop periodic field expiry
+	sweeper  sync.WaitGroup
+}
+
+// interval between deletions of expired fields
+const fieldExpiryInterval = time.Second
+
+// send a command while holding the connection mutex
+func (r *redisBackend) doRaw(command string, args ...interface{}) (reply interface{}, err error) {
+	opStart := time.Now()
+	conn := r.pool.Get()
+	defer conn.Close()
+	start := time.Now()
+	reply, err = conn.Do(command, args...)
+	last := time.Now()
+	elapsed := last.Sub(opStart)
+	connElapsed := last.Sub(start)
+	if elapsed > config.LongRedisOperation {
+		logger.Error("Slow Redis operation: %v total seconds (%v in conn.Do). Command was %v %v", elapsed.Seconds(), connElapsed.Seconds(), command, args[0])
+	}
+	if err != nil {
+		logger.Error("failed to send command to redis: %v", err)
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	operations.Inc(command, result)
+	operationDuration.Observe(elapsed.Seconds(), command)
+	return
+}
+
+// Keys
+
+func (r *redisBackend) Set(key, value string) (string, error) {
+	return redis.String(r.doRaw("SET", key, value))
+}
+
+func (r *redisBackend) SetWithTTL(key, value string, ttl time.Duration) (string, error) {
+	return redis.String(r.doRaw("SET", key, value, "PX", ttl.Milliseconds()))
+}
+
+func (r *redisBackend) Get(key string) (string, error) {
+	return redis.String(r.doRaw("GET", key))
+}
+
+// delScript deletes KEYS[1] and the expiry times of its fields recorded in the sorted set KEYS[2]
+const delScript = `
+if redis.call('TYPE', KEYS[1]).ok == 'hash' and redis.call('EXISTS', KEYS[2]) == 1 then
+  for _, k in ipairs(redis.call('HKEYS', KEYS[1])) do
+    redis.call('ZREM', KEYS[2], cjson.encode({KEYS[1], k}))
+  end
+end
+return redis.call('DEL', KEYS[1])
+`
+
+func (r *redisBackend) Del(key string) (int, error) {
+	return redis.Int(r.doRaw("EVAL", delScript, 2, key, r.expiries))
+}
+
+func (r *redisBackend) CompareAndSet(key string, expected, value *string) (int, error) {
+	if expected == nil && value == nil {
+		_, err := redis.String(r.doRaw("GET", key))
+		if err != nil {
+			if err == ErrNil {
+				return 1, nil
+			}
+			return 0, err
+		}
+		return 0, nil
+	}
+	if expected == nil {
+		return redis.Int(r.doRaw("SETNX", key, *value))
+	}
+	if value == nil {
+		return redis.Int(r.doRaw("EVAL", "if redis.call('GET', KEYS[1]) == ARGV[1] then redis.call('DEL', KEYS[1]); return 1 else return 0 end", 1, key, *expected))
+	}
+	return redis.Int(r.doRaw("EVAL", "if redis.call('GET', KEYS[1]) == ARGV[1] then redis.call('SET', KEYS[1], ARGV[2]); return 1 else return 0 end", 1, key, *expected, *value))
+}
+
+func (r *redisBackend) Keys(pattern string) ([]string, error) {
+	return redis.Strings(r.doRaw("KEYS", pattern))
+}
+
+func (r *redisBackend) Purge(pattern string) (int, error) {
+	bags := [][]interface{}{}
+	cursor := 0
+	for {
+		reply, err := redis.Values(r.doRaw("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		if err != nil {
+			return 0, err
+		}
+		cursor, _ = strconv.Atoi(string(reply[0].([]byte)))
+		keys := reply[1].([]interface{})
+		if len(keys) > 0 {
+			bags = append(bags, keys)
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	count := 0
+	for _, keys := range bags {
+		n, err := redis.Int(r.doRaw("DEL", keys...))
+		count += n
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// renameScript renames KEYS[1] to KEYS[2] if KEYS[1] exists
+// and moves the expiry times of its fields recorded in the sorted set KEYS[3]
+const renameScript = `
+if redis.call('EXISTS', KEYS[1]) == 0 then return 0 end
+if KEYS[1] == KEYS[2] then return 1 end
+if redis.call('EXISTS', KEYS[3]) == 1 then
+  if redis.call('TYPE', KEYS[2]).ok == 'hash' then
+    for _, k in ipairs(redis.call('HKEYS', KEYS[2])) do
+      redis.call('ZREM', KEYS[3], cjson.encode({KEYS[2], k}))
+    end
+  end
+  if redis.call('TYPE', KEYS[1]).ok == 'hash' then
+    for _, k in ipairs(redis.call('HKEYS', KEYS[1])) do
+      local m = cjson.encode({KEYS[1], k})
+      local t = redis.call('ZSCORE', KEYS[3], m)
+      if t then
+        redis.call('ZREM', KEYS[3], m)
+        redis.call('ZADD', KEYS[3], t, cjson.encode({KEYS[2], k}))
+      end
+    end
+  end
+end
+redis.call('RENAME', KEYS[1], KEYS[2])
+return 1
+`
+
+func (r *redisBackend) Rename(key, newKey string) (int, error) {
+	return redis.Int(r.doRaw("EVAL", renameScript, 3, key, newKey, r.expiries))
+}
+
+func (r *redisBackend) Expire(key string, ttl time.Duration) (int, error) {
+	return redis.Int(r.doRaw("PEXPIRE", key, ttl.Milliseconds()))
+}
+
+// Hashes
+
+// hSetScript sets fields of KEYS[1] and removes their expiry times from the sorted set KEYS[2]
+// ARGV: field, value, ...
+const hSetScript = `
+local expiring = redis.call('EXISTS', KEYS[2]) == 1
+local added = 0
+for j = 1, #ARGV, 2 do
+  added = added + redis.call('HSET', KEYS[1], ARGV[j], ARGV[j + 1])
+  if expiring then redis.call('ZREM', KEYS[2], cjson.encode({KEYS[1], ARGV[j]})) end
+end
+return added
+`
+
+func (r *redisBackend) HSetMultiple(hash string, keyValuePairs map[string]string) (int, error) {
+	args := make([]interface{}, 2*len(keyValuePairs)+4)
+	args[0] = hSetScript
+	args[1] = 2
+	args[2] = hash
+	args[3] = r.expiries
+	idx := 4
+	for k, v := range keyValuePairs {
+		args[idx] = k
+		args[idx+1] = v
+		idx += 2
+	}
+	return redis.Int(r.doRaw("EVAL", args...))
+}
+
+func (r *redisBackend) HGet(hash, key string) (string, error) {
+	return redis.String(r.doRaw("HGET", hash, key))
+}
+
+// hDelScript deletes fields of KEYS[1] and removes their expiry times from the sorted set KEYS[2]
+// ARGV: field, ...
+const hDelScript = `
+local expiring = redis.call('EXISTS', KEYS[2]) == 1
+local removed = 0
+for j = 1, #ARGV do
+  removed = removed + redis.call('HDEL', KEYS[1], ARGV[j])
+  if expiring then redis.call('ZREM', KEYS[2], cjson.encode({KEYS[1], ARGV[j]})) end
+end
+return removed
+`
+
+func (r *redisBackend) HDelMultiple(hash string, keys []string) (int, error) {
+	args := make([]interface{}, len(keys)+4)
+	args[0] = hDelScript
+	args[1] = 2
+	args[2] = hash
+	args[3] = r.expiries
+	for i := range keys {
+		args[i+4] = keys[i]
+	}
+	return redis.Int(r.doRaw("EVAL", args...))
+}
+
+func (r *redisBackend) HMGet(hash string, keys []string) ([]string, error) {
+	args := make([]interface{}, len(keys)+1)
+	args[0] = hash
+	for i := range keys {
+		args[i+1] = keys[i]
+	}
+	return redis.Strings(r.doRaw("HMGET", args...))
+}
+
+func (r *redisBackend) HScan(hash string, cursor int, match string) (int, []string, error) {
+	var response []interface{}
+	var err error
+	if match != "" {
+		response, err = redis.Values(r.doRaw("HSCAN", hash, cursor, "MATCH", match, "COUNT", 1000)) // if we are filtering, increase count by quite a bit to compensate
+	} else {
+		response, err = redis.Values(r.doRaw("HSCAN", hash, cursor))
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	cursor, err = strconv.Atoi(string(response[0].([]byte)))
+	if err != nil {
+		return 0, nil, err
+	}
+	data := response[1].([]interface{})
+	ans := make([]string, len(data))
+	for i := range data {
+		ans[i] = string(data[i].([]byte))
+	}
+	return cursor, ans, nil
+}
+
+func (r *redisBackend) HGetAll(hash string) (map[string]string, error) {
+	return redis.StringMap(r.doRaw("HGETALL", hash))
+}
+
+func (r *redisBackend) HExists(hash string, key string) (int, error) {
+	return redis.Int(r.doRaw("HEXISTS", hash, key))
+}
+
+func (r *redisBackend) HKeys(hash string) ([]string, error) {
+	return redis.Strings(r.doRaw("HKEYS", hash))
+}
+
+// hUpdateScript applies a HashUpdate to KEYS[1] drawing new versions from the counter KEYS[2]
+// and recording the expiry times of fields in the sorted set KEYS[3]
+// ARGV: versionField, now, ttl, fieldTTL, #expected, expected..., clear, #removals, removals..., field, value, ...
+// returns {ok, removed, added, version}
+const hUpdateScript = `
+local f = ARGV[1]
+local now = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local fieldTTL = tonumber(ARGV[4])
+local expiring = fieldTTL > 0 or redis.call('EXISTS', KEYS[3]) == 1
+local function persist(k)
+  if expiring then redis.call('ZREM', KEYS[3], cjson.encode({KEYS[1], k})) end
+end
+local version = redis.call('HGET', KEYS[1], f) or '0'
+local i = 5
+local n = tonumber(ARGV[i])
+if n > 0 then
+  local match = false
+  for j = i + 1, i + n do
+    if ARGV[j] == version then match = true end
+  end
+  if not match then return {0, 0, 0, version} end
+end
+i = i + n + 1
+local removed = 0
+if ARGV[i] == '1' then
+  for _, k in ipairs(redis.call('HKEYS', KEYS[1])) do
+    if k ~= f then
+      removed = removed + redis.call('HDEL', KEYS[1], k)
+      persist(k)
+    end
+  end
+end
+i = i + 1
+n = tonumber(ARGV[i])
+for j = i + 1, i + n do
+  if ARGV[j] ~= f then
+    removed = removed + redis.call('HDEL', KEYS[1], ARGV[j])
+    persist(ARGV[j])
+  end
+end
+i = i + n + 1
+local added = 0
+for j = i, #ARGV, 2 do
+  added = added + redis.call('HSET', KEYS[1], ARGV[j], ARGV[j + 1])
+  if fieldTTL > 0 then
+    redis.call('ZADD', KEYS[3], now + fieldTTL, cjson.encode({KEYS[1], ARGV[j]}))
+  else
+    persist(ARGV[j])
+  end
+end
+if removed > 0 or i <= #ARGV then
+  local n = redis.call('HLEN', KEYS[1])
+  if n == 0 or (n == 1 and redis.call('HEXISTS', KEYS[1], f) == 1) then
+    redis.call('DEL', KEYS[1])
+    version = '0'
+  else
+    version = tostring(redis.call('INCR', KEYS[2]))
+    redis.call('HSET', KEYS[1], f, version)
+  end
+end
+if ttl > 0 then redis.call('PEXPIRE', KEYS[1], ttl) end
+return {1, removed, added, version}
+`
+
+// expireFieldScript deletes the field ARGV[2] of KEYS[2] if its expiry time recorded as ARGV[1]
+// in the sorted set KEYS[1] is not after ARGV[3], returns 1 if the field was deleted
+const expireFieldScript = `
+local t = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not t or tonumber(t) > tonumber(ARGV[3]) then return 0 end
+redis.call('ZREM', KEYS[1], ARGV[1])
+return redis.call('HDEL', KEYS[2], ARGV[2])
+`
+
+// expireFields deletes all the fields whose expiry time has passed
+func (r *redisBackend) expireFields() error {
+	for {
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		members, err := redis.Strings(r.doRaw("ZRANGEBYSCORE", r.expiries, "-inf", now, "LIMIT", 0, 100))
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			var e []string
+			if err := json.Unmarshal([]byte(m), &e); err != nil || len(e) != 2 {
+				logger.Error("invalid field expiry %s", m)
+				if _, err := r.doRaw("ZREM", r.expiries, m); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := r.doRaw("EVAL", expireFieldScript, 2, r.expiries, e[0], m, e[1], now); err != nil {
+				return err
+			}
+		}
+		if len(members) < 100 {
+			return nil
+		}
+	}
+}
+
+func (r *redisBackend) HUpdate(hash, versionField, counter string, update HashUpdate) (HashUpdateResult, error) {
+	args := []interface{}{hUpdateScript, 3, hash, counter, r.expiries, versionField,
+		time.Now().UnixNano() / int64(time.Millisecond), update.TTL.Milliseconds(), update.FieldTTL.Milliseconds(), len(update.Expected)}
+	for _, v := range update.Expected {
+		args = append(args, v)
+	}
+	if update.Clear {
+		args = append(args, "1")
+	} else {
+		args = append(args, "0")
+	}
+	args = append(args, len(update.Removals))
+	for _, k := range update.Removals {
+		args = append(args, k)
+	}
+	for k, v := range update.Updates {
+		args = append(args, k, v)
+	}
+	reply, err := redis.Values(r.doRaw("EVAL", args...))
+	if err != nil {
+		return HashUpdateResult{}, err
+	}
+	var ok int
+	var result HashUpdateResult
+	if _, err := redis.Scan(reply, &ok, &result.Removed, &result.Added, &result.Version); err != nil {
+		return HashUpdateResult{}, err
+	}
+	if ok == 0 {
+		return result, ErrConflict
+	}
+	return result, nil
+}
+
+// Sorted sets
+
+func (r *redisBackend) ZAdd(key string, score int64, value string) (int, error) {
+	return redis.Int(r.doRaw("ZADD", key, score, value))
+}
+
+func (r *redisBackend) ZRange(key string, start, stop int) ([]string, error) {
+	return redis.Strings(r.doRaw("ZRANGE", key, start, stop))
+}
+
+func (r *redisBackend) ZRemRangeByScore(key string, min, max int64) (int, error) {
+	return redis.Int(r.doRaw("ZREMRANGEBYSCORE", key, min, max))
+}
+
+// zPopByScoreScript removes and returns at most ARGV[2] elements of KEYS[1] with scores up to ARGV[1]
+const zPopByScoreScript = `
+local values = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+if #values > 0 then
+  redis.call('ZREM', KEYS[1], unpack(values))
+end
+return values
+`
+
+func (r *redisBackend) ZPopByScore(key string, max int64, count int) ([]string, error) {
+	return redis.Strings(r.doRaw("EVAL", zPopByScoreScript, 1, key, max, count))
+}
+
+// Dial connects to Redis.
+func (r *redisBackend) Dial() error {
+	redisOptions := []redis.DialOption{}
+
+	if config.RedisEnableTLS {
+		redisOptions = append(redisOptions, redis.DialUseTLS(true))
+		if config.RedisCA != nil {
+			roots := x509.NewCertPool()
+			roots.AddCert(config.RedisCA)
+			redisOptions = append(redisOptions, redis.DialTLSConfig(&tls.Config{RootCAs: roots}))
+		}
+		if config.RedisTLSSkipVerify {
+			redisOptions = append(redisOptions, redis.DialTLSSkipVerify(true))
+		}
+	}
+	if config.RedisPassword != "" {
+		redisOptions = append(redisOptions, redis.DialPassword(config.RedisPassword))
+	}
+	if config.RequestRetryLimit >= 0 {
+		redisOptions = append(redisOptions, redis.DialConnectTimeout(config.RequestRetryLimit))
+		redisOptions = append(redisOptions, redis.DialReadTimeout(config.RequestRetryLimit))
+		redisOptions = append(redisOptions, redis.DialWriteTimeout(config.RequestRetryLimit))
+	}
+
+	address := net.JoinHostPort(config.RedisHost, strconv.Itoa(config.RedisPort))
+
+	r.pool = &redis.Pool{
+		MaxIdle:     3,
+		MaxActive:   16,
+		IdleTimeout: 240 * time.Second,
+		Wait:        true,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", address, redisOptions...)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	conn := r.pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		return err
+	}
+	if config.CmdName != config.RunCmd { // only sidecars delete expired fields
+		return nil
+	}
+	r.done = make(chan struct{})
+	r.sweeper.Add(1)
+	go func() {
+		defer r.sweeper.Done()
+		ticker := time.NewTicker(fieldExpiryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.expireFields(); err != nil {
+					logger.Error("failed to delete expired hash fields: %v", err)
+				}
+			case <-r.done:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Close stops the periodic field expiry and terminates the connection pool.
+func (r *redisBackend) Close() error {
+	if r.done != nil {
+		close(r.done)
+		r.sweeper.Wait()
+	}
+	return r.pool.Close()
+}
@@ -18,24 +18,132 @@
 package store
 
 import (
-	"crypto/tls"
-	"crypto/x509"
-	"net"
-	"strconv"
+	"errors"
 	"strings"
 	"time"
 
 	"github.com/IBM/kar.git/core/internal/config"
-	"github.com/IBM/kar.git/core/pkg/logger"
 	"github.com/gomodule/redigo/redis"
 )
 
+// Backend is the state backend underlying the store API
+//
+// Keys and patterns passed to a backend are already mangled.
+// Patterns follow the Redis glob-style syntax.
+// Backends must return ErrNil when a key or field does not exist.
+type Backend interface {
+	// Dial connects to the backend
+	Dial() error
+
+	// Close disconnects from the backend
+	Close() error
+
+	// Set sets the value associated with a key
+	Set(key, value string) (string, error)
+
+	// SetWithTTL sets the value associated with a key and its time to live
+	SetWithTTL(key, value string, ttl time.Duration) (string, error)
+
+	// Get returns the value associated with a key
+	Get(key string) (string, error)
+
+	// Del deletes a key
+	Del(key string) (int, error)
+
+	// CompareAndSet atomically updates the value associated with a key
+	CompareAndSet(key string, expected, value *string) (int, error)
+
+	// Keys returns all keys that match the pattern
+	Keys(pattern string) ([]string, error)
+
+	// Purge deletes all keys that match the pattern
+	Purge(pattern string) (int, error)
+
+	// Rename atomically renames a key replacing the new key if any, returns 1 if the key exists, 0 otherwise
+	Rename(key, newKey string) (int, error)
+
+	// Expire sets the time to live of a key and returns 1 if the key exists, 0 otherwise
+	Expire(key string, ttl time.Duration) (int, error)
+
+	// HSetMultiple sets fields of a hash, removing their time to live, and returns the number of fields added
+	HSetMultiple(hash string, keyValuePairs map[string]string) (int, error)
+
+	// HGet returns a field of a hash
+	HGet(hash, key string) (string, error)
+
+	// HDelMultiple deletes fields of a hash and returns the number of fields removed
+	HDelMultiple(hash string, keys []string) (int, error)
+
+	// HMGet returns multiple fields of a hash
+	HMGet(hash string, keys []string) ([]string, error)
+
+	// HScan iterates over the fields of a hash that match the pattern
+	HScan(hash string, cursor int, match string) (int, []string, error)
+
+	// HGetAll returns all the fields of a hash
+	HGetAll(hash string) (map[string]string, error)
+
+	// HExists returns 1 if the hash has the field, 0 otherwise
+	HExists(hash string, key string) (int, error)
+
+	// HKeys returns the field names of a hash
+	HKeys(hash string) ([]string, error)
+
+	// HUpdate atomically applies an update to a versioned hash, drawing new versions from the counter key
+	HUpdate(hash, versionField, counter string, update HashUpdate) (HashUpdateResult, error)
+
+	// ZAdd adds an element to a sorted set
+	ZAdd(key string, score int64, value string) (int, error)
+
+	// ZRange returns a range of elements from a sorted set
+	ZRange(key string, start, stop int) ([]string, error)
+
+	// ZRemRangeByScore removes elements by scores from a sorted set
+	ZRemRangeByScore(key string, min, max int64) (int, error)
+
+	// ZPopByScore atomically removes and returns the elements with the lowest scores up to max
+	ZPopByScore(key string, max int64, count int) ([]string, error)
+}
+
+// HashUpdate describes an atomic update of a versioned hash
+//
+// Removals are applied first, then updates. If the hash is modified, its version
+// field is set to a new version drawn from a counter shared by all versioned hashes,
+// so versions are never reused even if the hash is deleted. A hash left with
+// no field other than the version field is deleted. The version of a hash
+// without version field is 0. Setting a field without FieldTTL removes its
+// time to live. Expired fields are deleted without changing the version, a hash
+// left with only its version field is kept.
+type HashUpdate struct {
+	Expected []string          // acceptable current versions, any version if empty
+	Clear    bool              // remove all fields
+	Removals []string          // fields to remove
+	Updates  map[string]string // fields to set
+	TTL      time.Duration     // time to live of the hash, unchanged if 0
+	FieldTTL time.Duration     // time to live of the updated fields, none if 0
+}
+
+// HashUpdateResult is the outcome of a HashUpdate
+type HashUpdateResult struct {
+	Removed int    // number of fields removed
+	Added   int    // number of fields added
+	Version string // version of the hash after the update
+}
+
+const (
+	versionCounterKey = "hashversions"  // counter new hash versions are drawn from
+	fieldExpiriesKey  = "fieldexpiries" // expiry times of hash fields (Redis only)
+)
+
 var (
 	// ErrNil indicates that a reply value is nil.
 	ErrNil = redis.ErrNil
 
-	// connection pool
-	pool *redis.Pool
+	// ErrConflict indicates that the version of a hash is not the expected version.
+	ErrConflict = errors.New("version conflict")
+
+	// backend selected at Dial time
+	backend Backend
 )
 
 // mangle add common prefix to all keys
@@ -52,74 +160,38 @@ func unmangle(key string) string {
 	return key
 }
 
-// send a command while holding the connection mutex
-func doRaw(command string, args ...interface{}) (reply interface{}, err error) {
-	opStart := time.Now()
-	conn := pool.Get()
-	defer conn.Close()
-	start := time.Now()
-	reply, err = conn.Do(command, args...)
-	last := time.Now()
-	elapsed := last.Sub(opStart)
-	connElapsed := last.Sub(start)
-	if elapsed > config.LongRedisOperation {
-		logger.Error("Slow Redis operation: %v total seconds (%v in conn.Do). Command was %v %v", elapsed.Seconds(), connElapsed.Seconds(), command, args[0])
-	}
-	if err != nil {
-		logger.Error("failed to send command to redis: %v", err)
-	}
-	return
-}
-
-// mangle the key before sending the command (assuming args[0] is the key)
-func do(command string, args ...interface{}) (interface{}, error) {
-	args[0] = mangle(args[0].(string))
-	return doRaw(command, args...)
-}
-
 // Keys
 
 // Set sets the value associated with a key.
 func Set(key, value string) (string, error) {
-	return redis.String(do("SET", key, value))
+	return backend.Set(mangle(key), value)
+}
+
+// SetWithTTL sets the value associated with a key and its time to live.
+func SetWithTTL(key, value string, ttl time.Duration) (string, error) {
+	return backend.SetWithTTL(mangle(key), value, ttl)
 }
 
 // Get returns the value associated with a key.
 func Get(key string) (string, error) {
-	return redis.String(do("GET", key))
+	return backend.Get(mangle(key))
 }
 
 // Del deletes the value associated with a key.
 func Del(key string) (int, error) {
-	return redis.Int(do("DEL", key))
+	return backend.Del(mangle(key))
 }
 
 // CompareAndSet sets the value associated with a key if its current value is
 // equal to the expected value. Use nil values to create or delete the key.
 // Returns 0 if unsuccessful, 1 if successful.
 func CompareAndSet(key string, expected, value *string) (int, error) {
-	if expected == nil && value == nil {
-		_, err := redis.String(do("GET", key))
-		if err != nil {
-			if err == ErrNil {
-				return 1, nil
-			}
-			return 0, err
-		}
-		return 0, nil
-	}
-	if expected == nil {
-		return redis.Int(do("SETNX", key, *value))
-	}
-	if value == nil {
-		return redis.Int(doRaw("EVAL", "if redis.call('GET', KEYS[1]) == ARGV[1] then redis.call('DEL', KEYS[1]); return 1 else return 0 end", 1, mangle(key), *expected))
-	}
-	return redis.Int(doRaw("EVAL", "if redis.call('GET', KEYS[1]) == ARGV[1] then redis.call('SET', KEYS[1], ARGV[2]); return 1 else return 0 end", 1, mangle(key), *expected, *value))
+	return backend.CompareAndSet(mangle(key), expected, value)
 }
 
 // Keys returns all keys that match the argument pattern
 func Keys(pattern string) ([]string, error) {
-	mangledKeys, err := redis.Strings(do("KEYS", pattern))
+	mangledKeys, err := backend.Keys(mangle(pattern))
 	if err == nil {
 		for idx, val := range mangledKeys {
 			mangledKeys[idx] = unmangle(val)
@@ -130,203 +202,127 @@ func Keys(pattern string) ([]string, error) {
 
 // Purge deletes all keys that match the argument pattern
 func Purge(pattern string) (int, error) {
-	pattern = mangle(pattern)
-	bags := [][]interface{}{}
-	cursor := 0
-	for {
-		reply, err := redis.Values(doRaw("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
-		if err != nil {
-			return 0, err
-		}
-		cursor, _ = strconv.Atoi(string(reply[0].([]byte)))
-		keys := reply[1].([]interface{})
-		if len(keys) > 0 {
-			bags = append(bags, keys)
-		}
-		if cursor == 0 {
-			break
-		}
-	}
-	count := 0
-	for _, keys := range bags {
-		n, err := redis.Int(doRaw("DEL", keys...))
-		count += n
-		if err != nil {
-			return count, err
-		}
-	}
-	return count, nil
+	return backend.Purge(mangle(pattern))
+}
+
+// Rename atomically renames a key, replacing the new key if it exists.
+// Returns 1 if the key exists, 0 otherwise.
+func Rename(key, newKey string) (int, error) {
+	return backend.Rename(mangle(key), mangle(newKey))
+}
+
+// Expire sets the time to live of a key.
+// Returns 1 if the key exists, 0 otherwise.
+func Expire(key string, ttl time.Duration) (int, error) {
+	return backend.Expire(mangle(key), ttl)
 }
 
 // Hashes
 
 // HSet hash key value
 func HSet(hash, key, value string) (int, error) {
-	return redis.Int(do("HSET", hash, key, value))
+	return backend.HSetMultiple(mangle(hash), map[string]string{key: value})
 }
 
 // HSet2 hash key1 value1 key2 value2
 func HSet2(hash, key1, value1, key2, value2 string) (int, error) {
-	return redis.Int(do("HSET", hash, key1, value1, key2, value2))
+	return backend.HSetMultiple(mangle(hash), map[string]string{key1: value1, key2: value2})
 }
 
 // HSet3 hash key1 value1 key2 value2 key3 value3
 func HSet3(hash, key1, value1, key2, value2, key3, value3 string) (int, error) {
-	return redis.Int(do("HSET", hash, key1, value1, key2, value2, key3, value3))
+	return backend.HSetMultiple(mangle(hash), map[string]string{key1: value1, key2: value2, key3: value3})
 }
 
 // HSetMultiple hash map[string]string does an HSET of the entire map
 func HSetMultiple(hash string, keyValuePairs map[string]string) (int, error) {
-	nPairs := len(keyValuePairs)
-	if nPairs == 0 {
+	if len(keyValuePairs) == 0 {
 		return 0, nil
 	}
-	args := make([]interface{}, 2*nPairs+1)
-	args[0] = hash
-	idx := 1
-	for k, v := range keyValuePairs {
-		args[idx] = k
-		args[idx+1] = v
-		idx += 2
-	}
-	return redis.Int(do("HSET", args...))
+	return backend.HSetMultiple(mangle(hash), keyValuePairs)
 }
 
 // HGet hash key
 func HGet(hash, key string) (string, error) {
-	return redis.String(do("HGET", hash, key))
+	return backend.HGet(mangle(hash), key)
 }
 
 // HDel hash key
 func HDel(hash, key string) (int, error) {
-	return redis.Int(do("HDEL", hash, key))
+	return backend.HDelMultiple(mangle(hash), []string{key})
 }
 
 //HDelMultiple hash key[]
 func HDelMultiple(hash string, keys []string) (int, error) {
-	args := make([]interface{}, len(keys)+1)
-	args[0] = hash
-	for i := range keys {
-		args[i+1] = keys[i]
-	}
-	return redis.Int(do("HDEL", args...))
+	return backend.HDelMultiple(mangle(hash), keys)
 }
 
 // HMGet hash key[]
 func HMGet(hash string, keys []string) ([]string, error) {
-	args := make([]interface{}, len(keys)+1)
-	args[0] = hash
-	for i := range keys {
-		args[i+1] = keys[i]
-	}
-	return redis.Strings(do("HMGET", args...))
+	return backend.HMGet(mangle(hash), keys)
 }
 
 // HScan hash cursor [MATCH match]
 func HScan(hash string, cursor int, match string) (int, []string, error) {
-	var response []interface{}
-	var err error
-	if match != "" {
-		response, err = redis.Values(do("HSCAN", hash, cursor, "MATCH", match, "COUNT", 1000)) // if we are filtering, increase count by quite a bit to compensate
-	} else {
-		response, err = redis.Values(do("HSCAN", hash, cursor))
-	}
-	if err != nil {
-		return 0, nil, err
-	}
-	cursor, err = strconv.Atoi(string(response[0].([]byte)))
-	if err != nil {
-		return 0, nil, err
-	}
-	data := response[1].([]interface{})
-	ans := make([]string, len(data))
-	for i := range data {
-		ans[i] = string(data[i].([]byte))
-	}
-	return cursor, ans, nil
+	return backend.HScan(mangle(hash), cursor, match)
 }
 
 // HGetAll hash
 func HGetAll(hash string) (map[string]string, error) {
-	return redis.StringMap(do("HGETALL", hash))
+	return backend.HGetAll(mangle(hash))
 }
 
 // HExists hash key
 func HExists(hash string, key string) (int, error) {
-	return redis.Int(do("HEXISTS", hash, key))
+	return backend.HExists(mangle(hash), key)
 }
 
 // HKeys hash key
 func HKeys(hash string) ([]string, error) {
-	return redis.Strings(do("HKEYS", hash))
+	return backend.HKeys(mangle(hash))
+}
+
+// HUpdate atomically applies an update to a hash versioned by versionField.
+// Returns ErrConflict and the current version if the version is not expected.
+func HUpdate(hash, versionField string, update HashUpdate) (HashUpdateResult, error) {
+	return backend.HUpdate(mangle(hash), versionField, mangle(versionCounterKey), update)
 }
 
 // Sorted sets
 
 // ZAdd adds an element to a sorted set.
 func ZAdd(key string, score int64, value string) (int, error) {
-	return redis.Int(do("ZADD", key, score, value))
+	return backend.ZAdd(mangle(key), score, value)
 }
 
 // ZRange returns a range of elements from a sorted set.
 func ZRange(key string, start, stop int) ([]string, error) {
-	return redis.Strings(do("ZRANGE", key, start, stop))
+	return backend.ZRange(mangle(key), start, stop)
 }
 
 // ZRemRangeByScore removes elements by scores from a sorted set.
 func ZRemRangeByScore(key string, min, max int64) (int, error) {
-	return redis.Int(do("ZREMRANGEBYSCORE", key, min, max))
+	return backend.ZRemRangeByScore(mangle(key), min, max)
 }
 
-// Dial connects to Redis.
-func Dial() error {
-	redisOptions := []redis.DialOption{}
-
-	if config.RedisEnableTLS {
-		redisOptions = append(redisOptions, redis.DialUseTLS(true))
-		if config.RedisCA != nil {
-			roots := x509.NewCertPool()
-			roots.AddCert(config.RedisCA)
-			redisOptions = append(redisOptions, redis.DialTLSConfig(&tls.Config{RootCAs: roots}))
-		}
-		if config.RedisTLSSkipVerify {
-			redisOptions = append(redisOptions, redis.DialTLSSkipVerify(true))
-		}
-	}
-	if config.RedisPassword != "" {
-		redisOptions = append(redisOptions, redis.DialPassword(config.RedisPassword))
-	}
-	if config.RequestRetryLimit >= 0 {
-		redisOptions = append(redisOptions, redis.DialConnectTimeout(config.RequestRetryLimit))
-		redisOptions = append(redisOptions, redis.DialReadTimeout(config.RequestRetryLimit))
-		redisOptions = append(redisOptions, redis.DialWriteTimeout(config.RequestRetryLimit))
-	}
+// ZPopByScore atomically removes and returns at most count elements with scores up to max
+// from a sorted set, lowest scores first.
+func ZPopByScore(key string, max int64, count int) ([]string, error) {
+	return backend.ZPopByScore(mangle(key), max, count)
+}
 
-	address := net.JoinHostPort(config.RedisHost, strconv.Itoa(config.RedisPort))
-
-	pool = &redis.Pool{
-		MaxIdle:     3,
-		MaxActive:   16,
-		IdleTimeout: 240 * time.Second,
-		Wait:        true,
-		Dial: func() (redis.Conn, error) {
-			return redis.Dial("tcp", address, redisOptions...)
-		},
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			if time.Since(t) < time.Minute {
-				return nil
-			}
-			_, err := c.Do("PING")
-			return err
-		},
+// Dial selects and connects the backend specified in the configuration.
+func Dial() error {
+	switch config.Store {
+	case config.StoreMemory:
+		backend = newMemoryBackend(config.StorePath, config.StoreSaveInterval)
+	default:
+		backend = &redisBackend{expiries: mangle(fieldExpiriesKey)}
 	}
-	conn := pool.Get()
-	defer conn.Close()
-	_, err := conn.Do("PING")
-	return err
+	return backend.Dial()
 }
 
-// Close terminates the connection pool.
+// Close disconnects from the backend.
 func Close() error {
-	return pool.Close()
+	return backend.Close()
 }
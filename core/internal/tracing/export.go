@@ -0,0 +1,257 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/kar.git/core/pkg/logger"
+)
+
+const (
+	batchSize     = 512         // maximum number of spans per export request
+	queueSize     = 4096        // maximum number of spans waiting for export
+	batchInterval = time.Second // maximum delay before exporting a span
+)
+
+// exporter batches ended spans and writes them to an OTLP/HTTP endpoint or a file
+type exporter struct {
+	mu       sync.RWMutex // guards closed and sends to queue
+	closed   bool
+	queue    chan *Span
+	done     chan struct{}
+	resource []keyValue
+	endpoint string   // OTLP/HTTP traces endpoint
+	file     *os.File // file exporter
+	client   http.Client
+}
+
+var (
+	exp   *exporter // active exporter, nil if tracing is disabled
+	expMu sync.RWMutex
+)
+
+// active returns the active exporter, nil if tracing is disabled
+func active() *exporter {
+	expMu.RLock()
+	defer expMu.RUnlock()
+	return exp
+}
+
+// Init enables span export to an OTLP/HTTP endpoint or to a file
+// resource attributes are attached to all exported spans
+func Init(endpoint, path string, resource map[string]string) error {
+	e := &exporter{
+		queue:    make(chan *Span, queueSize),
+		done:     make(chan struct{}),
+		endpoint: endpoint,
+		client:   http.Client{Timeout: 10 * time.Second},
+		resource: attributes(resource),
+	}
+	if path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		e.file = f
+	}
+	go e.run()
+	expMu.Lock()
+	exp = e
+	expMu.Unlock()
+	return nil
+}
+
+// Close flushes pending spans and stops the exporter
+// spans ended after Close are dropped
+func Close() {
+	expMu.Lock()
+	e := exp
+	exp = nil
+	expMu.Unlock()
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	e.closed = true
+	close(e.queue)
+	e.mu.Unlock()
+	<-e.done
+	if e.file != nil {
+		e.file.Close()
+	}
+}
+
+func (e *exporter) enqueue(s *Span) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- s:
+	default:
+		logger.Debug("dropping span %s: export queue is full", s.name)
+	}
+}
+
+func (e *exporter) run() {
+	defer close(e.done)
+	batch := make([]*Span, 0, batchSize)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case s, ok := <-e.queue:
+			if !ok {
+				e.export(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		e.export(batch)
+		batch = batch[:0]
+	}
+}
+
+// OTLP/JSON encoding of an export request
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 0 unset, 2 error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	TraceState        string     `json:"traceState,omitempty"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+type scopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type resourceSpans struct {
+	Resource struct {
+		Attributes []keyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+func attributes(m map[string]string) []keyValue {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kvs := make([]keyValue, len(keys))
+	for i, k := range keys {
+		kvs[i] = keyValue{Key: k, Value: anyValue{StringValue: m[k]}}
+	}
+	return kvs
+}
+
+func (e *exporter) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	spans := make([]otlpSpan, len(batch))
+	for i, s := range batch {
+		spans[i] = otlpSpan{
+			TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+			TraceState:        s.context.TraceState,
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        attributes(s.attributes),
+		}
+		if s.parent != [8]byte{} {
+			spans[i].ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		if s.err != "" {
+			spans[i].Status = otlpStatus{Code: 2, Message: s.err}
+		}
+	}
+	rs := resourceSpans{ScopeSpans: []scopeSpans{{Spans: spans}}}
+	rs.Resource.Attributes = e.resource
+	rs.ScopeSpans[0].Scope.Name = "kar"
+	buf, err := json.Marshal(exportRequest{ResourceSpans: []resourceSpans{rs}})
+	if err != nil {
+		logger.Error("failed to encode spans: %v", err)
+		return
+	}
+	if e.file != nil {
+		if _, err := e.file.Write(append(buf, '\n')); err != nil {
+			logger.Error("failed to write spans: %v", err)
+		}
+	}
+	if e.endpoint != "" {
+		if err := e.post(buf); err != nil {
+			logger.Error("failed to export %d span(s) to %s: %v", len(batch), e.endpoint, err)
+		}
+	}
+}
+
+func (e *exporter) post(buf []byte) error {
+	res, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("status %v", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package tracing
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestExportToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.json")
+	if err := Init("", path, map[string]string{"service.name": "test"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, parent := Start(context.Background(), "parent", KindServer)
+	_, child := Start(ctx, "child", KindClient)
+	child.SetAttribute("k", "v")
+	child.End()
+	parent.End()
+	Close()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans := map[string]otlpSpan{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var req exportRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range req.ResourceSpans[0].ScopeSpans[0].Spans {
+			spans[s.Name] = s
+		}
+	}
+	if len(spans) != 2 {
+		t.Fatalf("exported %d span(s), want 2", len(spans))
+	}
+	if spans["child"].ParentSpanID != spans["parent"].SpanID || spans["child"].TraceID != spans["parent"].TraceID {
+		t.Errorf("child span %+v is not a child of %+v", spans["child"], spans["parent"])
+	}
+}
+
+func TestCloseWhileEnding(t *testing.T) {
+	if err := Init("", filepath.Join(t.TempDir(), "spans.json"), nil); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				_, s := Start(context.Background(), "span", KindInternal)
+				s.End()
+			}
+		}()
+	}
+	Close()
+	wg.Wait()
+	Close() // closing twice is harmless
+}
@@ -0,0 +1,192 @@
+//
+// Copyright IBM Corporation 2020,2021
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+// Package tracing implements W3C trace context propagation and span export.
+//
+// Spans are exported in the OTLP/JSON encoding, either to an OTLP/HTTP
+// endpoint or to a file with one export request per line.
+// If no exporter is configured, trace context is propagated unchanged.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext is the W3C trace context of a span
+type SpanContext struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Flags      byte
+	TraceState string
+}
+
+// IsValid returns true if the trace and span ids are not all zeros
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Sampled returns true if the sampled flag is set
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&1 == 1
+}
+
+// Traceparent formats the span context as a traceparent header value
+func (sc SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), sc.Flags)
+}
+
+// ParseTraceparent parses a traceparent header value
+func ParseTraceparent(traceparent, tracestate string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, false
+	}
+	sc.Flags = flags[0]
+	sc.TraceState = tracestate
+	return sc, sc.IsValid()
+}
+
+// span kinds (OTLP values)
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+	KindProducer = 4
+	KindConsumer = 5
+)
+
+// Span is a timed operation
+type Span struct {
+	context    SpanContext
+	parent     [8]byte
+	name       string
+	kind       int
+	start, end time.Time
+	lock       sync.Mutex
+	attributes map[string]string
+	err        string
+	recording  bool
+	exp        *exporter
+}
+
+type contextKey struct{}
+
+// FromContext returns the span context carried by ctx if any
+func FromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(contextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// Extract returns a context carrying the trace context from a traceparent and tracestate
+// ctx is returned unchanged if the traceparent is missing or invalid
+func Extract(ctx context.Context, traceparent, tracestate string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	sc, ok := ParseTraceparent(traceparent, tracestate)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// Inject adds the trace context carried by ctx to a message
+// an existing trace context in the message is preserved
+func Inject(ctx context.Context, msg map[string]string) {
+	if msg["traceparent"] != "" {
+		return
+	}
+	if sc, ok := FromContext(ctx); ok {
+		msg["traceparent"] = sc.Traceparent()
+		if sc.TraceState != "" {
+			msg["tracestate"] = sc.TraceState
+		}
+	}
+}
+
+// Start starts a span that is a child of the span context carried by ctx if any
+// and returns a context carrying the new span context
+// Start returns ctx unchanged and a no-op span if tracing is disabled
+func Start(ctx context.Context, name string, kind int) (context.Context, *Span) {
+	e := active()
+	if e == nil {
+		return ctx, &Span{}
+	}
+	parent, ok := FromContext(ctx)
+	s := &Span{name: name, kind: kind, start: time.Now(), attributes: map[string]string{}, exp: e}
+	if ok {
+		s.context.TraceID = parent.TraceID
+		s.context.Flags = parent.Flags
+		s.context.TraceState = parent.TraceState
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.context.TraceID[:])
+		s.context.Flags = 1 // sampled
+	}
+	rand.Read(s.context.SpanID[:])
+	s.recording = s.context.Sampled()
+	return context.WithValue(ctx, contextKey{}, s.context), s
+}
+
+// SetAttribute sets an attribute of the span
+func (s *Span) SetAttribute(key, value string) {
+	s.lock.Lock()
+	if s.recording {
+		s.attributes[key] = value
+	}
+	s.lock.Unlock()
+}
+
+// SetError records an error on the span
+func (s *Span) SetError(err error) {
+	s.lock.Lock()
+	if s.recording && err != nil {
+		s.err = err.Error()
+	}
+	s.lock.Unlock()
+}
+
+// End ends the span and queues it for export
+func (s *Span) End() {
+	s.lock.Lock()
+	recording := s.recording
+	s.end = time.Now()
+	s.recording = false
+	s.lock.Unlock()
+	if recording {
+		s.exp.enqueue(s)
+	}
+}
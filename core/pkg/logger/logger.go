@@ -17,16 +17,27 @@
 // Package logger supports leveled logging on top of the standard log package.
 //
 // Example:
-//     logger.SetVerbosity("warning")
+//     logger.SetVerbosity("warning,pubsub=debug")
 //     logger.Error("invalid value: %v", value)
+//     logger.With(logger.Fields{"actorType": t}).Info("activated")
+//
+// The subsystem of a log message is the name of the package emitting it.
+// Verbosity may be set per subsystem.
+// Fields attached to log messages are only output in JSON format.
 //
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -45,37 +56,209 @@ var severity = []string{
 	debugLog:   "DEBUG",
 }
 
-var verbosity = errorLog
+// Fields are key-value pairs attached to log messages
+type Fields map[string]string
+
+var (
+	lock         sync.RWMutex
+	verbosity    = errorLog
+	levels       = map[string]int{} // subsystem -> verbosity
+	maxVerbosity = errorLog         // max of verbosity and subsystem levels
+	jsonFormat   = false
+	fields       = Fields{} // fields attached to all messages in JSON format
+
+	subsystems = sync.Map{} // pc -> subsystem
+)
 
 func init() {
 	log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
 }
 
-// SetVerbosity sets the verbosity of the log.
-func SetVerbosity(s string) error {
-	s = strings.ToUpper(s)
+// parse a level name or number
+func parseLevel(s string) (int, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
 	for i, name := range severity {
 		if s == name {
-			verbosity = i
-			return nil
+			return i, nil
+		}
+	}
+	return strconv.Atoi(s)
+}
+
+// SetVerbosity sets the verbosity of the log.
+//
+// The argument is a comma-separated list of a default level
+// and SUBSYSTEM=LEVEL pairs, for instance "info,pubsub=debug".
+// Subsystems not listed keep their current level, use SUBSYSTEM=default
+// to revert a subsystem to the default level.
+func SetVerbosity(s string) error {
+	def := -1
+	updates := map[string]int{}
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) == 1 {
+			i, err := parseLevel(parts[0])
+			if err != nil {
+				return fmt.Errorf("invalid level %q", parts[0])
+			}
+			def = i
+			continue
+		}
+		name := strings.TrimSpace(parts[0])
+		if name == "" {
+			return fmt.Errorf("invalid subsystem in %q", entry)
+		}
+		if strings.EqualFold(strings.TrimSpace(parts[1]), "default") {
+			updates[name] = -1
+			continue
+		}
+		i, err := parseLevel(parts[1])
+		if err != nil {
+			return fmt.Errorf("invalid level %q for subsystem %s", parts[1], name)
 		}
+		updates[name] = i
 	}
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		return err
+	lock.Lock()
+	defer lock.Unlock()
+	if def >= 0 {
+		verbosity = def
+	}
+	for name, i := range updates {
+		if i < 0 {
+			delete(levels, name)
+		} else {
+			levels[name] = i
+		}
+	}
+	maxVerbosity = verbosity
+	for _, i := range levels {
+		if i > maxVerbosity {
+			maxVerbosity = i
+		}
 	}
-	verbosity = i
 	return nil
 }
 
+// Verbosity returns the current verbosity in the format accepted by SetVerbosity.
+func Verbosity() string {
+	lock.RLock()
+	defer lock.RUnlock()
+	entries := []string{levelName(verbosity)}
+	names := make([]string, 0, len(levels))
+	for name := range levels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		entries = append(entries, name+"="+levelName(levels[name]))
+	}
+	return strings.Join(entries, ",")
+}
+
+func levelName(i int) string {
+	if i >= 0 && i < len(severity) {
+		return strings.ToLower(severity[i])
+	}
+	return strconv.Itoa(i)
+}
+
+// SetJSON selects the JSON output format and the fields attached to all messages.
+func SetJSON(enabled bool, f Fields) {
+	lock.Lock()
+	defer lock.Unlock()
+	jsonFormat = enabled
+	fields = Fields{}
+	for k, v := range f {
+		fields[k] = v
+	}
+	if enabled {
+		log.SetFlags(0)
+	} else {
+		log.SetFlags(log.Ldate | log.Ltime | log.Lmicroseconds)
+	}
+}
+
+// subsystem returns the name of the package of the function calling the logger
+// skip is the number of stack frames to skip above the caller of subsystem
+func subsystem(skip int) string {
+	pcs := [1]uintptr{}
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return ""
+	}
+	if s, ok := subsystems.Load(pcs[0]); ok {
+		return s.(string)
+	}
+	name := ""
+	if f := runtime.FuncForPC(pcs[0]); f != nil {
+		name = f.Name() // e.g. github.com/IBM/kar.git/core/internal/pubsub.(*handler).Setup
+		name = name[strings.LastIndex(name, "/")+1:]
+		if i := strings.Index(name, "."); i != -1 {
+			name = name[:i]
+		}
+	}
+	subsystems.Store(pcs[0], name)
+	return name
+}
+
+// logf outputs a message if enabled for the subsystem of the caller
+// the fields of the entry if any are only computed and output in JSON format
+// logf must be called directly from the exported logging functions
+func logf(level int, e *Entry, format string, args ...interface{}) {
+	lock.RLock()
+	def, max, overrides, jf := verbosity, maxVerbosity, len(levels), jsonFormat
+	lock.RUnlock()
+	if level > max {
+		return
+	}
+	sub := ""
+	if overrides > 0 || jf {
+		sub = subsystem(2)
+		if overrides > 0 {
+			lock.RLock()
+			if i, ok := levels[sub]; ok {
+				def = i
+			}
+			lock.RUnlock()
+		}
+	}
+	if level > def && level != fatalLog {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	if !jf {
+		log.Print("[" + severity[level] + "] " + msg)
+		return
+	}
+	entry := map[string]string{}
+	lock.RLock()
+	for k, v := range fields {
+		entry[k] = v
+	}
+	lock.RUnlock()
+	if e != nil {
+		for k, v := range e.get() {
+			if v != "" {
+				entry[k] = v
+			}
+		}
+	}
+	entry["level"] = strings.ToLower(severity[level])
+	entry["timestamp"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["subsystem"] = sub
+	entry["msg"] = msg
+	buf, _ := json.Marshal(entry)
+	log.Print(string(buf))
+}
+
 // Debug outputs a formatted log message.
 func Debug(format string, args ...interface{}) {
 	if false {
 		_ = fmt.Sprintf(format, args...)
 	}
-	if verbosity >= debugLog {
-		log.Printf("[DEBUG] "+format, args...)
-	}
+	logf(debugLog, nil, format, args...)
 }
 
 // Info outputs a formatted log message.
@@ -83,9 +266,7 @@ func Info(format string, args ...interface{}) {
 	if false {
 		_ = fmt.Sprintf(format, args...)
 	}
-	if verbosity >= infoLog {
-		log.Printf("[INFO] "+format, args...)
-	}
+	logf(infoLog, nil, format, args...)
 }
 
 // Warning outputs a formatted warning message.
@@ -93,9 +274,7 @@ func Warning(format string, args ...interface{}) {
 	if false {
 		_ = fmt.Sprintf(format, args...)
 	}
-	if verbosity >= warningLog {
-		log.Printf("[WARNING] "+format, args...)
-	}
+	logf(warningLog, nil, format, args...)
 }
 
 // Error outputs a formatted error message.
@@ -103,9 +282,7 @@ func Error(format string, args ...interface{}) {
 	if false {
 		_ = fmt.Sprintf(format, args...)
 	}
-	if verbosity >= errorLog {
-		log.Printf("[ERROR] "+format, args...)
-	}
+	logf(errorLog, nil, format, args...)
 }
 
 // Fatal outputs a formatted error message and calls os.Exit(1).
@@ -113,5 +290,63 @@ func Fatal(format string, args ...interface{}) {
 	if false {
 		_ = fmt.Sprintf(format, args...)
 	}
-	log.Fatalf("[FATAL] "+format, args...)
+	logf(fatalLog, nil, format, args...)
+	os.Exit(1)
+}
+
+// Entry is a logger with fields attached to its messages in JSON format
+type Entry struct {
+	fields Fields
+	fn     func() Fields
+}
+
+// With returns a logger attaching the given fields to its messages, empty values are ignored.
+func With(f Fields) *Entry {
+	return &Entry{fields: f}
+}
+
+// WithFunc returns a logger attaching the fields returned by f to its messages, empty values are ignored.
+// f is only called when a message is output in JSON format.
+func WithFunc(f func() Fields) *Entry {
+	return &Entry{fn: f}
+}
+
+// get returns the fields of the entry
+func (e *Entry) get() Fields {
+	if e.fn != nil {
+		return e.fn()
+	}
+	return e.fields
+}
+
+// Debug outputs a formatted log message.
+func (e *Entry) Debug(format string, args ...interface{}) {
+	if false {
+		_ = fmt.Sprintf(format, args...)
+	}
+	logf(debugLog, e, format, args...)
+}
+
+// Info outputs a formatted log message.
+func (e *Entry) Info(format string, args ...interface{}) {
+	if false {
+		_ = fmt.Sprintf(format, args...)
+	}
+	logf(infoLog, e, format, args...)
+}
+
+// Warning outputs a formatted warning message.
+func (e *Entry) Warning(format string, args ...interface{}) {
+	if false {
+		_ = fmt.Sprintf(format, args...)
+	}
+	logf(warningLog, e, format, args...)
+}
+
+// Error outputs a formatted error message.
+func (e *Entry) Error(format string, args ...interface{}) {
+	if false {
+		_ = fmt.Sprintf(format, args...)
+	}
+	logf(errorLog, e, format, args...)
 }
@@ -32,6 +32,7 @@ import javax.ws.rs.Consumes;
 import javax.ws.rs.DELETE;
 import javax.ws.rs.GET;
 import javax.ws.rs.HEAD;
+import javax.ws.rs.HeaderParam;
 import javax.ws.rs.POST;
 import javax.ws.rs.Path;
 import javax.ws.rs.PathParam;
@@ -40,6 +41,7 @@ import javax.ws.rs.core.MediaType;
 import javax.ws.rs.core.Response;
 import javax.ws.rs.core.Response.Status;
 
+import com.ibm.research.kar.Kar;
 import com.ibm.research.kar.KarConfig;
 import com.ibm.research.kar.KarRest;
 import com.ibm.research.kar.actor.ActorInstance;
@@ -56,7 +58,8 @@ public class ActorRuntimeResource {
 	@GET
 	@Path("{type}/{id}")
 	@Produces(MediaType.TEXT_PLAIN)
-	public Response getActor(@PathParam("type") String type, @PathParam("id") String id) {
+	public Response getActor(@PathParam("type") String type, @PathParam("id") String id,
+			@HeaderParam(KarRest.KAR_CALL_CHAIN) String chain) {
 		if (actorManager.getActor(type, id) != null) {
 			// Already exists; nothing to do.
 			return Response.status(Response.Status.OK).build();
@@ -72,11 +75,14 @@ public class ActorRuntimeResource {
 		try {
 			MethodHandle activate = this.actorManager.getActorActivateMethod(type);
 			if (activate != null) {
+				Kar.setCallChain(chain);
 				activate.invoke(actorObj);
 			}
 			return Response.status(Response.Status.CREATED).entity("Created " + type + " actor " + id).build();
 		} catch (Throwable t) {
 			return Response.status(Response.Status.BAD_REQUEST).entity(t.toString()).build();
+		} finally {
+			Kar.setCallChain(null);
 		}
 	}
 
@@ -115,7 +121,8 @@ public class ActorRuntimeResource {
 	@Consumes(KarRest.KAR_ACTOR_JSON)
 	@Produces(KarRest.KAR_ACTOR_JSON)
 	public Response invokeActorMethod(@PathParam("type") String type, @PathParam("id") String id,
-			@PathParam("sessionid") String sessionid, @PathParam("path") String path, JsonArray args) {
+			@PathParam("sessionid") String sessionid, @PathParam("path") String path,
+			@HeaderParam(KarRest.KAR_CALL_CHAIN) String chain, JsonArray args) {
 
 		ActorInstance actorObj = this.actorManager.getActor(type, id);
 		if (actorObj == null) {
@@ -137,6 +144,9 @@ public class ActorRuntimeResource {
 			actuals[i + 1] = args.get(i);
 		}
 
+		// pass the call chain to nested actor calls
+		Kar.setCallChain(chain);
+
 		try {
 			Object result = actorMethod.invokeWithArguments(actuals);
 			if (result == null && actorMethod.type().returnType().equals(Void.TYPE)) {
@@ -171,6 +181,8 @@ public class ActorRuntimeResource {
 			}
 			ro.add("stack", sw.toString());
 			return Response.status(Response.Status.OK).type(KarRest.KAR_ACTOR_JSON).entity(ro.build()).build();
+		} finally {
+			Kar.setCallChain(null);
 		}
 	}
 }
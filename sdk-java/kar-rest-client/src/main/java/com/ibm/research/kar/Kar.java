@@ -58,6 +58,9 @@ public class Kar {
 
 	private static KarRest karClient = buildRestClient();
 
+	// call chain of the actor invocation running on the current thread if any
+	private static final ThreadLocal<String> callChain = new ThreadLocal<>();
+
 	public Kar() {
 	}
 
@@ -68,6 +71,18 @@ public class Kar {
 		Kar.karClient = client;
 	}
 
+	/*
+	 * Set the call chain of the actor invocation running on the current thread
+	 * (used by the actor runtime, null to clear)
+	 */
+	public static void setCallChain(String chain) {
+		if (chain == null) {
+			callChain.remove();
+		} else {
+			callChain.set(chain);
+		}
+	}
+
 	/*
 	 * Generate REST client (used when injection not possible, e.g. tests)
 	 */
@@ -501,7 +516,7 @@ public class Kar {
 				throws ActorMethodNotFoundException, ActorMethodInvocationException {
 			try {
 				Response response = karClient.actorCall(actor.getType(), actor.getId(), path, caller.getSession(),
-						packArgs(args));
+						callChain.get(), packArgs(args));
 				return callProcessResponse(response);
 			} catch (WebApplicationException e) {
 				if (e.getResponse() != null && e.getResponse().getStatus() == 404) {
@@ -529,7 +544,7 @@ public class Kar {
 		public static JsonValue call(String session, ActorRef actor, String path, JsonValue... args)
 				throws ActorMethodNotFoundException, ActorMethodInvocationException, ActorMethodTimeoutException {
 			try {
-				Response response = karClient.actorCall(actor.getType(), actor.getId(), path, session, packArgs(args));
+				Response response = karClient.actorCall(actor.getType(), actor.getId(), path, session, callChain.get(), packArgs(args));
 				return callProcessResponse(response);
 			} catch (WebApplicationException e) {
 				if (e.getResponse() != null && e.getResponse().getStatus() == 404) {
@@ -556,7 +571,8 @@ public class Kar {
 		public static JsonValue call(ActorRef actor, String path, JsonValue... args)
 				throws ActorMethodNotFoundException, ActorMethodInvocationException {
 			try {
-				Response response = karClient.actorCall(actor.getType(), actor.getId(), path, null, packArgs(args));
+				Response response = karClient.actorCall(actor.getType(), actor.getId(), path, null, callChain.get(),
+						packArgs(args));
 				return callProcessResponse(response);
 			} catch (WebApplicationException e) {
 				if (e.getResponse() != null && e.getResponse().getStatus() == 404) {
@@ -583,7 +599,7 @@ public class Kar {
 		 */
 		public static CompletionStage<JsonValue> callAsync(ActorRef actor, String path, JsonValue... args) {
 			CompletionStage<Response> cr = karClient.actorCallAsync(actor.getType(), actor.getId(), path, null,
-					packArgs(args));
+					callChain.get(), packArgs(args));
 			return cr.thenApply(r -> callProcessResponse(r));
 		}
 
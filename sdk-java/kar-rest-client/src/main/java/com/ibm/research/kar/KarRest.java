@@ -25,6 +25,7 @@ import javax.ws.rs.Consumes;
 import javax.ws.rs.DELETE;
 import javax.ws.rs.GET;
 import javax.ws.rs.HEAD;
+import javax.ws.rs.HeaderParam;
 import javax.ws.rs.OPTIONS;
 import javax.ws.rs.PATCH;
 import javax.ws.rs.POST;
@@ -51,6 +52,9 @@ public interface KarRest extends AutoCloseable {
 	public final static String KAR_ACTOR_JSON = "application/kar+json";
 	public final static MediaType KAR_ACTOR_JSON_TYPE = new MediaType("application", "kar+json");
 
+	// header carrying the call chain of an actor invocation, used by the sidecar for deadlock detection
+	public final static String KAR_CALL_CHAIN = "Kar-Call-Chain";
+
 	/*
 	 * Services
 	 */
@@ -183,7 +187,7 @@ public interface KarRest extends AutoCloseable {
 	@Consumes(KAR_ACTOR_JSON)
 	@Produces(KAR_ACTOR_JSON)
 	public Response actorCall(@PathParam("type") String type, @PathParam("id") String id, @PathParam("path") String path,
-			@QueryParam("session") String session, JsonArray args);
+			@QueryParam("session") String session, @HeaderParam(KAR_CALL_CHAIN) String chain, JsonArray args);
 
 	// synchronous actor invocation: returns invocation result
 	@POST
@@ -192,7 +196,8 @@ public interface KarRest extends AutoCloseable {
 	@Consumes(KAR_ACTOR_JSON)
 	@Produces(KAR_ACTOR_JSON)
 	public CompletionStage<Response> actorCallAsync(@PathParam("type") String type, @PathParam("id") String id,
-			@PathParam("path") String path, @QueryParam("session") String session, JsonArray args);
+			@PathParam("path") String path, @QueryParam("session") String session, @HeaderParam(KAR_CALL_CHAIN) String chain,
+			JsonArray args);
 
 	//
 	// Actor Reminder operations
@@ -14,6 +14,7 @@
  * limitations under the License.
  */
 
+const { AsyncLocalStorage } = require('async_hooks')
 const express = require('express')
 const http2 = require('http2')
 const morgan = require('morgan') // for logging http requests and responses
@@ -26,11 +27,16 @@ if (!process.env.KAR_RUNTIME_PORT) {
 
 const session = http2.connect(`http://localhost:${process.env.KAR_RUNTIME_PORT}`)
 
+// call chain of the current actor invocation if any, passed back to the sidecar for deadlock detection
+const callChain = new AsyncLocalStorage()
+
 // assumes utf8
 function rawFetch (path, { method, headers, body } = {}) {
   const obj = { ':path': path }
   if (method) obj[':method'] = method
   Object.assign(obj, headers)
+  const chain = callChain.getStore()
+  if (chain) obj['kar-call-chain'] = chain
   return new Promise((resolve, reject) => {
     const req = session.request(obj)
     req.setEncoding('utf8')
@@ -278,7 +284,7 @@ function actorRuntime (actors) {
     if (table[req.params.type] && table[req.params.type][req.params.id]) {
       return res.status(200).type('text/plain').send('existing instance')
     }
-    return Promise.resolve()
+    return callChain.run(req.get('Kar-Call-Chain'), () => Promise.resolve()
       .then(_ => {
         table[req.params.type] = table[req.params.type] || {}
         const actor = new Actor(req.params.id)
@@ -289,7 +295,7 @@ function actorRuntime (actors) {
         if (typeof table[req.params.type][req.params.id].activate === 'function') return table[req.params.type][req.params.id].activate()
       })
       .then(_ => res.sendStatus(201)) // Created
-      .catch(next)
+      .catch(next))
   })
 
   // actor deactivation route
@@ -315,7 +321,7 @@ function actorRuntime (actors) {
     const actor = (table[req.params.type] || {})[req.params.id]
     if (actor == null) return res.status(404).type('text/plain').send(`no actor with type ${req.params.type} and id ${req.params.id}`)
     if (!(req.params.method in actor)) return res.status(404).type('text/plain').send(`no ${req.params.method} in actor with type ${req.params.type} and id ${req.params.id}`)
-    return Promise.resolve()
+    return callChain.run(req.get('Kar-Call-Chain'), () => Promise.resolve()
       .then(_ => {
         // NOTE: session intentionally not cleared before return (could be nested call in same session)
         actor.kar.session = req.params.session
@@ -329,7 +335,7 @@ function actorRuntime (actors) {
           return res.status(200).type('application/kar+json').send({ value })
         }
       })
-      .catch(next)
+      .catch(next))
   })
 
   // actor type validation route